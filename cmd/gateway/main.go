@@ -1,7 +1,122 @@
 package main
 
-import "github.com/busgo/elsa/pkg/log"
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/busgo/elsa/internal/gateway"
+	"github.com/busgo/elsa/pkg/client"
+	"github.com/busgo/elsa/pkg/etcd"
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/security"
+)
+
+const (
+	defaultRegistryServerEndpoint = "127.0.0.1:8005"
+	defaultShutdownTimeout        = time.Second * 30
+	defaultLimitPrefix            = "/elsa/gateway/limits"
+)
 
 func main() {
-	log.Infof("gateway...")
+
+	address := flag.String("address", gateway.DefaultAddress, "the gateway listen address")
+	serverEndpoints := flag.String("registry_server_endpoints", defaultRegistryServerEndpoint, "the registry server endpoints,if multi server endpoint please use ',' split")
+	segment := flag.String("segment", client.DefaultSegment, "the default segment of the registry stub")
+	timeout := flag.Duration("timeout", gateway.DefaultTimeout, "the default timeout of the backend calls")
+	routeFile := flag.String("routes", "", "the route file of the gateway, reloaded when changed")
+	reloadInterval := flag.Duration("reload_interval", gateway.DefaultReloadInterval, "the interval to check the route file changed")
+	discoverInterval := flag.Duration("discover_interval", gateway.DefaultDiscoverInterval, "the interval to discover the routes of the google.api.http rules")
+	adminAddress := flag.String("admin_address", "", "the admin api listen address like :8081, empty means disabled")
+	authConfig := flag.String("auth_config", "", "the auth config file of the api keys, the jwks files and the hmac keys")
+	clientIPHeader := flag.String("client_ip_header", "", "the header of the client ip set by the proxy like X-Forwarded-For")
+	etcdEndpoints := flag.String("etcd_endpoints", "", "the etcd endpoints sharing the rate limits and the quotas,if multi endpoint please use ',' split, empty means local limits")
+	etcdUserName := flag.String("etcd_username", "", "the etcd user name")
+	etcdPassword := flag.String("etcd_password", "", "the etcd password")
+	accessLog := flag.Bool("access_log", true, "log the accesses of the gateway")
+	accessLogSampleRate := flag.Float64("access_log_sample_rate", 1, "the rate of the successful requests logged in [0,1], the failed and the slow requests are always logged")
+	slowThreshold := flag.Duration("slow_threshold", time.Second, "the requests slower than the threshold are always logged, 0 means no slow requests")
+	metricsAddress := flag.String("metrics_address", "", "the metrics listen address like :9090, empty means disabled")
+	cacheSize := flag.Int64("cache_size", gateway.DefaultCacheSize, "the max bytes of the cached responses of the routes with the cache policy")
+	cacheEntrySize := flag.Int64("cache_entry_size", gateway.DefaultCacheEntrySize, "the responses larger than the size are not cached")
+	pingInterval := flag.Duration("ping_interval", gateway.DefaultPingInterval, "the interval to ping the websocket clients, the websocket is closed when the pong is missed twice")
+	defaultRoute := flag.Bool("default_route", false, "enable the route POST /{segment}/{service}/{method} calling the services without the routes")
+	defaultRouteServices := flag.String("default_route_services", "", "the services allowed by the default route like dev/com.busgo.trade.proto.TradeService,if multi service please use ',' split, empty means all the services")
+	maxConns := flag.Int("max_conns", gateway.DefaultMaxConns, "the max client conns of the backend services, the least recently used idle conn is closed when full")
+	connIdleTimeout := flag.Duration("conn_idle_timeout", gateway.DefaultConnIdleTimeout, "the client conns of the backend services unused for the timeout are closed")
+	tlsCert := flag.String("tls_cert", "", "the tls client certificate file,enable the mutual tls with the backends and the registry if set")
+	tlsKey := flag.String("tls_key", "", "the tls private key file")
+	tlsCA := flag.String("tls_ca", "", "the tls CA file,enable the tls with the backends and the registry if set, the system roots are used if empty")
+	tlsServerName := flag.String("tls_server_name", "", "the server name to verify the certificates, default is the service name of the backend")
+	flag.Parse()
+
+	stubOptions := make([]client.RegistryStubOption, 0)
+	var tlsConfig *security.TLSConfig
+	if *tlsCert != "" || *tlsCA != "" {
+		tlsConfig = &security.TLSConfig{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA, ServerName: *tlsServerName}
+		stubOptions = append(stubOptions, client.WithRegistryTLS(*tlsConfig))
+	}
+	stub, err := client.NewRegistryStub(*segment, strings.Split(*serverEndpoints, ","), stubOptions...)
+	if err != nil {
+		log.Errorf("create the registry stub fail:%#v", err)
+		panic(err)
+	}
+	options := []gateway.Option{gateway.WithAddress(*address), gateway.WithTimeout(*timeout), gateway.WithRouteFile(*routeFile, *reloadInterval),
+		gateway.WithDiscoverInterval(*discoverInterval), gateway.WithAdminAddress(*adminAddress), gateway.WithClientIPHeader(*clientIPHeader), gateway.WithMetrics(*metricsAddress),
+		gateway.WithCache(gateway.CacheConfig{MaxSize: *cacheSize, MaxEntrySize: *cacheEntrySize}), gateway.WithPingInterval(*pingInterval),
+		gateway.WithConnPool(*maxConns, *connIdleTimeout)}
+	if *defaultRoute {
+		services := make([]string, 0)
+		if *defaultRouteServices != "" {
+			services = strings.Split(*defaultRouteServices, ",")
+		}
+		options = append(options, gateway.WithDefaultRoute(services...))
+	}
+	if tlsConfig != nil {
+		options = append(options, gateway.WithTLS(*tlsConfig))
+	}
+	if *accessLog {
+		options = append(options, gateway.WithAccessLog(gateway.AccessLogConfig{SampleRate: *accessLogSampleRate, SlowThreshold: *slowThreshold}))
+	}
+	if *authConfig != "" {
+		config, err := gateway.LoadAuthConfig(*authConfig)
+		if err != nil {
+			log.Errorf("load the auth config fail:%#v", err)
+			panic(err)
+		}
+		options = append(options, gateway.WithAuth(config))
+	}
+	if *etcdEndpoints != "" {
+		cli, err := etcd.NewEtcdClient(strings.Split(*etcdEndpoints, ","), *etcdUserName, *etcdPassword)
+		if err != nil {
+			log.Errorf("create the etcd client fail:%#v", err)
+			panic(err)
+		}
+		options = append(options, gateway.WithCounterStore(gateway.NewEtcdCounterStore(cli, defaultLimitPrefix)))
+	}
+	g, err := gateway.NewGateway(stub, options...)
+	if err != nil {
+		log.Errorf("create the gateway fail:%#v", err)
+		panic(err)
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		if err := g.Shutdown(ctx); err != nil {
+			log.Warnf("shutdown the gateway fail:%s", err.Error())
+		}
+	}()
+
+	if err = g.Start(); err != nil {
+		log.Errorf("start the gateway fail:%#v", err)
+		panic(err)
+	}
 }
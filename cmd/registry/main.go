@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/busgo/elsa/internal/registry/server"
 	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/security"
+	"github.com/busgo/elsa/pkg/tracing"
 	"os"
 	"strings"
 )
@@ -13,6 +16,8 @@ const (
 	defaultRegistryServerEndpoint = "127.0.0.1:8005"
 	defaultVersion                = "1.0"
 	defaultLogFile                = "."
+	defaultTraceFile              = "elsa-trace.json"
+	defaultTraceEndpoint          = "127.0.0.1:4317"
 )
 
 func main() {
@@ -21,14 +26,55 @@ func main() {
 	version := flag.String("version", "", "print elsa micro service framework version")
 	v := flag.String("v", "", "print elsa micro service framework version")
 	flag.String("logfile", defaultLogFile, "set log file path")
+	traceExporter := flag.String("trace_exporter", tracing.NoneExporter, "the trace exporter none,stdout,file or otlp")
+	traceFile := flag.String("trace_file", defaultTraceFile, "the trace file path of the file exporter writing the otlp json lines")
+	traceEndpoint := flag.String("trace_endpoint", defaultTraceEndpoint, "the otlp grpc collector endpoint of the otlp exporter")
+	tlsCert := flag.String("tls_cert", "", "the tls certificate file,enable the tls if set")
+	tlsKey := flag.String("tls_key", "", "the tls private key file")
+	tlsCA := flag.String("tls_ca", "", "the tls CA file,enable the mutual tls if set")
+	tlsServerName := flag.String("tls_server_name", "", "the server name to verify the peer registry server certificate")
+	authConfig := flag.String("auth_config", "", "the auth json config file of the tokens and acl rules,enable the auth if set")
+	peerToken := flag.String("peer_token", "", "the token attached to the sync requests of the peer registry servers")
 	flag.Parse()
 	if *version != "" || *v != "" {
 		fmt.Printf("elsa micro service framework %s", defaultVersion)
 		os.Exit(0)
 	}
 
+	shutdown, err := tracing.Init(tracing.Config{
+		ServiceName: "elsa-registry",
+		Exporter:    *traceExporter,
+		FilePath:    *traceFile,
+		Endpoint:    *traceEndpoint,
+	})
+	if err != nil {
+		log.Errorf("init the tracing fail:%#v", err)
+		panic(err)
+	}
+	defer shutdown(context.Background())
+
 	endpoints := strings.Split(*serverEndpoints, ",")
-	s, err := server.NewRegistryServerWithEndpoints(endpoints)
+	options := make([]server.Option, 0)
+	if *tlsCert != "" {
+		options = append(options, server.WithTLS(security.TLSConfig{
+			CertFile:   *tlsCert,
+			KeyFile:    *tlsKey,
+			CAFile:     *tlsCA,
+			ServerName: *tlsServerName,
+		}))
+	}
+	if *authConfig != "" {
+		config, err := server.LoadAuthConfig(*authConfig)
+		if err != nil {
+			log.Errorf("load the auth config:%s fail:%#v", *authConfig, err)
+			panic(err)
+		}
+		options = append(options, server.WithAuth(config))
+	}
+	if *peerToken != "" {
+		options = append(options, server.WithPeerCredentials(security.NewTokenCredentials(*peerToken, *tlsCert != "")))
+	}
+	s, err := server.NewRegistryServerWithEndpoints(endpoints, options...)
 
 	if err != nil {
 		log.Error("create registry server fail:%#v", err)
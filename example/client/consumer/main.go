@@ -5,7 +5,9 @@ import (
 	"github.com/busgo/elsa/example/client/proto/pb"
 	"github.com/busgo/elsa/pkg/client"
 	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"time"
 )
 
@@ -16,7 +18,8 @@ func main() {
 		panic(err)
 	}
 
-	elsaServer, err := client.NewElsaServer(client.WithServerPort(8002), client.WithRegistryStub(stub), client.WithName("consumer"))
+	elsaServer, err := client.NewElsaServer(client.WithServerPort(8002), client.WithRegistryStub(stub), client.WithName("consumer"),
+		client.WithDialOptions(grpc.WithChainUnaryInterceptor(middleware.RequestIdUnaryClientInterceptor, middleware.LoggingUnaryClientInterceptor)))
 	if err != nil {
 		panic(err)
 	}
@@ -28,7 +31,11 @@ func main() {
 	// build the stub
 	cli := elsaServer.BuildStub(pb.TradeService_ServiceDesc.ServiceName, func(cc *grpc.ClientConn) interface{} {
 		return pb.NewTradeServiceClient(cc)
-	}).(pb.TradeServiceClient)
+	}, client.WithDefaultTimeout(time.Second), client.WithRetryPolicy(client.RetryPolicy{
+		MaxAttempts:    3,
+		RetryableCodes: []codes.Code{codes.Unavailable},
+		Budget:         &client.RetryBudget{MaxTokens: 10, TokenRatio: 0.1},
+	})).(pb.TradeServiceClient)
 
 	go func() {
 		for {
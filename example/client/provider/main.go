@@ -5,6 +5,7 @@ import (
 	"github.com/busgo/elsa/example/client/proto/pb"
 	"github.com/busgo/elsa/pkg/client"
 	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/middleware"
 	"google.golang.org/grpc"
 )
 
@@ -26,7 +27,8 @@ func main() {
 	}
 	elsaServer, err := client.NewElsaServer(client.WithName("trade"),
 		client.WithServerPort(8001),
-		client.WithRegistryStub(stub))
+		client.WithRegistryStub(stub),
+		client.WithUnaryInterceptors(middleware.RecoveryUnaryServerInterceptor, middleware.RequestIdUnaryServerInterceptor, middleware.LoggingUnaryServerInterceptor))
 	if err != nil {
 		panic(err)
 	}
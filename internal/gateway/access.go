@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/peer"
+)
+
+// the route label of the requests not matching any route
+const unmatchedRoute = "unmatched"
+
+// the access log config
+type AccessLogConfig struct {
+	SampleRate    float64       // the rate of the successful requests logged in [0,1], the failed and the slow requests are always logged
+	SlowThreshold time.Duration // the requests slower than the threshold are slow, 0 means no slow requests
+}
+
+// the access of a request filled by the pipeline
+type access struct {
+	route      string
+	service    string
+	instance   string
+	principal  string
+	sampleRate *float64 // the sample rate of the route overriding the config
+}
+
+type accessKey struct{}
+
+// the access of the request, nil if the access is not recorded
+func accessFromContext(ctx context.Context) *access {
+	a, _ := ctx.Value(accessKey{}).(*access)
+	return a
+}
+
+// record the route and the service of the call
+func (c call) recordAccess(r *http.Request) {
+
+	a := accessFromContext(r.Context())
+	if a == nil {
+		return
+	}
+	a.route = c.routeName()
+	a.service = c.service
+	if c.route != nil {
+		a.sampleRate = c.route.logSampleRate
+	}
+}
+
+// record the principal of the request
+func recordPrincipal(r *http.Request, principal *Principal) {
+	if a := accessFromContext(r.Context()); a != nil && principal != nil {
+		a.principal = principal.Subject
+	}
+}
+
+// record the backend instance of the peer
+func recordInstance(r *http.Request, p *peer.Peer) {
+	if a := accessFromContext(r.Context()); a != nil && p != nil && p.Addr != nil {
+		a.instance = p.Addr.String()
+	}
+}
+
+// the response writer recording the status and the written bytes
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessWriter) Write(content []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(content)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+// flush the streaming responses
+func (aw *accessWriter) Flush() {
+	if flusher, ok := aw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// hijack the connection of the websocket
+func (aw *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := aw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can not be hijacked")
+	}
+	aw.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// observe the request by the metrics and the access log
+func (g *Gateway) observe(aw *accessWriter, r *http.Request, a *access, start time.Time) {
+
+	latency := time.Since(start)
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	route := a.route
+	if route == "" {
+		route = unmatchedRoute
+	}
+	if g.opts.metricsAddress != "" {
+		gatewayRequests.WithLabelValues(route, a.service, strconv.Itoa(aw.status)).Inc()
+		gatewayLatency.WithLabelValues(route, a.service).Observe(latency.Seconds())
+		gatewayResponseSize.WithLabelValues(route, a.service).Observe(float64(aw.bytes))
+	}
+
+	config := g.opts.accessLog
+	if config == nil {
+		return
+	}
+	slow := config.SlowThreshold > 0 && latency >= config.SlowThreshold
+	if aw.status < http.StatusBadRequest && !slow {
+		rate := config.SampleRate
+		if a.sampleRate != nil {
+			rate = *a.sampleRate
+		}
+		if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+			return
+		}
+	}
+	fields := []interface{}{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", route,
+		"service", a.service,
+		"instance", a.instance,
+		"status", aw.status,
+		"latency", latency.Seconds(),
+		"requestBytes", r.ContentLength,
+		"responseBytes", aw.bytes,
+		"principal", a.principal,
+		"client", clientIP(r, g.opts.clientIPHeader),
+	}
+	switch {
+	case aw.status >= http.StatusInternalServerError:
+		log.Errorw("gateway access", fields...)
+	case aw.status >= http.StatusBadRequest || slow:
+		log.Warnw("gateway access", fields...)
+	default:
+		log.Infow("gateway access", fields...)
+	}
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGateway_Access(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routeFile := filepath.Join(dir, "routes.json")
+	rate := 0.0
+	writeRoutes(t, routeFile, RouteConfig{Routes: []Route{
+		{Name: "access-fetch", Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch", LogSampleRate: &rate},
+	}})
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour), WithMetrics("127.0.0.1:0"), WithAccessLog(AccessLogConfig{SampleRate: 1}))
+	defer closeFunc()
+
+	// the pipeline records the route, the backend instance and the body size
+	a := &access{}
+	aw := &accessWriter{ResponseWriter: httptest.NewRecorder()}
+	r := httptest.NewRequest(http.MethodGet, "/v1/dev/services/trade", nil)
+	g.serve(aw, r.WithContext(context.WithValue(r.Context(), accessKey{}, a)))
+	if aw.status != http.StatusOK || aw.bytes == 0 || a.route != "access-fetch" || a.service != "com.busgo.registry.proto.RegistryService" || a.instance == "" {
+		t.Fatalf("the access is not recorded:%+v %d %d", a, aw.status, aw.bytes)
+	}
+	if a.sampleRate == nil || *a.sampleRate != 0 {
+		t.Fatalf("the sample rate of the route must be recorded")
+	}
+
+	serve(g, http.MethodGet, "/v1/dev/services/trade", "")
+	serve(g, http.MethodGet, "/v1/dev/services/trade?unknown=1", "")
+	serve(g, http.MethodPost, "/missing", strings.Repeat("x", 10))
+	cases := []struct {
+		route  string
+		status string
+		count  float64
+	}{
+		{"access-fetch", "200", 2},
+		{unmatchedRoute, "404", 1},
+	}
+	for _, c := range cases {
+		service := "com.busgo.registry.proto.RegistryService"
+		if c.route == unmatchedRoute {
+			service = ""
+		}
+		if count := testutil.ToFloat64(gatewayRequests.WithLabelValues(c.route, service, c.status)); count != c.count {
+			t.Fatalf("the requests of the route:%s with the status:%s must be %v but %v", c.route, c.status, c.count, count)
+		}
+	}
+	// the gateway with the metrics registers the gateway metrics once
+	other, err := NewGateway(nil, WithMetrics("127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Shutdown(context.Background())
+	if count, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "elsa_gateway_requests_total"); err != nil || count == 0 {
+		t.Fatalf("the gateway metrics must be registered but %d:%v", count, err)
+	}
+}
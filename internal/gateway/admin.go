@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the admin api of the gateway
+func (g *Gateway) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/routes", g.serveRoutes)
+	mux.HandleFunc("/usage", g.serveUsage)
+	mux.HandleFunc("/cache", g.serveCache)
+	return mux
+}
+
+// GET /routes reports the routes and the problems of the route file and the annotated services
+func (g *Gateway) serveRoutes(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, g.routes.report())
+}
+
+// GET /usage?route=name reports the usages of the rate limits and the daily quotas
+func (g *Gateway) serveUsage(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, g.limiter.report(r.URL.Query().Get("route")))
+}
+
+// GET /cache reports the stats of the response cache, DELETE /cache?route=name purges the responses of the route or all the responses
+func (g *Gateway) serveCache(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, g.cache.stats())
+	case http.MethodDelete:
+		route := r.URL.Query().Get("route")
+		purged := g.cache.purge(route)
+		log.Infof("the gateway purge %d cached responses of the route:%s", purged, route)
+		writeJSON(w, map[string]int{"purged": purged})
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+	}
+}
+
+// write the value as the json body
+func writeJSON(w http.ResponseWriter, value interface{}) {
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("marshal the gateway admin response fail:%s", err.Error())
+		writeError(w, status.Errorf(codes.Internal, "marshal the response fail:%s", err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// the source of the routes
+const (
+	FileSource       = "file"
+	AnnotationSource = "annotation"
+)
+
+// split the annotated service like dev/com.busgo.trade.proto.TradeService, the segment is optional
+func splitService(name string) (string, string) {
+	if index := strings.Index(name, "/"); index >= 0 {
+		return name[:index], name[index+1:]
+	}
+	return "", name
+}
+
+// discover the routes of the google.api.http rules of the service by the server reflection
+func (g *Gateway) discover(segment, service string) ([]Route, []error, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.opts.timeout)
+	defer cancel()
+	b, err := g.conns.get(segment, service)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer g.conns.release(b)
+	sd, err := b.source.refresh(ctx, service)
+	if err != nil {
+		return nil, nil, err
+	}
+	routes, problems := httpRoutes(segment, sd.desc)
+	return routes, problems, nil
+}
+
+// the routes of the google.api.http rules of the service methods
+func httpRoutes(segment string, desc protoreflect.ServiceDescriptor) ([]Route, []error) {
+
+	routes := make([]Route, 0)
+	problems := make([]error, 0)
+	methods := desc.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		options, ok := md.Options().(*descriptorpb.MethodOptions)
+		if !ok || options == nil || !proto.HasExtension(options, annotations.E_Http) {
+			continue
+		}
+		rule, ok := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		name := fmt.Sprintf("/%s/%s", desc.FullName(), md.Name())
+		rules := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+		for index, r := range rules {
+			ruleName := name
+			if index > 0 {
+				ruleName = fmt.Sprintf("%s#%d", name, index)
+			}
+			// the additional bindings must not be nested
+			if index > 0 && len(r.GetAdditionalBindings()) > 0 {
+				problems = append(problems, fmt.Errorf("the http rule of %s is unsupported:the nested additional bindings", ruleName))
+				continue
+			}
+			route, err := httpRoute(segment, md, r)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("the http rule of %s is unsupported:%s", ruleName, err.Error()))
+				continue
+			}
+			route.Name = ruleName
+			routes = append(routes, route)
+		}
+	}
+	return routes, problems
+}
+
+// the route of the http rule, the field paths are checked with the method descriptor
+func httpRoute(segment string, md protoreflect.MethodDescriptor, rule *annotations.HttpRule) (Route, error) {
+
+	route := Route{
+		Segment:      segment,
+		Service:      string(md.Parent().FullName()),
+		RPC:          string(md.Name()),
+		Body:         rule.GetBody(),
+		ResponseBody: rule.GetResponseBody(),
+		Source:       AnnotationSource,
+	}
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		route.Method, route.Path = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		route.Method, route.Path = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		route.Method, route.Path = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		route.Method, route.Path = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		route.Method, route.Path = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		route.Method, route.Path = strings.ToUpper(pattern.Custom.GetKind()), pattern.Custom.GetPath()
+	default:
+		return route, fmt.Errorf("the pattern is missing")
+	}
+	if route.Method == "" {
+		return route, fmt.Errorf("the custom kind is missing")
+	}
+	if md.IsStreamingClient() {
+		return route, fmt.Errorf("the client streaming method")
+	}
+
+	template, err := parseTemplate(route.Path)
+	if err != nil {
+		return route, err
+	}
+	for _, v := range template.variables {
+		if !hasField(md.Input(), v.field) {
+			return route, fmt.Errorf("the path variable:%s not found in %s", v.field, md.Input().FullName())
+		}
+	}
+	if route.Body != "" && route.Body != bodyWildcard && !hasField(md.Input(), route.Body) {
+		return route, fmt.Errorf("the body field:%s not found in %s", route.Body, md.Input().FullName())
+	}
+	if route.ResponseBody != "" && findField(md.Output(), route.ResponseBody) == nil {
+		return route, fmt.Errorf("the response body field:%s not found in %s", route.ResponseBody, md.Output().FullName())
+	}
+	return route, nil
+}
@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// build the order service with the http rules
+func newOrderService(t *testing.T, rules map[string]*annotations.HttpRule) protoreflect.ServiceDescriptor {
+
+	field := func(name string, number int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}
+	}
+	service := &descriptorpb.ServiceDescriptorProto{Name: proto.String("OrderService")}
+	for _, name := range []string{"GetOrder", "CancelOrder", "Nested"} {
+		options := &descriptorpb.MethodOptions{}
+		if rule, ok := rules[name]; ok {
+			proto.SetExtension(options, annotations.E_Http, rule)
+		}
+		service.Method = append(service.Method, &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".test.OrderRequest"),
+			OutputType: proto.String(".test.Order"),
+			Options:    options,
+		})
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/order.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("OrderRequest"), Field: []*descriptorpb.FieldDescriptorProto{field("id", 1), field("reason", 2)}},
+			{Name: proto.String("Order"), Field: []*descriptorpb.FieldDescriptorProto{field("id", 1), field("state", 2)}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{service},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Services().Get(0)
+}
+
+func TestHttpRoutes(t *testing.T) {
+
+	desc := newOrderService(t, map[string]*annotations.HttpRule{
+		"GetOrder": {
+			Pattern:      &annotations.HttpRule_Get{Get: "/v1/orders/{id}"},
+			ResponseBody: "state",
+			AdditionalBindings: []*annotations.HttpRule{
+				{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{Kind: "head", Path: "/v1/orders/{id}"}}},
+			},
+		},
+		"CancelOrder": {
+			Pattern: &annotations.HttpRule_Post{Post: "/v1/orders/{missing}:cancel"},
+			Body:    "*",
+		},
+		"Nested": {
+			Pattern: &annotations.HttpRule_Post{Post: "/v1/nested"},
+			AdditionalBindings: []*annotations.HttpRule{{
+				Pattern:            &annotations.HttpRule_Post{Post: "/v1/nested/a"},
+				AdditionalBindings: []*annotations.HttpRule{{Pattern: &annotations.HttpRule_Post{Post: "/v1/nested/b"}}},
+			}},
+		},
+	})
+
+	routes, problems := httpRoutes("dev", desc)
+	if len(routes) != 3 || len(problems) != 2 {
+		t.Fatalf("the routes must be 3 and the problems must be 2 but %v and %v", routes, problems)
+	}
+	get := routes[0]
+	if get.Method != http.MethodGet || get.Path != "/v1/orders/{id}" || get.Segment != "dev" || get.Service != "test.OrderService" ||
+		get.RPC != "GetOrder" || get.ResponseBody != "state" || get.Source != AnnotationSource {
+		t.Fatalf("the route is unexpected:%+v", get)
+	}
+	if routes[1].Method != http.MethodHead || routes[1].Name != "/test.OrderService/GetOrder#1" {
+		t.Fatalf("the additional binding is unexpected:%+v", routes[1])
+	}
+	if !strings.Contains(problems[0].Error(), "missing") || !strings.Contains(problems[1].Error(), "nested") {
+		t.Fatalf("the problems are unexpected:%v", problems)
+	}
+}
+
+func TestRouteTable_Discover(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes.json")
+	writeRoutes(t, file, RouteConfig{
+		Routes:   []Route{{Method: http.MethodGet, Path: "/v1/orders/{id}", Service: "test.OrderService", RPC: "FindOrder"}},
+		Services: []string{"dev/test.OrderService", "test.MissingService"},
+	})
+
+	available := true
+	discover := func(segment, service string) ([]Route, []error, error) {
+		if service == "test.MissingService" || !available {
+			return nil, nil, errors.New("unavailable")
+		}
+		desc := newOrderService(t, map[string]*annotations.HttpRule{
+			"GetOrder":    {Pattern: &annotations.HttpRule_Get{Get: "/v1/orders/{id}"}},
+			"CancelOrder": {Pattern: &annotations.HttpRule_Post{Post: "/v1/orders/{id}:cancel"}, Body: "*"},
+		})
+		routes, problems := httpRoutes(segment, desc)
+		return routes, problems, nil
+	}
+	table, err := newRouteTable(file, time.Hour, time.Hour, discover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.close()
+
+	report := table.report()
+	if len(report.Routes) != 2 || len(report.Problems) != 2 {
+		t.Fatalf("the routes must be 2 and the problems must be 2 but %+v", report)
+	}
+	// the route of the file wins the conflict
+	if r, _, _ := table.current().match(http.MethodGet, "/v1/orders/1"); r == nil || r.rpc != "FindOrder" || r.config.Source != FileSource {
+		t.Fatalf("the route of the file must win the conflict")
+	}
+	if r, variables, _ := table.current().match(http.MethodPost, "/v1/orders/1:cancel"); r == nil || r.segment != "dev" || variables["id"] != "1" {
+		t.Fatalf("the annotated route must be matched")
+	}
+
+	// keep the discovered routes when the service is unavailable
+	available = false
+	table.discoverAll()
+	if r, _, _ := table.current().match(http.MethodPost, "/v1/orders/1:cancel"); r == nil {
+		t.Fatalf("the discovered routes must be kept")
+	}
+
+	g := &Gateway{routes: table}
+	recorder := httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/routes", nil))
+	report = RouteReport{}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &report); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("the admin api fail:%d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(report.Routes) != 2 || len(report.Problems) != 3 {
+		t.Fatalf("the report is unexpected:%s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// the header of the api key
+const APIKeyHeader = "X-API-Key"
+
+// the api key of the key file
+type APIKey struct {
+	Key     string            `json:"key"`
+	Subject string            `json:"subject"`
+	Claims  map[string]string `json:"claims,omitempty"`
+}
+
+// the authenticator looking up the api keys from the key file
+type apiKeyAuthenticator struct {
+	file string
+	keys map[string]APIKey
+	sync.RWMutex
+}
+
+// new an api key authenticator with the key file like {"keys":[{"key":"...","subject":"team-a"}]}
+func NewAPIKeyAuthenticator(file string) (Authenticator, error) {
+	a := &apiKeyAuthenticator{file: file, keys: make(map[string]APIKey), RWMutex: sync.RWMutex{}}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuthenticator) Name() string {
+	return APIKeyMethod
+}
+
+func (a *apiKeyAuthenticator) files() []string {
+	return []string{a.file}
+}
+
+// load the api keys
+func (a *apiKeyAuthenticator) load() error {
+
+	content, err := ioutil.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	config := struct {
+		Keys []APIKey `json:"keys"`
+	}{}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("the api key file:%s is invalid:%s", a.file, err.Error())
+	}
+	keys := make(map[string]APIKey, len(config.Keys))
+	for _, key := range config.Keys {
+		if key.Key == "" || key.Subject == "" {
+			return fmt.Errorf("the key and the subject of the api key file:%s are required", a.file)
+		}
+		keys[key.Key] = key
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.keys = keys
+	return nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+
+	value := r.Header.Get(APIKeyHeader)
+	if value == "" {
+		return nil, ErrNoCredentials
+	}
+	a.RLock()
+	key, ok := a.keys[value]
+	a.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("the api key is unknown")
+	}
+	return &Principal{Subject: key.Subject, Method: APIKeyMethod, Claims: key.Claims}, nil
+}
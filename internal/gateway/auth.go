@@ -0,0 +1,270 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// the metadata of the authenticated principal passed to the backends
+const (
+	PrincipalKey   = "x-elsa-principal"
+	AuthMethodKey  = "x-elsa-auth-method"
+	ClaimKeyPrefix = "x-elsa-claim-"
+)
+
+// the built in auth methods
+const (
+	APIKeyMethod = "apikey"
+	JWTMethod    = "jwt"
+	HMACMethod   = "hmac"
+)
+
+// the request has no credentials of the authenticator
+var ErrNoCredentials = errors.New("no credentials")
+
+// the authenticated principal
+type Principal struct {
+	Subject string            // the api key name, the jwt subject or the hmac key id
+	Method  string            // the auth method
+	Claims  map[string]string // the extra attributes like the scope
+}
+
+// the metadata of the principal
+func (p *Principal) metadata() metadata.MD {
+
+	md := metadata.Pairs(PrincipalKey, p.Subject, AuthMethodKey, p.Method)
+	for name, value := range p.Claims {
+		md.Append(ClaimKeyPrefix+strings.ToLower(name), value)
+	}
+	return md
+}
+
+// the authenticator plugin of the auth chain
+type Authenticator interface {
+	// the auth method like apikey
+	Name() string
+	// authenticate the request, ErrNoCredentials if the request has no credentials of the authenticator
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// the authenticator with the files reloaded when changed
+type reloadable interface {
+	files() []string
+	load() error
+}
+
+// the auth requirement of a route
+type AuthRequirement struct {
+	Methods  []string `json:"methods,omitempty"`  // the accepted auth methods, empty means any configured method
+	Optional bool     `json:"optional,omitempty"` // accept the anonymous requests, the principal is passed if authenticated
+	Subjects []string `json:"subjects,omitempty"` // the allowed subject patterns like team-*, empty means any subject
+}
+
+// the config of the built in authenticators
+type AuthConfig struct {
+	APIKeyFile string      `json:"apiKeyFile,omitempty"` // the api key file like {"keys":[{"key":"...","subject":"team-a"}]}
+	JWT        *JWTConfig  `json:"jwt,omitempty"`
+	HMAC       *HMACConfig `json:"hmac,omitempty"`
+}
+
+// load the auth config from the json file
+func LoadAuthConfig(file string) (AuthConfig, error) {
+
+	config := AuthConfig{}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("the auth file:%s is invalid:%s", file, err.Error())
+	}
+	return config, nil
+}
+
+// the built in authenticators of the config
+func (c AuthConfig) authenticators() ([]Authenticator, error) {
+
+	authenticators := make([]Authenticator, 0)
+	if c.APIKeyFile != "" {
+		a, err := NewAPIKeyAuthenticator(c.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if c.JWT != nil {
+		a, err := NewJWTAuthenticator(*c.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if c.HMAC != nil {
+		a, err := NewHMACAuthenticator(*c.HMAC)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	return authenticators, nil
+}
+
+// the auth chain trying the authenticators in order
+type authChain struct {
+	authenticators []Authenticator
+	interval       time.Duration
+	modTimes       map[string]time.Time
+	closedChan     chan bool
+	sync.RWMutex
+}
+
+// new an auth chain watching the files of the authenticators
+func newAuthChain(authenticators []Authenticator, interval time.Duration) *authChain {
+
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	chain := &authChain{
+		authenticators: authenticators,
+		interval:       interval,
+		modTimes:       make(map[string]time.Time),
+		closedChan:     make(chan bool),
+		RWMutex:        sync.RWMutex{},
+	}
+	for _, a := range authenticators {
+		if r, ok := a.(reloadable); ok {
+			for _, file := range r.files() {
+				if info, err := os.Stat(file); err == nil {
+					chain.modTimes[file] = info.ModTime()
+				}
+			}
+		}
+	}
+	if len(chain.modTimes) > 0 {
+		go chain.lookup()
+	}
+	return chain
+}
+
+func (chain *authChain) lookup() {
+
+	ticker := time.NewTicker(chain.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, a := range chain.authenticators {
+				r, ok := a.(reloadable)
+				if !ok || !chain.changed(r.files()) {
+					continue
+				}
+				// keep the old keys when the new files are broken
+				if err := r.load(); err != nil {
+					log.Warnf("reload the %s files:%v fail:%s", a.Name(), r.files(), err.Error())
+					continue
+				}
+				log.Infof("reload the %s files:%v success", a.Name(), r.files())
+			}
+		case <-chain.closedChan:
+			return
+		}
+	}
+}
+
+// check the files changed and record the new mod times
+func (chain *authChain) changed(files []string) bool {
+
+	chain.Lock()
+	defer chain.Unlock()
+	changed := false
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(chain.modTimes[file]) {
+			chain.modTimes[file] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stop watching the files
+func (chain *authChain) close() {
+	if len(chain.modTimes) > 0 {
+		close(chain.closedChan)
+	}
+}
+
+// authenticate the request by the requirement, the nil principal means the anonymous request
+func (chain *authChain) authenticate(r *http.Request, requirement *AuthRequirement) (*Principal, error) {
+
+	if requirement == nil {
+		return nil, nil
+	}
+	var principal *Principal
+	for _, a := range chain.authenticators {
+		if !requirement.accept(a.Name()) {
+			continue
+		}
+		p, err := a.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "the %s credentials are invalid:%s", a.Name(), err.Error())
+		}
+		principal = p
+		break
+	}
+	if principal == nil {
+		if requirement.Optional {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "the credentials are required")
+	}
+	if !requirement.allow(principal.Subject) {
+		return nil, status.Errorf(codes.PermissionDenied, "the subject:%s is not allowed", principal.Subject)
+	}
+	return principal, nil
+}
+
+// the auth method is accepted
+func (requirement *AuthRequirement) accept(method string) bool {
+	if len(requirement.Methods) == 0 {
+		return true
+	}
+	for _, m := range requirement.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// the subject is allowed
+func (requirement *AuthRequirement) allow(subject string) bool {
+	if len(requirement.Subjects) == 0 {
+		return true
+	}
+	for _, pattern := range requirement.Subjects {
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sign the rs256 jwt
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+
+	encode := func(v interface{}) string {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSONFile(t *testing.T, file string, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "jwks.json")
+	writeJSONFile(t, file, map[string]interface{}{"keys": []map[string]string{{
+		"kid": "k1",
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	a, err := NewJWTAuthenticator(JWTConfig{JWKSFiles: []string{file}, Issuer: "elsa", Audience: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func(token string) (*Principal, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			r.Header.Set("Authorization", bearerPrefix+token)
+		}
+		return a.Authenticate(r)
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	principal, err := authenticate(signJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "elsa", "aud": []string{"gateway"}, "exp": exp, "scope": "read"}))
+	if err != nil || principal.Subject != "alice" || principal.Claims["scope"] != "read" {
+		t.Fatalf("the jwt must be valid:%v %+v", err, principal)
+	}
+	if _, err = authenticate(""); err != ErrNoCredentials {
+		t.Fatalf("the missing jwt must be no credentials but %v", err)
+	}
+	invalid := []string{
+		signJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "elsa", "aud": "gateway", "exp": time.Now().Add(-time.Minute).Unix()}),
+		signJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "other", "aud": "gateway", "exp": exp}),
+		signJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "elsa", "aud": "other", "exp": exp}),
+		signJWT(t, key, "k2", map[string]interface{}{"sub": "alice", "iss": "elsa", "aud": "gateway", "exp": exp}),
+		"a.b.c",
+	}
+	for _, token := range invalid {
+		if _, err = authenticate(token); err == nil || err == ErrNoCredentials {
+			t.Fatalf("the jwt:%s must be invalid", token)
+		}
+	}
+}
+
+func TestGateway_Auth(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "keys.json")
+	writeJSONFile(t, keyFile, map[string]interface{}{"keys": []APIKey{
+		{Key: "key-a", Subject: "team-a"},
+		{Key: "key-b", Subject: "ops"},
+	}})
+	hmacFile := filepath.Join(dir, "hmac.json")
+	writeJSONFile(t, hmacFile, map[string]string{"partner": "secret"})
+	routeFile := filepath.Join(dir, "routes.json")
+	writeRoutes(t, routeFile, RouteConfig{
+		Routes: []Route{
+			{Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch",
+				Auth: &AuthRequirement{Methods: []string{APIKeyMethod}, Subjects: []string{"team-*"}}},
+			{Method: http.MethodPost, Path: "/v1/fetch", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch", Body: "*",
+				Auth: &AuthRequirement{Methods: []string{HMACMethod}}},
+		},
+		DefaultAuth: &AuthRequirement{Optional: true},
+	})
+
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour), WithAuth(AuthConfig{APIKeyFile: keyFile, HMAC: &HMACConfig{KeyFile: hmacFile}}))
+	defer closeFunc()
+
+	message := func(recorder *httptest.ResponseRecorder) string {
+		response := struct {
+			Message string `json:"message"`
+		}{}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+		return response.Message
+	}
+	cases := []struct {
+		key       string
+		status    int
+		principal string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"unknown", http.StatusUnauthorized, ""},
+		{"key-b", http.StatusForbidden, ""},
+		{"key-a", http.StatusOK, "team-a"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/v1/dev/services/trade", nil)
+		if c.key != "" {
+			r.Header.Set(APIKeyHeader, c.key)
+		}
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, r)
+		if recorder.Code != c.status || (c.status == http.StatusOK && message(recorder) != c.principal) {
+			t.Fatalf("the api key:%s must be %d with the principal:%s but %d:%s", c.key, c.status, c.principal, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// the hmac signed request, the body is restored for the backend
+	body := `{"segment":"dev","serviceName":"trade"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/fetch", strings.NewReader(body))
+	SignRequest(r, "partner", []byte("secret"), []byte(body))
+	recorder := httptest.NewRecorder()
+	g.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK || message(recorder) != "partner" {
+		t.Fatalf("the hmac request must be authenticated but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	r = httptest.NewRequest(http.MethodPost, "/v1/fetch", strings.NewReader(`{"segment":"dev","serviceName":"other"}`))
+	SignRequest(r, "partner", []byte("secret"), []byte(body))
+	recorder = httptest.NewRecorder()
+	if g.ServeHTTP(recorder, r); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("the tampered body must be unauthorized but %d", recorder.Code)
+	}
+
+	// the default route accepts the anonymous and the authenticated requests
+	r = httptest.NewRequest(http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", strings.NewReader(body))
+	r.Header.Set(APIKeyHeader, "key-b")
+	recorder = httptest.NewRecorder()
+	if g.ServeHTTP(recorder, r); recorder.Code != http.StatusOK || message(recorder) != "ops" {
+		t.Fatalf("the optional auth must pass the principal but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", body); recorder.Code != http.StatusOK || message(recorder) != "" {
+		t.Fatalf("the optional auth must accept the anonymous request but %d:%s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,252 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// the body binds the whole request message
+const bodyWildcard = "*"
+
+// bind the body, the path variables and the query parameters to the request message
+func bind(request *dynamicpb.Message, body []byte, rt *route, variables map[string]string, query url.Values, types *typeResolver) error {
+
+	unmarshal := protojson.UnmarshalOptions{Resolver: types}
+	bound := make(map[string]bool, len(variables))
+	switch {
+	case len(body) == 0 || rt.body == "":
+	case rt.body == bodyWildcard:
+		if err := unmarshal.Unmarshal(body, request); err != nil {
+			return status.Errorf(codes.InvalidArgument, "the request body is invalid:%s", err.Error())
+		}
+	default:
+		if err := bindBody(request, rt.body, body, unmarshal); err != nil {
+			return err
+		}
+		bound[rt.body] = true
+	}
+
+	for fieldPath, value := range variables {
+		if err := setField(request, fieldPath, []string{value}); err != nil {
+			return status.Errorf(codes.InvalidArgument, "the path variable:%s is invalid:%s", fieldPath, err.Error())
+		}
+		bound[fieldPath] = true
+	}
+
+	// the query parameters bind the fields not bound by the body or the path
+	if rt.body == bodyWildcard {
+		return nil
+	}
+	for fieldPath, values := range query {
+		// the unknown parameters like the cache busters are ignored
+		if bound[fieldPath] || !hasField(request.Descriptor(), fieldPath) {
+			continue
+		}
+		if err := setField(request, fieldPath, values); err != nil {
+			return status.Errorf(codes.InvalidArgument, "the query parameter:%s is invalid:%s", fieldPath, err.Error())
+		}
+	}
+	return nil
+}
+
+// bind the body to the field of the request message
+func bindBody(request *dynamicpb.Message, fieldPath string, body []byte, unmarshal protojson.UnmarshalOptions) error {
+
+	parent, fd, err := lookupField(request, fieldPath)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "the body field:%s is invalid:%s", fieldPath, err.Error())
+	}
+	// wrap the body as the field of the parent message and merge it
+	wrapped, err := json.Marshal(map[string]json.RawMessage{fd.JSONName(): body})
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "the request body is invalid:%s", err.Error())
+	}
+	message := parent.New()
+	if err = unmarshal.Unmarshal(wrapped, message.Interface()); err != nil {
+		return status.Errorf(codes.InvalidArgument, "the request body is invalid:%s", err.Error())
+	}
+	proto.Merge(parent.Interface(), message.Interface())
+	return nil
+}
+
+// find the field by the dotted path like order.id, the parent messages are created if missing
+func lookupField(message protoreflect.Message, fieldPath string) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
+
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fd := findField(message.Descriptor(), name)
+		if fd == nil {
+			return nil, nil, fmt.Errorf("the field:%s not found in %s", name, message.Descriptor().FullName())
+		}
+		if i == len(names)-1 {
+			return message, fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, nil, fmt.Errorf("the field:%s is not a message", name)
+		}
+		message = message.Mutable(fd).Message()
+	}
+	return nil, nil, fmt.Errorf("the field path is empty")
+}
+
+// the dotted field path exists in the message
+func hasField(desc protoreflect.MessageDescriptor, fieldPath string) bool {
+
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fd := findField(desc, name)
+		if fd == nil {
+			return false
+		}
+		if i == len(names)-1 {
+			return true
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return false
+		}
+		desc = fd.Message()
+	}
+	return false
+}
+
+// find the field by the proto name or the json name
+func findField(desc protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := desc.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return desc.Fields().ByJSONName(name)
+}
+
+// set the string values to the field, the repeated field accepts the multi values
+func setField(message protoreflect.Message, fieldPath string, values []string) error {
+
+	parent, fd, err := lookupField(message, fieldPath)
+	if err != nil {
+		return err
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("the map field:%s is not supported", fd.Name())
+	}
+	if fd.IsList() {
+		list := parent.Mutable(fd).List()
+		for _, value := range values {
+			v, err := parseValue(fd, value)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	v, err := parseValue(fd, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	parent.Set(fd, v)
+	return nil
+}
+
+// parse the string value by the field kind, the well known wrappers accept the json value
+func parseValue(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("the enum value:%s of %s not found", value, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// the well known types like the wrappers and the timestamp are parsed as the json value
+		message := dynamicpb.NewMessage(fd.Message())
+		if json.Valid([]byte(value)) && protojson.Unmarshal([]byte(value), message) == nil {
+			return protoreflect.ValueOfMessage(message), nil
+		}
+		quoted, _ := json.Marshal(value)
+		message = dynamicpb.NewMessage(fd.Message())
+		if err := protojson.Unmarshal(quoted, message); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(message), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("the field kind:%s is not supported", fd.Kind())
+}
+
+// the message descriptor of the response body, nil if the response field is not a message
+func responseDescriptor(desc protoreflect.MessageDescriptor, responseBody string) protoreflect.MessageDescriptor {
+
+	if responseBody == "" || responseBody == bodyWildcard {
+		return desc
+	}
+	if fd := findField(desc, responseBody); fd != nil {
+		return fd.Message()
+	}
+	return nil
+}
+
+// marshal the response or the field of the response
+func marshalResponse(response *dynamicpb.Message, responseBody string, types *typeResolver) ([]byte, error) {
+
+	content, err := protojson.MarshalOptions{Resolver: types, EmitUnpopulated: true}.Marshal(response)
+	if err != nil || responseBody == "" || responseBody == bodyWildcard {
+		return content, err
+	}
+	fd := findField(response.Descriptor(), responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("the response field:%s not found in %s", responseBody, response.Descriptor().FullName())
+	}
+	fields := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	if field, ok := fields[fd.JSONName()]; ok {
+		return field, nil
+	}
+	return []byte("null"), nil
+}
@@ -0,0 +1,325 @@
+package gateway
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	DefaultCacheSize      = 64 << 20
+	DefaultCacheEntrySize = 1 << 20
+
+	// the header of the cache result, HIT or MISS
+	CacheHeader = "X-Elsa-Cache"
+)
+
+// the cache policy of a route, only the successful unary responses are cached
+type CachePolicy struct {
+	TTL     Duration `json:"ttl"`               // the time to live of the responses, shortened by the max-age of the backend cache-control metadata
+	Fields  []string `json:"fields,omitempty"`  // the request field paths of the key like segment or page.size, default is the whole request
+	Headers []string `json:"headers,omitempty"` // the request headers of the key like Accept-Language
+	Shared  bool     `json:"shared,omitempty"`  // share the responses between the principals, the responses are cached per principal by default
+}
+
+// the size of the response cache
+type CacheConfig struct {
+	MaxSize      int64 // the max bytes of the cached responses
+	MaxEntrySize int64 // the responses larger than the size are not cached
+}
+
+// the cached response
+type cacheEntry struct {
+	key      string
+	route    string
+	header   http.Header
+	content  []byte
+	storedAt time.Time
+	expireAt time.Time
+	shared   bool
+}
+
+// the size of the entry
+func (e *cacheEntry) size() int64 {
+	size := int64(len(e.key) + len(e.content))
+	for name, values := range e.header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
+
+// the stats of the response cache
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"maxSize"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// the lru response cache limited by the bytes
+type responseCache struct {
+	config  CacheConfig
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+	hits    int64
+	misses  int64
+	sync.Mutex
+}
+
+func newResponseCache(config CacheConfig) *responseCache {
+
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultCacheSize
+	}
+	if config.MaxEntrySize <= 0 {
+		config.MaxEntrySize = DefaultCacheEntrySize
+	}
+	return &responseCache{config: config, entries: make(map[string]*list.Element), lru: list.New(), Mutex: sync.Mutex{}}
+}
+
+// the cache key of the route, the selected request fields, the headers and the principal
+func (cache *responseCache) key(c call, r *http.Request, request *dynamicpb.Message, principal *Principal) (string, error) {
+
+	policy := c.route.cache
+	h := sha256.New()
+	write := func(part []byte) {
+		h.Write([]byte(strconv.Itoa(len(part))))
+		h.Write([]byte{':'})
+		h.Write(part)
+	}
+	write([]byte(c.segment))
+	if len(policy.Fields) == 0 {
+		content, err := proto.MarshalOptions{Deterministic: true}.Marshal(request)
+		if err != nil {
+			return "", err
+		}
+		write(content)
+	}
+	for _, field := range policy.Fields {
+		content, err := fieldValue(request, field)
+		if err != nil {
+			return "", err
+		}
+		write(content)
+	}
+	for _, name := range policy.Headers {
+		write([]byte(strings.Join(r.Header.Values(name), ",")))
+	}
+	if !policy.Shared && principal != nil {
+		write([]byte(principal.Subject))
+	}
+	return c.routeName() + "/" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// the deterministic bytes of the field value, empty if the field is not set
+func fieldValue(message protoreflect.Message, fieldPath string) ([]byte, error) {
+
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fd := findField(message.Descriptor(), name)
+		if fd == nil {
+			return nil, fmt.Errorf("the field:%s not found in %s", name, message.Descriptor().FullName())
+		}
+		if !message.Has(fd) {
+			return nil, nil
+		}
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("the field:%s is not a message", name)
+			}
+			message = message.Get(fd).Message()
+			continue
+		}
+		value := dynamicpb.NewMessage(message.Descriptor())
+		value.Set(fd, message.Get(fd))
+		return proto.MarshalOptions{Deterministic: true}.Marshal(value)
+	}
+	return nil, errors.New("the field path is empty")
+}
+
+// get the fresh entry of the key, the max-age of the request limits the age of the entry
+func (cache *responseCache) get(key string, directives map[string]string) *cacheEntry {
+
+	cache.Lock()
+	defer cache.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.misses++
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	now := time.Now()
+	if now.After(entry.expireAt) {
+		cache.remove(element)
+		cache.misses++
+		return nil
+	}
+	if value, ok := directives["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(value); err == nil && now.Sub(entry.storedAt) > time.Duration(maxAge)*time.Second {
+			cache.misses++
+			return nil
+		}
+	}
+	cache.lru.MoveToFront(element)
+	cache.hits++
+	return entry
+}
+
+// put the response, the oldest entries are evicted when the cache is full
+func (cache *responseCache) put(entry *cacheEntry) bool {
+
+	size := entry.size()
+	if size > cache.config.MaxEntrySize || size > cache.config.MaxSize {
+		return false
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if element, ok := cache.entries[entry.key]; ok {
+		cache.remove(element)
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+	cache.size += size
+	for cache.size > cache.config.MaxSize {
+		cache.remove(cache.lru.Back())
+	}
+	return true
+}
+
+// remove the element, the lock must be held
+func (cache *responseCache) remove(element *list.Element) {
+	entry := cache.lru.Remove(element).(*cacheEntry)
+	delete(cache.entries, entry.key)
+	cache.size -= entry.size()
+}
+
+// purge the entries of the route, all the entries if the route is empty
+func (cache *responseCache) purge(route string) int {
+
+	cache.Lock()
+	defer cache.Unlock()
+	purged := 0
+	for element := cache.lru.Front(); element != nil; {
+		next := element.Next()
+		if route == "" || element.Value.(*cacheEntry).route == route {
+			cache.remove(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+func (cache *responseCache) stats() CacheStats {
+	cache.Lock()
+	defer cache.Unlock()
+	return CacheStats{Entries: len(cache.entries), Size: cache.size, MaxSize: cache.config.MaxSize, Hits: cache.hits, Misses: cache.misses}
+}
+
+// parse the cache-control directives like no-cache, max-age=60
+func parseCacheControl(values ...string) map[string]string {
+
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, argument := directive, ""
+			if index := strings.Index(directive, "="); index > 0 {
+				name, argument = directive[:index], strings.Trim(directive[index+1:], `"`)
+			}
+			directives[strings.ToLower(name)] = argument
+		}
+	}
+	return directives
+}
+
+// lookup the cached response of the call, the key is empty if the response must not be cached
+func (g *Gateway) lookupCache(w http.ResponseWriter, r *http.Request, c call, request *dynamicpb.Message, principal *Principal) (string, bool) {
+
+	directives := parseCacheControl(r.Header.Values("Cache-Control")...)
+	if _, ok := directives["no-store"]; ok {
+		return "", false
+	}
+	key, err := g.cache.key(c, r, request, principal)
+	if err != nil {
+		log.Warnf("the gateway cache key of the route:%s fail:%s", c.routeName(), err.Error())
+		return "", false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return key, false
+	}
+	entry := g.cache.get(key, directives)
+	if entry == nil {
+		return key, false
+	}
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = append([]string(nil), values...)
+	}
+	age := time.Since(entry.storedAt)
+	header.Set("Age", strconv.FormatInt(int64(age.Seconds()), 10))
+	header.Set("Cache-Control", cacheControl(entry.shared, entry.expireAt.Sub(entry.storedAt)-age))
+	header.Set(CacheHeader, "HIT")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(entry.content)
+	return key, true
+}
+
+// store the response of the call, the no-store and the private backend responses are not shared
+func (g *Gateway) storeCache(header http.Header, key string, c call, content []byte, md metadata.MD, principal *Principal) {
+
+	policy := c.route.cache
+	ttl := time.Duration(policy.TTL)
+	directives := parseCacheControl(md.Get("cache-control")...)
+	if _, ok := directives["no-store"]; ok {
+		return
+	}
+	if _, ok := directives["private"]; ok && policy.Shared && principal != nil {
+		return
+	}
+	if value, ok := directives["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(value); err == nil && time.Duration(maxAge)*time.Second < ttl {
+			ttl = time.Duration(maxAge) * time.Second
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	entry := &cacheEntry{key: key, route: c.routeName(), header: header.Clone(), content: content, storedAt: now, expireAt: now.Add(ttl), shared: policy.Shared}
+	if g.cache.put(entry) {
+		header.Set("Cache-Control", cacheControl(policy.Shared, ttl))
+	}
+	header.Set(CacheHeader, "MISS")
+}
+
+// the cache-control of the cached response
+func cacheControl(shared bool, ttl time.Duration) string {
+	scope := "private"
+	if shared {
+		scope = "public"
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return fmt.Sprintf("%s, max-age=%d", scope, int64(ttl.Seconds()))
+}
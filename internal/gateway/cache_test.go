@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+
+	cache := newResponseCache(CacheConfig{MaxSize: 100, MaxEntrySize: 50})
+	now := time.Now()
+	entry := func(key, route string, size int) *cacheEntry {
+		return &cacheEntry{key: key, route: route, content: []byte(strings.Repeat("x", size-len(key))), storedAt: now, expireAt: now.Add(time.Minute)}
+	}
+	if cache.put(entry("big", "a", 60)) {
+		t.Fatalf("the entry larger than the max entry size must not be cached")
+	}
+	cache.put(entry("k1", "a", 40))
+	cache.put(entry("k2", "b", 40))
+	if cache.get("k1", nil) == nil {
+		t.Fatalf("the entry k1 must be cached")
+	}
+	// k2 is the least recently used
+	cache.put(entry("k3", "a", 40))
+	if cache.get("k2", nil) != nil || cache.get("k1", nil) == nil || cache.get("k3", nil) == nil {
+		t.Fatalf("the least recently used entry must be evicted")
+	}
+	if stats := cache.stats(); stats.Entries != 2 || stats.Size != 80 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Fatalf("the stats are wrong:%+v", stats)
+	}
+
+	old := entry("k4", "b", 10)
+	old.storedAt = now.Add(-time.Second * 30)
+	cache.put(old)
+	if cache.get("k4", map[string]string{"max-age": "10"}) != nil || cache.get("k4", map[string]string{"max-age": "60"}) == nil {
+		t.Fatalf("the max-age of the request must limit the age")
+	}
+	if purged := cache.purge("a"); purged != 2 || cache.stats().Entries != 1 {
+		t.Fatalf("the entries of the route must be purged but %d", purged)
+	}
+
+	directives := parseCacheControl("no-cache, max-age=\"60\"", "Private")
+	if _, ok := directives["no-cache"]; !ok || directives["max-age"] != "60" {
+		t.Fatalf("the directives are wrong:%v", directives)
+	}
+	if _, ok := directives["private"]; !ok {
+		t.Fatalf("the directives are wrong:%v", directives)
+	}
+}
+
+func TestGateway_Cache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routeFile := filepath.Join(dir, "routes.json")
+	writeRoutes(t, routeFile, RouteConfig{Routes: []Route{
+		{Name: "services", Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch",
+			Cache: &CachePolicy{TTL: Duration(time.Minute), Fields: []string{"serviceName"}, Headers: []string{"Accept-Language"}, Shared: true}},
+	}})
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour))
+	defer closeFunc()
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("the status of %s must be 200 but %d:%s", path, recorder.Code, recorder.Body.String())
+		}
+		return recorder
+	}
+	cases := []struct {
+		path   string
+		header http.Header
+		result string
+	}{
+		{"/v1/dev/services/trade", nil, "MISS"},
+		{"/v1/dev/services/trade", nil, "HIT"},
+		{"/v1/prod/services/trade", nil, "HIT"},
+		{"/v1/dev/services/order", nil, "MISS"},
+		{"/v1/dev/services/trade", http.Header{"Accept-Language": {"en"}}, "MISS"},
+		{"/v1/dev/services/trade", http.Header{"Cache-Control": {"no-cache"}}, "MISS"},
+		{"/v1/dev/services/trade", http.Header{"Cache-Control": {"no-store"}}, ""},
+	}
+	first := ""
+	for i, c := range cases {
+		recorder := get(c.path, c.header)
+		if result := recorder.Header().Get(CacheHeader); result != c.result {
+			t.Fatalf("the case:%d must be %q but %q", i, c.result, result)
+		}
+		if i == 0 {
+			first = recorder.Body.String()
+			if recorder.Header().Get("Cache-Control") != "public, max-age=60" {
+				t.Fatalf("the cache control is wrong:%v", recorder.Header())
+			}
+		}
+		if i == 1 && (recorder.Body.String() != first || recorder.Header().Get("Content-Type") != "application/json") {
+			t.Fatalf("the cached response is wrong:%v %s", recorder.Header(), recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/cache?route=services", nil))
+	result := map[string]int{}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || result["purged"] != 3 {
+		t.Fatalf("the cached responses of the route must be purged but %s", recorder.Body.String())
+	}
+	if recorder = get("/v1/dev/services/trade", nil); recorder.Header().Get(CacheHeader) != "MISS" {
+		t.Fatalf("the purged response must not be hit")
+	}
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/client"
+	"github.com/busgo/elsa/pkg/client/balancer"
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultMaxConns        = 256
+	DefaultConnIdleTimeout = time.Minute * 10
+)
+
+// the client conn of a service and its descriptors
+type backend struct {
+	key      string
+	cc       *grpc.ClientConn
+	source   *descriptorSource
+	active   int // the calls using the client conn
+	lastUsed time.Time
+	removed  bool // removed from the pool, closed by the last call releasing it
+}
+
+// the client conns of the services keyed by the segment and the service name,
+// the least recently used idle conns are closed when the pool is full or idle for the idle timeout
+type connPool struct {
+	target      func(segment, service string) string
+	dialOpts    []grpc.DialOption
+	maxConns    int
+	idleTimeout time.Duration
+	backends    map[string]*list.Element
+	lru         *list.List
+	closedChan  chan bool
+	closed      bool
+	sync.Mutex
+}
+
+// new a conn pool resolving the services by the elsa registry, the conns are insecure without the creds
+func newConnPool(registryStub *client.RegistryStub, creds credentials.TransportCredentials, maxConns int, idleTimeout time.Duration, dialOpts []grpc.DialOption) (*connPool, error) {
+
+	serviceConfig, err := balancer.BuildServiceConfig(balancer.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConns
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultConnIdleTimeout
+	}
+	builder := client.NewElsaResolverBuilder(registryStub)
+	opts := []grpc.DialOption{
+		grpc.WithResolvers(builder),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	p := &connPool{
+		target: func(segment, service string) string {
+			return client.BuildSegmentTarget(builder.Scheme(), segment, service)
+		},
+		dialOpts:    append(opts, dialOpts...),
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		backends:    make(map[string]*list.Element),
+		lru:         list.New(),
+		closedChan:  make(chan bool),
+		Mutex:       sync.Mutex{},
+	}
+	go p.lookup()
+	return p, nil
+}
+
+// get the backend of the service in the segment, dial it lazily, the backend must be released after the call
+func (p *connPool) get(segment, service string) (*backend, error) {
+
+	key := fmt.Sprintf("%s/%s", segment, service)
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return nil, status.Error(codes.Unavailable, "the conn pool has closed")
+	}
+	if element, ok := p.backends[key]; ok {
+		p.lru.MoveToFront(element)
+		b := element.Value.(*backend)
+		b.active++
+		return b, nil
+	}
+	if len(p.backends) >= p.maxConns && !p.evict() {
+		return nil, status.Errorf(codes.ResourceExhausted, "the conn pool is full of %d busy conns", p.maxConns)
+	}
+	cc, err := grpc.Dial(p.target(segment, service), p.dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	b := &backend{key: key, cc: cc, source: newDescriptorSource(cc), active: 1, lastUsed: time.Now()}
+	p.backends[key] = p.lru.PushFront(b)
+	log.Infof("the gateway dial the segment:%s,service:%s success", segment, service)
+	return b, nil
+}
+
+// release the backend after the call
+func (p *connPool) release(b *backend) {
+
+	p.Lock()
+	defer p.Unlock()
+	b.active--
+	b.lastUsed = time.Now()
+	if b.removed && b.active == 0 {
+		_ = b.cc.Close()
+	}
+}
+
+// close the least recently used idle backend, false if all the backends are busy
+func (p *connPool) evict() bool {
+
+	for element := p.lru.Back(); element != nil; element = element.Prev() {
+		b := element.Value.(*backend)
+		if b.active > 0 {
+			continue
+		}
+		p.lru.Remove(element)
+		delete(p.backends, b.key)
+		_ = b.cc.Close()
+		log.Infof("the gateway close the least recently used conn of %s", b.key)
+		return true
+	}
+	return false
+}
+
+// close the backends idle for the idle timeout
+func (p *connPool) evictIdle() {
+
+	p.Lock()
+	defer p.Unlock()
+	deadline := time.Now().Add(-p.idleTimeout)
+	for element := p.lru.Back(); element != nil; {
+		prev := element.Prev()
+		b := element.Value.(*backend)
+		if b.active == 0 && b.lastUsed.Before(deadline) {
+			p.lru.Remove(element)
+			delete(p.backends, b.key)
+			_ = b.cc.Close()
+			log.Infof("the gateway close the idle conn of %s", b.key)
+		}
+		element = prev
+	}
+}
+
+func (p *connPool) lookup() {
+
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.evictIdle()
+		case <-p.closedChan:
+			return
+		}
+	}
+}
+
+// remove the backend of the unknown service from the pool, the conn is closed after the calls using it released
+func (p *connPool) remove(b *backend) {
+
+	p.Lock()
+	defer p.Unlock()
+	element, ok := p.backends[b.key]
+	if !ok || element.Value.(*backend) != b {
+		return
+	}
+	p.lru.Remove(element)
+	delete(p.backends, b.key)
+	b.removed = true
+	if b.active == 0 {
+		_ = b.cc.Close()
+	}
+}
+
+// close all the client conns
+func (p *connPool) close() {
+
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.closedChan)
+	for key, element := range p.backends {
+		_ = element.Value.(*backend).cc.Close()
+		delete(p.backends, key)
+	}
+	p.lru.Init()
+}
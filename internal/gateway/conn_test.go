@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+func newTestConnPool(t *testing.T, maxConns int, idleTimeout time.Duration) *connPool {
+
+	p, err := newConnPool(nil, nil, maxConns, idleTimeout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.target = func(segment, service string) string {
+		return "passthrough:///127.0.0.1:1"
+	}
+	return p
+}
+
+// the least recently used idle conn is closed when the pool is full
+func TestConnPool_Evict(t *testing.T) {
+
+	p := newTestConnPool(t, 2, time.Hour)
+	defer p.close()
+
+	a, _ := p.get("dev", "a")
+	b, _ := p.get("dev", "b")
+	if _, err := p.get("dev", "c"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the busy conns must not be closed but %v", err)
+	}
+	p.release(b)
+	p.release(a)
+	// b is the least recently used since a is used again
+	reused, _ := p.get("dev", "a")
+	p.release(reused)
+	c, err := p.get("dev", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.release(c)
+	if _, ok := p.backends["dev/b"]; ok || len(p.backends) != 2 {
+		t.Fatalf("the least recently used conn must be closed but %d conns", len(p.backends))
+	}
+}
+
+// the conns idle for the idle timeout are closed
+func TestConnPool_EvictIdle(t *testing.T) {
+
+	p := newTestConnPool(t, 0, time.Millisecond*20)
+	defer p.close()
+
+	idle, _ := p.get("dev", "idle")
+	p.release(idle)
+	busy, _ := p.get("dev", "busy")
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		p.Lock()
+		_, ok := p.backends["dev/idle"]
+		p.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the idle conn must be closed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	p.Lock()
+	_, ok := p.backends["dev/busy"]
+	p.Unlock()
+	if !ok {
+		t.Fatal("the busy conn must be kept")
+	}
+	p.release(busy)
+}
+
+// the removed conn is closed after all the concurrent calls using it released
+func TestConnPool_Remove(t *testing.T) {
+
+	p := newTestConnPool(t, 0, time.Hour)
+	defer p.close()
+
+	const calls = 8
+	backends := make(chan *backend, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := p.get("dev", "unknown")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// every call finds the service unknown and removes the backend
+			p.remove(b)
+			backends <- b
+		}()
+	}
+	wg.Wait()
+	close(backends)
+
+	removed := make([]*backend, 0, calls)
+	for b := range backends {
+		removed = append(removed, b)
+	}
+	for i, b := range removed {
+		if state := b.cc.GetState(); state == connectivity.Shutdown {
+			t.Fatalf("the conn must not be closed before the call %d released", i)
+		}
+		p.release(b)
+	}
+	for _, b := range removed {
+		if state := b.cc.GetState(); state != connectivity.Shutdown {
+			t.Fatalf("the removed conn must be closed after all the calls released but %s", state)
+		}
+	}
+	if len(p.backends) != 0 {
+		t.Fatalf("the removed backend must not be in the pool but %d", len(p.backends))
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the http status of the grpc codes
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+}
+
+// the http status of the grpc code
+func HTTPStatus(code codes.Code) int {
+	if s, ok := httpStatus[code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// the error body of the gateway
+type errorBody struct {
+	Code    int32  `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+func newErrorBody(s *status.Status) errorBody {
+	return errorBody{
+		Code:    int32(s.Code()),
+		Status:  s.Code().String(),
+		Message: s.Message(),
+	}
+}
+
+// write the error as the json body with the http status of the grpc code
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	writeStatus(w, HTTPStatus(s.Code()), s)
+}
+
+// write the grpc status as the json body with the http status
+func writeStatus(w http.ResponseWriter, httpStatus int, s *status.Status) {
+
+	content, err := json.Marshal(newErrorBody(s))
+	if err != nil {
+		log.Errorf("marshal the gateway error fail:%s", err.Error())
+		content = []byte(`{"code":13,"status":"Internal","message":"marshal the error fail"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(content)
+}
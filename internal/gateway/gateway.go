@@ -1 +1,592 @@
 package gateway
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/busgo/elsa/pkg/client"
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/metrics"
+	"github.com/busgo/elsa/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	DefaultAddress     = ":8080"
+	DefaultTimeout     = time.Second * 10
+	DefaultMaxBodySize = 4 << 20
+)
+
+type Options struct {
+	address          string
+	timeout          time.Duration
+	maxBodySize      int64
+	dialOpts         []grpc.DialOption
+	tls              *security.TLSConfig
+	maxConns         int
+	connIdleTimeout  time.Duration
+	defaultRoute     bool
+	defaultServices  map[string]bool
+	routeFile        string
+	reloadInterval   time.Duration
+	discoverInterval time.Duration
+	adminAddress     string
+	auth             *AuthConfig
+	authenticators   []Authenticator
+	counterStore     CounterStore
+	clientIPHeader   string
+	accessLog        *AccessLogConfig
+	metricsAddress   string
+	cache            CacheConfig
+	pingInterval     time.Duration
+}
+
+type Option func(options *Options)
+
+// listen on the address like :8080
+func WithAddress(address string) Option {
+	return func(options *Options) {
+		options.address = address
+	}
+}
+
+// the default timeout of the backend calls
+func WithTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.timeout = timeout
+	}
+}
+
+// the max size of the request body
+func WithMaxBodySize(size int64) Option {
+	return func(options *Options) {
+		options.maxBodySize = size
+	}
+}
+
+// add the dial options of the backends
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(options *Options) {
+		options.dialOpts = append(options.dialOpts, opts...)
+	}
+}
+
+// call the backends with the tls, the server name of the backend certificates is the service name by default,
+// the cert file enables the mutual tls, the backends are insecure by default
+func WithTLS(config security.TLSConfig) Option {
+	return func(options *Options) {
+		options.tls = &config
+	}
+}
+
+// the max client conns of the backends and the idle timeout closing the unused conns,
+// the least recently used idle conn is closed when the pool is full
+func WithConnPool(maxConns int, idleTimeout time.Duration) Option {
+	return func(options *Options) {
+		options.maxConns = maxConns
+		options.connIdleTimeout = idleTimeout
+	}
+}
+
+// enable the route POST /{segment}/{service}/{method} of the services like dev/com.busgo.trade.proto.TradeService,
+// all the services are allowed without the services, the default route is disabled by default
+func WithDefaultRoute(services ...string) Option {
+	return func(options *Options) {
+		options.defaultRoute = true
+		for _, service := range services {
+			if options.defaultServices == nil {
+				options.defaultServices = make(map[string]bool)
+			}
+			options.defaultServices[service] = true
+		}
+	}
+}
+
+// the route file reloaded when changed, the interval is the check interval of the file
+func WithRouteFile(file string, interval time.Duration) Option {
+	return func(options *Options) {
+		options.routeFile = file
+		options.reloadInterval = interval
+	}
+}
+
+// the interval to discover the routes of the google.api.http rules of the services in the route file
+func WithDiscoverInterval(interval time.Duration) Option {
+	return func(options *Options) {
+		options.discoverInterval = interval
+	}
+}
+
+// the admin api listen on the address like :8081, the admin api is disabled by default
+func WithAdminAddress(address string) Option {
+	return func(options *Options) {
+		options.adminAddress = address
+	}
+}
+
+// the built in authenticators of the auth config
+func WithAuth(config AuthConfig) Option {
+	return func(options *Options) {
+		options.auth = &config
+	}
+}
+
+// add the authenticator plugins tried after the built in authenticators
+func WithAuthenticators(authenticators ...Authenticator) Option {
+	return func(options *Options) {
+		options.authenticators = append(options.authenticators, authenticators...)
+	}
+}
+
+// the counter store shared by the gateways like the etcd store, the limits are local by default
+func WithCounterStore(store CounterStore) Option {
+	return func(options *Options) {
+		options.counterStore = store
+	}
+}
+
+// the header of the client ip set by the proxy like X-Forwarded-For, the remote address is used by default
+func WithClientIPHeader(header string) Option {
+	return func(options *Options) {
+		options.clientIPHeader = header
+	}
+}
+
+// log the accesses by the config
+func WithAccessLog(config AccessLogConfig) Option {
+	return func(options *Options) {
+		options.accessLog = &config
+	}
+}
+
+// collect the per route metrics and expose them on the /metrics http listener of the address like :9090
+func WithMetrics(address string) Option {
+	return func(options *Options) {
+		options.metricsAddress = address
+	}
+}
+
+// the size of the response cache of the routes with the cache policy
+func WithCache(config CacheConfig) Option {
+	return func(options *Options) {
+		options.cache = config
+	}
+}
+
+// the interval to ping the websocket clients, the client is closed if no pong is received in 2 intervals
+func WithPingInterval(interval time.Duration) Option {
+	return func(options *Options) {
+		options.pingInterval = interval
+	}
+}
+
+// the http json to grpc gateway resolving the backends by the elsa registry
+type Gateway struct {
+	opts     Options
+	conns    *connPool
+	reloader *security.Reloader
+	routes   *routeTable
+	auth     *authChain
+	limiter  *rateLimiter
+	cache    *responseCache
+	server   *http.Server
+	admin    *http.Server
+	metrics  *http.Server
+}
+
+// the default route binds the whole body
+var defaultRoute = &route{name: "default", method: http.MethodPost, body: bodyWildcard}
+
+// the call of a backend method
+type call struct {
+	segment   string
+	service   string
+	method    string
+	route     *route
+	variables map[string]string
+	auth      *AuthRequirement
+	limits    *Limits
+	transform *transform
+}
+
+// the full method like /com.busgo.trade.proto.TradeService/Ping
+func (c call) fullMethod() string {
+	return fmt.Sprintf("/%s/%s", c.service, c.method)
+}
+
+// write the error with the http status of the transform
+func (c call) writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	writeStatus(w, c.transform.httpStatus(s.Code()), s)
+}
+
+// the route name of the limits, the full method for the default route and the grpc-web calls
+func (c call) routeName() string {
+	if c.route == nil || c.route == defaultRoute {
+		return c.fullMethod()
+	}
+	return c.route.name
+}
+
+// new a gateway with the registry stub
+func NewGateway(registryStub *client.RegistryStub, options ...Option) (*Gateway, error) {
+
+	opts := Options{
+		address:      DefaultAddress,
+		timeout:      DefaultTimeout,
+		maxBodySize:  DefaultMaxBodySize,
+		pingInterval: DefaultPingInterval,
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.pingInterval <= 0 {
+		opts.pingInterval = DefaultPingInterval
+	}
+
+	var reloader *security.Reloader
+	var creds credentials.TransportCredentials
+	if opts.tls != nil {
+		r, err := security.NewReloader(*opts.tls)
+		if err != nil {
+			return nil, err
+		}
+		reloader, creds = r, r.ClientCredentials()
+	}
+	conns, err := newConnPool(registryStub, creds, opts.maxConns, opts.connIdleTimeout, opts.dialOpts)
+	if err != nil {
+		if reloader != nil {
+			reloader.Close()
+		}
+		return nil, err
+	}
+	authenticators := make([]Authenticator, 0)
+	if opts.auth != nil {
+		if authenticators, err = opts.auth.authenticators(); err != nil {
+			conns.close()
+			if reloader != nil {
+				reloader.Close()
+			}
+			return nil, err
+		}
+	}
+	g := &Gateway{
+		opts:     opts,
+		conns:    conns,
+		reloader: reloader,
+		auth:     newAuthChain(append(authenticators, opts.authenticators...), opts.reloadInterval),
+		limiter:  newRateLimiter(opts.counterStore),
+		cache:    newResponseCache(opts.cache),
+	}
+	if g.routes, err = newRouteTable(opts.routeFile, opts.reloadInterval, opts.discoverInterval, g.discover); err != nil {
+		g.limiter.close()
+		g.auth.close()
+		conns.close()
+		if reloader != nil {
+			reloader.Close()
+		}
+		return nil, err
+	}
+	g.server = &http.Server{Addr: opts.address, Handler: g}
+	if opts.adminAddress != "" {
+		g.admin = &http.Server{Addr: opts.adminAddress, Handler: g.adminHandler()}
+	}
+	if opts.metricsAddress != "" {
+		registerMetrics()
+		g.metrics = metrics.NewServer(opts.metricsAddress)
+	}
+	return g, nil
+}
+
+// start the gateway
+func (g *Gateway) Start() error {
+
+	if g.admin != nil {
+		go func() {
+			log.Infof("the gateway admin api listen on %s", g.admin.Addr)
+			if err := g.admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("the gateway admin api serve fail:%s", err.Error())
+			}
+		}()
+	}
+	if g.metrics != nil {
+		go func() {
+			log.Infof("the gateway metrics listen on %s", g.metrics.Addr)
+			if err := g.metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("the gateway metrics serve fail:%s", err.Error())
+			}
+		}()
+	}
+	log.Infof("the gateway listen on %s", g.opts.address)
+	if err := g.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// shutdown the gateway gracefully and close the backends
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	err := g.server.Shutdown(ctx)
+	if g.admin != nil {
+		if e := g.admin.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if g.metrics != nil {
+		if e := g.metrics.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	g.routes.close()
+	g.auth.close()
+	g.limiter.close()
+	g.conns.close()
+	if g.reloader != nil {
+		g.reloader.Close()
+	}
+	return err
+}
+
+// serve the request and observe it by the metrics and the access log
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if g.opts.accessLog == nil && g.opts.metricsAddress == "" {
+		g.serve(w, r)
+		return
+	}
+	start := time.Now()
+	aw := &accessWriter{ResponseWriter: w}
+	a := &access{}
+	g.serve(aw, r.WithContext(context.WithValue(r.Context(), accessKey{}, a)))
+	g.observe(aw, r, a, start)
+}
+
+// serve the grpc-web requests, the configured routes, then the default route like POST /{segment}/{service}/{method} if enabled
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
+
+	router := g.routes.current()
+	if isPreflight(r) {
+		g.preflight(w, r, router)
+		return
+	}
+	if isGRPCWeb(r) {
+		g.serveGRPCWeb(w, r, router)
+		return
+	}
+	rt, variables, allowed := router.match(r.Method, r.URL.Path)
+	if rt != nil {
+		g.invoke(w, r, router.call(rt, rt.segment, rt.service, rt.rpc, variables))
+		return
+	}
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+		return
+	}
+	if !g.opts.defaultRoute || router.disableDefaultRoute {
+		writeStatus(w, http.StatusNotFound, status.Newf(codes.NotFound, "the path:%s not found", r.URL.Path))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		writeStatus(w, http.StatusNotFound, status.Newf(codes.NotFound, "the path:%s not found", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost && !isWebSocket(r) {
+		w.Header().Set("Allow", http.MethodPost)
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+		return
+	}
+	if !g.defaultAllowed(router, parts[0], parts[1]) {
+		writeStatus(w, http.StatusNotFound, status.Newf(codes.NotFound, "the service:%s of segment:%s not found", parts[1], parts[0]))
+		return
+	}
+	g.invoke(w, r, router.call(defaultRoute, parts[0], parts[1], parts[2], nil))
+}
+
+// the service in the segment is allowed by the default route, the default route is disabled by default
+// and limited to the services of the allowlist if set
+func (g *Gateway) defaultAllowed(router *router, segment, service string) bool {
+
+	if !g.opts.defaultRoute || router.disableDefaultRoute {
+		return false
+	}
+	return g.opts.defaultServices == nil || g.opts.defaultServices[segment+"/"+service]
+}
+
+// answer the cors preflight by the policy of the requested route
+func (g *Gateway) preflight(w http.ResponseWriter, r *http.Request, router *router) {
+
+	transform := router.defaultTransform
+	if rt, _, _ := router.match(r.Header.Get("Access-Control-Request-Method"), r.URL.Path); rt != nil && rt.transform != nil {
+		transform = rt.transform
+	}
+	transform.preflight(w, r)
+}
+
+// invoke the backend method with the json body and write the json response
+func (g *Gateway) invoke(w http.ResponseWriter, r *http.Request, c call) {
+
+	c.recordAccess(r)
+	c.transform.writeCORS(w, r)
+	principal, err := g.auth.authenticate(r, c.auth)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+	recordPrincipal(r, principal)
+	if retryAfter, err := g.limit(r, c, principal); err != nil {
+		setRetryAfter(w, retryAfter)
+		c.writeError(w, err)
+		return
+	}
+	b, err := g.conns.get(c.segment, c.service)
+	if err != nil {
+		c.writeError(w, status.Errorf(codes.Unavailable, "dial the service:%s fail:%s", c.service, err.Error()))
+		return
+	}
+	defer g.conns.release(b)
+	findCtx, findCancel := context.WithTimeout(r.Context(), g.opts.timeout)
+	md, types, err := b.source.findMethod(findCtx, c.service, c.method)
+	findCancel()
+	if err != nil {
+		// do not keep the client conns of the unknown services
+		if b.source.empty() {
+			g.conns.remove(b)
+		}
+		log.Warnf("the gateway find the method:%s of segment:%s fail:%s", c.fullMethod(), c.segment, err.Error())
+		c.writeError(w, err)
+		return
+	}
+	if md.IsStreamingClient() || isWebSocket(r) {
+		if !md.IsStreamingClient() {
+			c.writeError(w, status.Errorf(codes.InvalidArgument, "the websocket of the method:%s is not supported, only the client streaming methods are bridged", c.fullMethod()))
+			return
+		}
+		if !isWebSocket(r) {
+			c.writeError(w, status.Errorf(codes.Unimplemented, "the client streaming method:%s is only supported over the websocket", c.fullMethod()))
+			return
+		}
+		// the websocket calls are only limited by the route timeout
+		ctx := r.Context()
+		if timeout := c.route.timeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		g.bridge(c.outgoingContext(ctx, r, principal), w, r, b, md, types, c)
+		return
+	}
+
+	request, err := g.decode(r, md.Input(), types, c)
+	if err != nil {
+		c.writeError(w, err)
+		return
+	}
+
+	cacheKey := ""
+	if c.route.cache != nil && !md.IsStreamingServer() {
+		var hit bool
+		if cacheKey, hit = g.lookupCache(w, r, c, request, principal); hit {
+			return
+		}
+	}
+
+	// the server streaming calls are only limited by the route timeout
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if timeout := c.route.timeout; timeout > 0 || !md.IsStreamingServer() {
+		if timeout <= 0 {
+			timeout = g.opts.timeout
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = c.outgoingContext(ctx, r, principal)
+	if md.IsStreamingServer() {
+		g.serverStream(ctx, w, r, b, md, types, c, request)
+		return
+	}
+
+	response := dynamicpb.NewMessage(md.Output())
+	header, trailer, p := metadata.MD{}, metadata.MD{}, &peer.Peer{}
+	err = b.cc.Invoke(ctx, c.fullMethod(), request, response, grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(p))
+	recordInstance(r, p)
+	if err != nil {
+		log.Warnf("the gateway invoke the method:%s of segment:%s fail:%s", c.fullMethod(), c.segment, err.Error())
+		c.writeError(w, err)
+		return
+	}
+
+	content, err := marshalResponse(response, c.route.responseBody, types)
+	if err == nil {
+		content, err = c.transform.responseBody(content, responseDescriptor(md.Output(), c.route.responseBody))
+	}
+	if err != nil {
+		c.writeError(w, status.Errorf(codes.Internal, "marshal the response fail:%s", err.Error()))
+		return
+	}
+	responseHeader := http.Header{}
+	c.transform.writeResponseHeaders(responseHeader, header, trailer)
+	responseHeader.Set("Content-Type", "application/json")
+	if cacheKey != "" {
+		g.storeCache(responseHeader, cacheKey, c, content, metadata.Join(header, trailer), principal)
+	}
+	for name, values := range responseHeader {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
+
+// the context with the forwarded headers and the principal as the outgoing metadata
+func (c call) outgoingContext(ctx context.Context, r *http.Request, principal *Principal) context.Context {
+
+	outgoing := c.transform.requestMetadata(r.Header)
+	if principal != nil {
+		outgoing = metadata.Join(outgoing, principal.metadata())
+	}
+	if len(outgoing) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, outgoing)
+	}
+	return ctx
+}
+
+// limit the call by the route, the subject of the principal and the client ip
+func (g *Gateway) limit(r *http.Request, c call, principal *Principal) (time.Duration, error) {
+
+	subject := ""
+	if principal != nil {
+		subject = principal.Subject
+	}
+	return g.limiter.allow(r.Context(), c.routeName(), c.limits, subject, clientIP(r, g.opts.clientIPHeader))
+}
+
+// decode the request message from the body, the path variables and the query parameters
+func (g *Gateway) decode(r *http.Request, desc protoreflect.MessageDescriptor, types *typeResolver, c call) (*dynamicpb.Message, error) {
+
+	request := dynamicpb.NewMessage(desc)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, g.opts.maxBodySize))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "read the request body fail:%s", err.Error())
+	}
+	if body, err = c.transform.requestBody(body); err != nil {
+		return nil, err
+	}
+	if err = bind(request, body, c.route, c.variables, c.transform.requestQuery(r.URL.Query()), types); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/busgo/elsa/pkg/proto/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type testRegistryService struct {
+	pb.UnimplementedRegistryServiceServer
+}
+
+func (s *testRegistryService) Fetch(ctx context.Context, request *pb.FetchRequest) (*pb.FetchResponse, error) {
+	if request.ServiceName == "" {
+		return nil, status.Error(codes.InvalidArgument, "the service name is empty")
+	}
+	// echo the principal and the tenant as the message
+	md, _ := metadata.FromIncomingContext(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-backend-version", "1", "x-internal-node", "n1"))
+	return &pb.FetchResponse{
+		Message:   strings.Join(append(md.Get(PrincipalKey), md.Get("x-tenant")...), ","),
+		Instances: []*pb.ServiceInstance{{Segment: request.Segment, ServiceName: request.ServiceName, Ip: "127.0.0.1", Port: 8001}},
+	}, nil
+}
+
+// echo the request as the instance
+func (s *testRegistryService) Renew(ctx context.Context, request *pb.RenewRequest) (*pb.RenewResponse, error) {
+	return &pb.RenewResponse{
+		Code:     int32(request.SyncType),
+		Instance: &pb.ServiceInstance{Segment: request.Segment, ServiceName: request.ServiceName, Ip: request.Ip, Port: request.Port},
+	}, nil
+}
+
+// start a backend with the reflection and a gateway calling it directly, the default route is enabled
+func newTestGateway(t *testing.T, options ...Option) (*Gateway, func()) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterRegistryServiceServer(server, new(testRegistryService))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	go server.Serve(l)
+
+	g, err := NewGateway(nil, append([]Option{WithDefaultRoute()}, options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.conns.target = func(segment, service string) string {
+		return "passthrough:///" + l.Addr().String()
+	}
+	return g, func() {
+		_ = g.Shutdown(context.Background())
+		server.Stop()
+	}
+}
+
+func serve(g *Gateway, method, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	g.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+func TestGateway_Invoke(t *testing.T) {
+
+	g, closeFunc := newTestGateway(t)
+	defer closeFunc()
+
+	recorder := serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{"segment":"dev","serviceName":"trade"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the status must be 200 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	response := struct {
+		Instances []struct {
+			ServiceName string `json:"serviceName"`
+			Port        int    `json:"port"`
+		} `json:"instances"`
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Instances) != 1 || response.Instances[0].ServiceName != "trade" || response.Instances[0].Port != 8001 {
+		t.Fatalf("the response is unexpected:%s", recorder.Body.String())
+	}
+}
+
+func TestGateway_Errors(t *testing.T) {
+
+	g, closeFunc := newTestGateway(t)
+	defer closeFunc()
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/dev/com.busgo.registry.proto.RegistryService/fetch", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService", "", http.StatusNotFound},
+		{http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/missing", "", http.StatusNotFound},
+		{http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{"unknown":1}`, http.StatusBadRequest},
+		{http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/dev/com.busgo.UnknownService/fetch", `{}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if recorder := serve(g, c.method, c.path, c.body); recorder.Code != c.status {
+			t.Fatalf("the status of %s %s must be %d but %d:%s", c.method, c.path, c.status, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestGateway_DefaultRoute(t *testing.T) {
+
+	// the default route is disabled by default
+	g, err := NewGateway(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Shutdown(context.Background())
+	if recorder := serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{}`); recorder.Code != http.StatusNotFound {
+		t.Fatalf("the default route must be disabled but %d", recorder.Code)
+	}
+
+	g, closeFunc := newTestGateway(t, WithDefaultRoute("dev/com.busgo.registry.proto.RegistryService"))
+	defer closeFunc()
+	if recorder := serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{"segment":"dev","serviceName":"trade"}`); recorder.Code != http.StatusOK {
+		t.Fatalf("the allowed service must be served but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	for _, path := range []string{"/prod/com.busgo.registry.proto.RegistryService/fetch", "/dev/grpc.health.v1.Health/Check"} {
+		if recorder := serve(g, http.MethodPost, path, `{}`); recorder.Code != http.StatusNotFound {
+			t.Fatalf("the service of %s is not allowed but %d", path, recorder.Code)
+		}
+	}
+	if size := len(g.conns.backends); size != 1 {
+		t.Fatalf("the gateway must not dial the services not allowed but %d conns", size)
+	}
+}
@@ -0,0 +1,356 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the header to select the segment of the grpc-web calls, default is the segment of the registry stub
+	SegmentHeader = "X-Elsa-Segment"
+
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// the trailer frame flag
+	trailerFlag     byte = 0x80
+	frameHeaderSize      = 5
+)
+
+// the headers not forwarded as the metadata
+var skippedHeaders = map[string]bool{
+	"accept":            true,
+	"accept-encoding":   true,
+	"accept-language":   true,
+	"connection":        true,
+	"content-length":    true,
+	"content-type":      true,
+	"cookie":            true,
+	"host":              true,
+	"keep-alive":        true,
+	"origin":            true,
+	"referer":           true,
+	"te":                true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"user-agent":        true,
+	"x-grpc-web":        true,
+	"x-user-agent":      true,
+}
+
+// the credentials consumed by the auth chain, only forwarded if the header policy of the transform names them
+var credentialHeaders = map[string]bool{
+	"authorization": true,
+	"x-api-key":     true,
+}
+
+// the codec passing the raw frames through
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	payload, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("the raw codec can not marshal %T", v)
+	}
+	return *payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	payload, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("the raw codec can not unmarshal %T", v)
+	}
+	*payload = append((*payload)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// the request is a grpc-web request
+func isGRPCWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// the grpc-web response writer writing the frames, the text mode encodes every frame by base64
+type webWriter struct {
+	w           http.ResponseWriter
+	contentType string
+	text        bool
+	wroteHeader bool
+}
+
+// write the metadata as the http headers
+func (ww *webWriter) writeHeader(md metadata.MD) {
+
+	if ww.wroteHeader {
+		return
+	}
+	ww.wroteHeader = true
+	header := ww.w.Header()
+	header.Set("Content-Type", ww.contentType)
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, encodeMetadataValue(key, value))
+		}
+	}
+	ww.w.WriteHeader(http.StatusOK)
+}
+
+// write a frame and flush it
+func (ww *webWriter) writeFrame(flag byte, payload []byte) error {
+
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+	if ww.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := ww.w.Write(frame); err != nil {
+		return err
+	}
+	if flusher, ok := ww.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// write the status and the trailers as the trailer frame
+func (ww *webWriter) writeTrailer(s *status.Status, trailer metadata.MD) error {
+
+	buffer := bytes.Buffer{}
+	buffer.WriteString(fmt.Sprintf("grpc-status: %d\r\n", s.Code()))
+	if s.Message() != "" {
+		buffer.WriteString(fmt.Sprintf("grpc-message: %s\r\n", url.PathEscape(s.Message())))
+	}
+	for key, values := range trailer {
+		for _, value := range values {
+			buffer.WriteString(fmt.Sprintf("%s: %s\r\n", strings.ToLower(key), encodeMetadataValue(key, value)))
+		}
+	}
+	ww.writeHeader(nil)
+	return ww.writeFrame(trailerFlag, buffer.Bytes())
+}
+
+// the binary metadata values are encoded by base64
+func encodeMetadataValue(key, value string) string {
+	if strings.HasSuffix(key, "-bin") {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// serve the grpc-web request like POST /{service}/{method} of the routed methods and the services allowed by the default route,
+// the frames are passed through to the backend
+func (g *Gateway) serveGRPCWeb(w http.ResponseWriter, r *http.Request, router *router) {
+
+	contentType := r.Header.Get("Content-Type")
+	ww := &webWriter{w: w, contentType: contentType, text: strings.HasPrefix(contentType, grpcWebTextContentType)}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeStatus(w, http.StatusMethodNotAllowed, status.Newf(codes.Unimplemented, "the method:%s not allowed", r.Method))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		_ = ww.writeTrailer(status.Newf(codes.Unimplemented, "the path:%s not found", r.URL.Path), nil)
+		return
+	}
+	// only the methods of the configured routes and the services allowed by the default route are callable,
+	// the policies of the configured route are applied
+	segment := r.Header.Get(SegmentHeader)
+	rt := router.find(segment, parts[0], parts[1])
+	if rt == nil && !g.defaultAllowed(router, segment, parts[0]) {
+		_ = ww.writeTrailer(status.Newf(codes.Unimplemented, "the method:/%s/%s of segment:%s not found", parts[0], parts[1], segment), nil)
+		return
+	}
+	c := router.call(rt, segment, parts[0], parts[1], nil)
+	c.recordAccess(r)
+	c.transform.writeCORS(w, r)
+	principal, err := g.auth.authenticate(r, c.auth)
+	if err != nil {
+		_ = ww.writeTrailer(status.Convert(err), nil)
+		return
+	}
+	recordPrincipal(r, principal)
+	if retryAfter, err := g.limit(r, c, principal); err != nil {
+		setRetryAfter(w, retryAfter)
+		_ = ww.writeTrailer(status.Convert(err), nil)
+		return
+	}
+
+	payload, err := g.readWebRequest(r, ww.text)
+	if err != nil {
+		_ = ww.writeTrailer(status.Convert(err), nil)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	md := forwardedMetadata(r.Header)
+	if forwarded := c.transform.requestMetadata(r.Header); forwarded != nil {
+		md = forwarded
+	}
+	if principal != nil {
+		md = metadata.Join(md, principal.metadata())
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	b, err := g.conns.get(c.segment, c.service)
+	if err != nil {
+		_ = ww.writeTrailer(status.Newf(codes.Unavailable, "dial the service:%s fail:%s", c.service, err.Error()), nil)
+		return
+	}
+	defer g.conns.release(b)
+	stream, err := b.cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, c.fullMethod(), grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		_ = ww.writeTrailer(status.Convert(err), nil)
+		return
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		recordInstance(r, p)
+	}
+	if err = stream.SendMsg(&payload); err != nil && err != io.EOF {
+		_ = ww.writeTrailer(status.Convert(err), stream.Trailer())
+		return
+	}
+	if err = stream.CloseSend(); err != nil {
+		_ = ww.writeTrailer(status.Convert(err), stream.Trailer())
+		return
+	}
+
+	for {
+		response := make([]byte, 0)
+		err = stream.RecvMsg(&response)
+		if err != nil {
+			break
+		}
+		header, _ := stream.Header()
+		ww.writeHeader(c.transform.responseMetadata(header))
+		if err = ww.writeFrame(0, response); err != nil {
+			log.Warnf("the gateway write the grpc-web frame of %s fail:%s", c.fullMethod(), err.Error())
+			return
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if header, e := stream.Header(); e == nil {
+		ww.writeHeader(c.transform.responseMetadata(header))
+	}
+	_ = ww.writeTrailer(status.Convert(err), c.transform.responseMetadata(stream.Trailer()))
+}
+
+// read the first data frame of the grpc-web request
+func (g *Gateway) readWebRequest(r *http.Request, text bool) ([]byte, error) {
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, g.opts.maxBodySize))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "read the request body fail:%s", err.Error())
+	}
+	if text {
+		if body, err = decodeText(body); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "the grpc-web-text body is invalid:%s", err.Error())
+		}
+	}
+	if len(body) < frameHeaderSize {
+		return nil, status.Error(codes.InvalidArgument, "the grpc-web frame is missing")
+	}
+	if body[0]&0x01 != 0 {
+		return nil, status.Error(codes.Unimplemented, "the compressed grpc-web frame is not supported")
+	}
+	length := binary.BigEndian.Uint32(body[1:frameHeaderSize])
+	if uint32(len(body)-frameHeaderSize) < length {
+		return nil, status.Error(codes.InvalidArgument, "the grpc-web frame is truncated")
+	}
+	return body[frameHeaderSize : frameHeaderSize+int(length)], nil
+}
+
+// decode the base64 text, the chunks may be padded separately
+func decodeText(body []byte) ([]byte, error) {
+
+	body = bytes.Join(bytes.Fields(body), nil)
+	if len(body)%4 != 0 {
+		return nil, fmt.Errorf("the length:%d is not a multiple of 4", len(body))
+	}
+	decoded := make([]byte, 0, len(body)/4*3)
+	quantum := make([]byte, 3)
+	for i := 0; i < len(body); i += 4 {
+		n, err := base64.StdEncoding.Decode(quantum, body[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, quantum[:n]...)
+	}
+	return decoded, nil
+}
+
+// the http headers forwarded as the metadata
+func forwardedMetadata(header http.Header) metadata.MD {
+
+	md := metadata.MD{}
+	for key, values := range header {
+		key = strings.ToLower(key)
+		// the elsa headers like the principal must not be forged by the clients
+		if skippedHeaders[key] || credentialHeaders[key] || strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, "x-elsa-") {
+			continue
+		}
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+					value = string(decoded)
+				}
+			}
+			md.Append(key, value)
+		}
+	}
+	return md
+}
+
+// parse the grpc timeout like 100m or 5S
+func parseTimeout(value string) (time.Duration, bool) {
+
+	if len(value) < 2 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// the scheme of the hmac authorization like HMAC-SHA256 KeyId=team-a,Signature=base64
+	hmacScheme = "HMAC-SHA256 "
+	// the header of the unix seconds signing the request
+	TimestampHeader = "X-Elsa-Timestamp"
+	// the default max clock skew of the signed requests
+	DefaultMaxSkew = time.Minute * 5
+)
+
+// the config of the hmac authenticator
+type HMACConfig struct {
+	KeyFile     string   `json:"keyFile"`               // the key file like {"team-a":"secret"}
+	MaxSkew     Duration `json:"maxSkew,omitempty"`     // the max clock skew of the timestamp
+	MaxBodySize int64    `json:"maxBodySize,omitempty"` // the max size of the signed body
+}
+
+// the authenticator verifying the hmac signature of the method, the path, the timestamp and the body
+type hmacAuthenticator struct {
+	config HMACConfig
+	keys   map[string][]byte
+	sync.RWMutex
+}
+
+// new a hmac authenticator with the key file
+func NewHMACAuthenticator(config HMACConfig) (Authenticator, error) {
+
+	if config.KeyFile == "" {
+		return nil, errors.New("the key file of the hmac authenticator is required")
+	}
+	if config.MaxSkew <= 0 {
+		config.MaxSkew = Duration(DefaultMaxSkew)
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+	a := &hmacAuthenticator{config: config, keys: make(map[string][]byte), RWMutex: sync.RWMutex{}}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *hmacAuthenticator) Name() string {
+	return HMACMethod
+}
+
+func (a *hmacAuthenticator) files() []string {
+	return []string{a.config.KeyFile}
+}
+
+// load the keys
+func (a *hmacAuthenticator) load() error {
+
+	content, err := ioutil.ReadFile(a.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	secrets := make(map[string]string)
+	if err = json.Unmarshal(content, &secrets); err != nil {
+		return fmt.Errorf("the hmac key file:%s is invalid:%s", a.config.KeyFile, err.Error())
+	}
+	keys := make(map[string][]byte, len(secrets))
+	for id, secret := range secrets {
+		keys[id] = []byte(secret)
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.keys = keys
+	return nil
+}
+
+func (a *hmacAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, hmacScheme) {
+		return nil, ErrNoCredentials
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(strings.TrimPrefix(authorization, hmacScheme), ",") {
+		if index := strings.Index(param, "="); index > 0 {
+			params[strings.TrimSpace(param[:index])] = strings.TrimSpace(param[index+1:])
+		}
+	}
+	keyId, signature := params["KeyId"], params["Signature"]
+	if keyId == "" || signature == "" {
+		return nil, errors.New("the key id and the signature are required")
+	}
+	a.RLock()
+	secret, ok := a.keys[keyId]
+	a.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("the key id:%s is unknown", keyId)
+	}
+
+	seconds, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("the %s header is invalid", TimestampHeader)
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > time.Duration(a.config.MaxSkew) || -skew > time.Duration(a.config.MaxSkew) {
+		return nil, errors.New("the request timestamp is out of the max skew")
+	}
+
+	// read the body and restore it for the backend call
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, a.config.MaxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("read the request body fail:%s", err.Error())
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, sign(secret, r.Method, r.URL.RequestURI(), seconds, body)) {
+		return nil, errors.New("the signature is invalid")
+	}
+	return &Principal{Subject: keyId, Method: HMACMethod}, nil
+}
+
+// sign the method, the request uri, the timestamp and the body hash
+func sign(secret []byte, method, uri string, seconds int64, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprintf("%s\n%s\n%d\n%s", method, uri, seconds, hex.EncodeToString(digest[:]))))
+	return mac.Sum(nil)
+}
+
+// sign the request by the hmac key, the body must be the request body
+func SignRequest(r *http.Request, keyId string, secret []byte, body []byte) {
+	seconds := time.Now().Unix()
+	signature := base64.StdEncoding.EncodeToString(sign(secret, r.Method, r.URL.RequestURI(), seconds, body))
+	r.Header.Set(TimestampHeader, strconv.FormatInt(seconds, 10))
+	r.Header.Set("Authorization", fmt.Sprintf("%sKeyId=%s,Signature=%s", hmacScheme, keyId, signature))
+}
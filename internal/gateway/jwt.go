@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the prefix of the bearer token
+const bearerPrefix = "Bearer "
+
+// the config of the jwt authenticator
+type JWTConfig struct {
+	JWKSFiles []string `json:"jwksFiles"`          // the json web key set files
+	Issuer    string   `json:"issuer,omitempty"`   // the required issuer, empty means any issuer
+	Audience  string   `json:"audience,omitempty"` // the required audience, empty means any audience
+	Leeway    Duration `json:"leeway,omitempty"`   // the clock skew of the exp and the nbf
+}
+
+// the json web key
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// the verification key of the jwt
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// the authenticator verifying the bearer jwt by the json web key sets
+type jwtAuthenticator struct {
+	config JWTConfig
+	keys   []verificationKey
+	sync.RWMutex
+}
+
+// new a jwt authenticator loading the json web key set files
+func NewJWTAuthenticator(config JWTConfig) (Authenticator, error) {
+
+	if len(config.JWKSFiles) == 0 {
+		return nil, errors.New("the jwks files of the jwt authenticator are required")
+	}
+	a := &jwtAuthenticator{config: config, RWMutex: sync.RWMutex{}}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *jwtAuthenticator) Name() string {
+	return JWTMethod
+}
+
+func (a *jwtAuthenticator) files() []string {
+	return a.config.JWKSFiles
+}
+
+// load the keys of the json web key set files
+func (a *jwtAuthenticator) load() error {
+
+	keys := make([]verificationKey, 0)
+	for _, file := range a.config.JWKSFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		set := struct {
+			Keys []jsonWebKey `json:"keys"`
+		}{}
+		if err = json.Unmarshal(content, &set); err != nil {
+			return fmt.Errorf("the jwks file:%s is invalid:%s", file, err.Error())
+		}
+		for _, jwk := range set.Keys {
+			key, err := parseJSONWebKey(jwk)
+			if err != nil {
+				return fmt.Errorf("the key:%s of the jwks file:%s is invalid:%s", jwk.Kid, file, err.Error())
+			}
+			keys = append(keys, key)
+		}
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.keys = keys
+	return nil
+}
+
+// parse the rsa, ec and oct keys
+func parseJSONWebKey(jwk jsonWebKey) (verificationKey, error) {
+
+	key := verificationKey{kid: jwk.Kid, alg: jwk.Alg}
+	decode := base64.RawURLEncoding.DecodeString
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return key, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return key, err
+		}
+		key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return key, fmt.Errorf("the curve:%s is not supported", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return key, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return key, err
+		}
+		key.key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "oct":
+		k, err := decode(jwk.K)
+		if err != nil {
+			return key, err
+		}
+		key.key = k
+	default:
+		return key, fmt.Errorf("the key type:%s is not supported", jwk.Kty)
+	}
+	return key, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return nil, ErrNoCredentials
+	}
+	parts := strings.Split(strings.TrimPrefix(authorization, bearerPrefix), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the jwt is malformed")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("the jwt signature is malformed")
+	}
+	if err = a.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = a.validate(claims); err != nil {
+		return nil, err
+	}
+	principal := &Principal{Method: JWTMethod, Claims: make(map[string]string)}
+	for name, value := range claims {
+		if v, ok := value.(string); ok {
+			if name == "sub" {
+				principal.Subject = v
+				continue
+			}
+			principal.Claims[name] = v
+		}
+	}
+	if principal.Subject == "" {
+		return nil, errors.New("the jwt subject is missing")
+	}
+	return principal, nil
+}
+
+// decode the base64 url json segment
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("the jwt is malformed")
+	}
+	if err = json.Unmarshal(content, v); err != nil {
+		return errors.New("the jwt is malformed")
+	}
+	return nil
+}
+
+// verify the signature by the key of the kid, all the keys are tried if the kid is missing
+func (a *jwtAuthenticator) verify(alg, kid string, signed string, signature []byte) error {
+
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if len(alg) != 5 {
+		return fmt.Errorf("the jwt alg:%s is not supported", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("the jwt alg:%s is not supported", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	a.RLock()
+	defer a.RUnlock()
+	for _, key := range a.keys {
+		if (kid != "" && key.kid != kid) || (key.alg != "" && key.alg != alg) {
+			continue
+		}
+		switch k := key.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(alg, "ES") && len(signature) == 2*size &&
+				ecdsa.Verify(k, digest, new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])) {
+				return nil
+			}
+		case []byte:
+			if strings.HasPrefix(alg, "HS") {
+				mac := hmac.New(hash.New, k)
+				mac.Write([]byte(signed))
+				if hmac.Equal(mac.Sum(nil), signature) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("the jwt signature is invalid")
+}
+
+// validate the exp, the nbf, the iss and the aud claims
+func (a *jwtAuthenticator) validate(claims map[string]interface{}) error {
+
+	now := time.Now()
+	leeway := time.Duration(a.config.Leeway)
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return errors.New("the jwt is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("the jwt is not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return errors.New("the jwt issuer is invalid")
+	}
+	if a.config.Audience != "" {
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud == a.config.Audience {
+				return nil
+			}
+		case []interface{}:
+			for _, v := range aud {
+				if v == a.config.Audience {
+					return nil
+				}
+			}
+		}
+		return errors.New("the jwt audience is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the per route metrics of the gateway, registered by the first gateway exposing the metrics
+var (
+	gatewayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "elsa",
+		Subsystem: "gateway",
+		Name:      "requests_total",
+		Help:      "The total number of the http requests handled by the elsa gateway.",
+	}, []string{"route", "service", "status"})
+
+	gatewayLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "elsa",
+		Subsystem: "gateway",
+		Name:      "request_duration_seconds",
+		Help:      "The latency of the http requests handled by the elsa gateway.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "service"})
+
+	gatewayResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "elsa",
+		Subsystem: "gateway",
+		Name:      "response_size_bytes",
+		Help:      "The body size of the http responses written by the elsa gateway.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"route", "service"})
+
+	registerMetricsOnce sync.Once
+)
+
+// register the gateway metrics to the default registry once
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		prometheus.MustRegister(gatewayRequests, gatewayLatency, gatewayResponseSize)
+	})
+}
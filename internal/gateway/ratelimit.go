@@ -0,0 +1,385 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/etcd"
+	"github.com/busgo/elsa/pkg/limiter"
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the limit scopes
+const (
+	RouteScope = "route"
+	KeyScope   = "key"
+	IPScope    = "ip"
+)
+
+const (
+	// the day of the quotas in utc
+	quotaWindow = time.Hour * 24
+	// the idle local buckets and usages are removed
+	idleTimeout = time.Hour * 24
+	// the interval to remove the idle buckets and the expired counters
+	cleanInterval = time.Minute
+)
+
+// the limit of a scope
+type Limit struct {
+	Rate  float64 `json:"rate,omitempty"`  // the requests per second, 0 means no rate limit
+	Burst int     `json:"burst,omitempty"` // the burst of the rate, default is the ceil of the rate
+	Quota int64   `json:"quota,omitempty"` // the requests per day in utc, 0 means no quota
+}
+
+// the burst of the rate
+func (l *Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Ceil(l.Rate))
+}
+
+// the limits of a route
+type Limits struct {
+	Route *Limit            `json:"route,omitempty"` // shared by all the clients of the route
+	Key   *Limit            `json:"key,omitempty"`   // per authenticated subject like the api key
+	IP    *Limit            `json:"ip,omitempty"`    // per client ip
+	Keys  map[string]*Limit `json:"keys,omitempty"`  // the limits of the subjects overriding the key limit
+}
+
+// the counter store of the fixed windows shared by the gateways
+type CounterStore interface {
+	// increase the counter of the key in the window, return the count after increased
+	Incr(ctx context.Context, key string, n int64, window time.Duration) (int64, error)
+}
+
+// the counter store on etcd for the cluster wide limits
+type etcdCounterStore struct {
+	cli    *etcd.Cli
+	prefix string
+}
+
+// new a counter store on etcd, the keys are under the prefix like /elsa/gateway/limits
+func NewEtcdCounterStore(cli *etcd.Cli, prefix string) CounterStore {
+	return &etcdCounterStore{cli: cli, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+func (s *etcdCounterStore) Incr(ctx context.Context, key string, n int64, window time.Duration) (int64, error) {
+	ttl := int64(math.Ceil(window.Seconds()))
+	return s.cli.Incr(ctx, s.prefix+"/"+key, n, ttl)
+}
+
+// the counter of the memory store
+type counter struct {
+	count    int64
+	expireAt time.Time
+}
+
+// the counter store in memory for the local limits
+type memoryStore struct {
+	counters map[string]*counter
+	sync.Mutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{counters: make(map[string]*counter), Mutex: sync.Mutex{}}
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, n int64, window time.Duration) (int64, error) {
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expireAt) {
+		c = &counter{expireAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count += n
+	return c.count, nil
+}
+
+// remove the expired counters
+func (s *memoryStore) clean(now time.Time) {
+
+	s.Lock()
+	defer s.Unlock()
+	for key, c := range s.counters {
+		if now.After(c.expireAt) {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// the usage of a limited key
+type Usage struct {
+	Scope     string    `json:"scope"`
+	Route     string    `json:"route"`
+	ID        string    `json:"id,omitempty"` // the subject of the key scope or the ip of the ip scope
+	Allowed   int64     `json:"allowed"`
+	Rejected  int64     `json:"rejected"`
+	Rate      float64   `json:"rate,omitempty"`
+	Tokens    float64   `json:"tokens,omitempty"` // the left tokens of the local bucket
+	Quota     int64     `json:"quota,omitempty"`
+	QuotaUsed int64     `json:"quotaUsed,omitempty"` // the used quota of today
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// the local bucket of a limited key
+type bucket struct {
+	tb    *limiter.TokenBucket
+	rate  float64
+	burst int
+}
+
+// the rate limiter of the routes, the rates use the local token buckets or the fixed windows of the shared store
+type rateLimiter struct {
+	store      CounterStore
+	quotas     CounterStore
+	memory     *memoryStore
+	buckets    map[string]*bucket
+	usages     map[string]*Usage
+	closedChan chan bool
+	sync.RWMutex
+}
+
+// new a rate limiter, the nil store means the local limits
+func newRateLimiter(store CounterStore) *rateLimiter {
+
+	l := &rateLimiter{
+		store:      store,
+		quotas:     store,
+		memory:     newMemoryStore(),
+		buckets:    make(map[string]*bucket),
+		usages:     make(map[string]*Usage),
+		closedChan: make(chan bool),
+		RWMutex:    sync.RWMutex{},
+	}
+	if store == nil {
+		l.quotas = l.memory
+	}
+	go l.lookup()
+	return l
+}
+
+// the limit of a scope
+type scopeLimit struct {
+	scope string
+	id    string
+	limit *Limit
+}
+
+// allow the request of the route, the retry after is returned if the request is limited
+func (l *rateLimiter) allow(ctx context.Context, route string, limits *Limits, subject, ip string) (time.Duration, error) {
+
+	if limits == nil {
+		return 0, nil
+	}
+	checks := make([]scopeLimit, 0, 3)
+	if limits.Route != nil {
+		checks = append(checks, scopeLimit{scope: RouteScope, limit: limits.Route})
+	}
+	if subject != "" {
+		limit := limits.Key
+		if override, ok := limits.Keys[subject]; ok {
+			limit = override
+		}
+		if limit != nil {
+			checks = append(checks, scopeLimit{scope: KeyScope, id: subject, limit: limit})
+		}
+	}
+	if limits.IP != nil && ip != "" {
+		checks = append(checks, scopeLimit{scope: IPScope, id: ip, limit: limits.IP})
+	}
+
+	for _, check := range checks {
+		key := fmt.Sprintf("%s/%s/%s", check.scope, route, check.id)
+		usage := l.usage(key, route, check)
+		retryAfter, reason, err := l.take(ctx, key, check.limit, usage)
+		if err != nil {
+			// fail open when the shared store is unavailable
+			log.Warnf("the gateway limit the key:%s fail:%s", key, err.Error())
+			continue
+		}
+		if reason != "" {
+			l.Lock()
+			usage.Rejected++
+			l.Unlock()
+			return retryAfter, status.Errorf(codes.ResourceExhausted, "the %s %s of the route:%s is exceeded", check.scope, reason, route)
+		}
+	}
+	l.Lock()
+	defer l.Unlock()
+	for _, check := range checks {
+		l.usages[fmt.Sprintf("%s/%s/%s", check.scope, route, check.id)].Allowed++
+	}
+	return 0, nil
+}
+
+// the usage of the key
+func (l *rateLimiter) usage(key, route string, check scopeLimit) *Usage {
+
+	l.Lock()
+	defer l.Unlock()
+	usage, ok := l.usages[key]
+	if !ok {
+		usage = &Usage{Scope: check.scope, Route: route, ID: check.id}
+		l.usages[key] = usage
+	}
+	usage.Rate = check.limit.Rate
+	usage.Quota = check.limit.Quota
+	usage.LastSeen = time.Now()
+	return usage
+}
+
+// take a token and a quota of the key, the reason is not empty if the request is limited
+func (l *rateLimiter) take(ctx context.Context, key string, limit *Limit, usage *Usage) (time.Duration, string, error) {
+
+	now := time.Now()
+	if limit.Rate > 0 {
+		if l.store == nil {
+			b := l.bucket(key, limit)
+			if !b.AllowN(now, 1) {
+				wait := time.Duration((1 - b.Tokens()) / limit.Rate * float64(time.Second))
+				return wait, "rate limit", nil
+			}
+		} else {
+			// the fixed window holding the burst
+			burst := limit.burst()
+			window := time.Duration(math.Max(float64(time.Second), float64(burst)/limit.Rate*float64(time.Second)))
+			start := now.Truncate(window)
+			count, err := l.store.Incr(ctx, fmt.Sprintf("rate/%s/%d", key, start.Unix()), 1, window)
+			if err != nil {
+				return 0, "", err
+			}
+			if count > int64(burst) {
+				return start.Add(window).Sub(now), "rate limit", nil
+			}
+		}
+	}
+	if limit.Quota > 0 {
+		day := now.UTC().Truncate(quotaWindow)
+		count, err := l.quotas.Incr(ctx, fmt.Sprintf("quota/%s/%s", key, day.Format("20060102")), 1, day.Add(quotaWindow).Sub(now))
+		if err != nil {
+			return 0, "", err
+		}
+		l.Lock()
+		usage.QuotaUsed = count
+		l.Unlock()
+		if count > limit.Quota {
+			return day.Add(quotaWindow).Sub(now), "daily quota", nil
+		}
+	}
+	return 0, "", nil
+}
+
+// the local token bucket of the key, the limit of the bucket follows the reloaded routes
+func (l *rateLimiter) bucket(key string, limit *Limit) *limiter.TokenBucket {
+
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tb: limiter.NewTokenBucket(limit.Rate, limit.Burst), rate: limit.Rate, burst: limit.Burst}
+		l.buckets[key] = b
+	} else if b.rate != limit.Rate || b.burst != limit.Burst {
+		b.tb.SetLimit(limit.Rate, limit.Burst)
+		b.rate, b.burst = limit.Rate, limit.Burst
+	}
+	return b.tb
+}
+
+// the usages sorted by the route, the scope and the id
+func (l *rateLimiter) report(route string) []Usage {
+
+	l.RLock()
+	usages := make([]Usage, 0, len(l.usages))
+	for key, usage := range l.usages {
+		if route != "" && usage.Route != route {
+			continue
+		}
+		u := *usage
+		if b, ok := l.buckets[key]; ok {
+			u.Tokens = b.tb.Tokens()
+		}
+		usages = append(usages, u)
+	}
+	l.RUnlock()
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Route != usages[j].Route {
+			return usages[i].Route < usages[j].Route
+		}
+		if usages[i].Scope != usages[j].Scope {
+			return usages[i].Scope < usages[j].Scope
+		}
+		return usages[i].ID < usages[j].ID
+	})
+	return usages
+}
+
+func (l *rateLimiter) lookup() {
+
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.memory.clean(now)
+			l.Lock()
+			for key, usage := range l.usages {
+				if now.Sub(usage.LastSeen) > idleTimeout {
+					delete(l.usages, key)
+					delete(l.buckets, key)
+				}
+			}
+			l.Unlock()
+		case <-l.closedChan:
+			return
+		}
+	}
+}
+
+// stop cleaning
+func (l *rateLimiter) close() {
+	close(l.closedChan)
+}
+
+// the client ip of the request, the first address of the header is used if the header is set like X-Forwarded-For
+func clientIP(r *http.Request, header string) string {
+
+	if header != "" {
+		if value := r.Header.Get(header); value != "" {
+			return strings.TrimSpace(strings.Split(value, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// the retry after seconds, at least 1 second
+func retrySeconds(retryAfter time.Duration) int64 {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// set the retry after header of the limited request
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(retrySeconds(retryAfter), 10))
+}
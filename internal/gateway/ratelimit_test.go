@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		store CounterStore
+	}{
+		{"local", nil},
+		{"shared", newMemoryStore()},
+	}
+	for _, c := range cases {
+		l := newRateLimiter(c.store)
+		limits := &Limits{
+			Key:  &Limit{Rate: 1, Burst: 2},
+			IP:   &Limit{Quota: 3},
+			Keys: map[string]*Limit{"vip": {Rate: 100, Burst: 100}},
+		}
+		ctx := context.Background()
+		for i := 0; i < 2; i++ {
+			if _, err := l.allow(ctx, "orders", limits, "team-a", "10.0.0.1"); err != nil {
+				t.Fatalf("%s: the burst request:%d must be allowed but %s", c.name, i, err.Error())
+			}
+		}
+		retryAfter, err := l.allow(ctx, "orders", limits, "team-a", "10.0.0.1")
+		if err == nil || retryAfter <= 0 || retryAfter > time.Second*2 {
+			t.Fatalf("%s: the key rate must be limited with the retry after but %v %v", c.name, retryAfter, err)
+		}
+		// the override of the subject and the quota of the ip
+		if _, err = l.allow(ctx, "orders", limits, "vip", "10.0.0.1"); err != nil {
+			t.Fatalf("%s: the vip must be allowed but %s", c.name, err.Error())
+		}
+		if retryAfter, err = l.allow(ctx, "orders", limits, "vip", "10.0.0.1"); err == nil || retryAfter < time.Second {
+			t.Fatalf("%s: the ip quota must be limited until the next day but %v %v", c.name, retryAfter, err)
+		}
+		if _, err = l.allow(ctx, "orders", limits, "vip", "10.0.0.2"); err != nil {
+			t.Fatalf("%s: the other ip must be allowed but %s", c.name, err.Error())
+		}
+
+		usages := l.report("orders")
+		if len(usages) != 4 {
+			t.Fatalf("%s: the usages must be 4 but %+v", c.name, usages)
+		}
+		if u := usages[0]; u.Scope != IPScope || u.ID != "10.0.0.1" || u.Allowed != 3 || u.Rejected != 1 || u.QuotaUsed != 4 {
+			t.Fatalf("%s: the usage of the ip is wrong:%+v", c.name, u)
+		}
+		l.close()
+	}
+}
+
+func TestGateway_RateLimit(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routeFile := filepath.Join(dir, "routes.json")
+	writeRoutes(t, routeFile, RouteConfig{
+		Routes: []Route{
+			{Name: "fetch", Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch",
+				Limits: &Limits{Route: &Limit{Rate: 0.001, Burst: 1}}},
+		},
+		DefaultLimits: &Limits{IP: &Limit{Quota: 1}},
+	})
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour), WithClientIPHeader("X-Forwarded-For"))
+	defer closeFunc()
+
+	if recorder := serve(g, http.MethodGet, "/v1/dev/services/trade", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("the first request must be allowed but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	recorder := serve(g, http.MethodGet, "/v1/dev/services/trade", "")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("the route rate must be limited with the retry after but %d:%v", recorder.Code, recorder.Header())
+	}
+
+	// the default limits of the default route
+	path := "/dev/com.busgo.registry.proto.RegistryService/fetch"
+	for i, code := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"segment":"dev","serviceName":"trade"}`))
+		r.Header.Set("X-Forwarded-For", "10.0.0.1, 192.168.0.1")
+		recorder = httptest.NewRecorder()
+		if g.ServeHTTP(recorder, r); recorder.Code != code {
+			t.Fatalf("the request:%d of the default route must be %d but %d:%s", i, code, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	g.adminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/usage?route=fetch", nil))
+	usages := make([]Usage, 0)
+	if err = json.Unmarshal(recorder.Body.Bytes(), &usages); err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 1 || usages[0].Scope != RouteScope || usages[0].Allowed != 1 || usages[0].Rejected != 1 {
+		t.Fatalf("the usage of the route must be reported but %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// the min interval to fetch the descriptors of a service again when a method is missing
+const refreshInterval = time.Second * 10
+
+// the descriptors of a service fetched by the server reflection
+type serviceDescriptor struct {
+	desc    protoreflect.ServiceDescriptor
+	files   *protoregistry.Files
+	types   *typeResolver
+	fetched time.Time
+}
+
+// the descriptor source of the services behind a client conn
+type descriptorSource struct {
+	cc       *grpc.ClientConn
+	services map[string]*serviceDescriptor
+	sync.RWMutex
+}
+
+func newDescriptorSource(cc *grpc.ClientConn) *descriptorSource {
+	return &descriptorSource{
+		cc:       cc,
+		services: make(map[string]*serviceDescriptor),
+		RWMutex:  sync.RWMutex{},
+	}
+}
+
+// find the method of the service, the descriptors are fetched again if the method is missing
+func (d *descriptorSource) findMethod(ctx context.Context, service, method string) (protoreflect.MethodDescriptor, *typeResolver, error) {
+
+	d.RLock()
+	sd, ok := d.services[service]
+	d.RUnlock()
+	if ok {
+		if md := sd.desc.Methods().ByName(protoreflect.Name(method)); md != nil {
+			return md, sd.types, nil
+		}
+		if time.Since(sd.fetched) < refreshInterval {
+			return nil, nil, status.Errorf(codes.NotFound, "the method:%s of the service:%s not found", method, service)
+		}
+	}
+
+	sd, err := d.refresh(ctx, service)
+	if err != nil {
+		return nil, nil, err
+	}
+	if md := sd.desc.Methods().ByName(protoreflect.Name(method)); md != nil {
+		return md, sd.types, nil
+	}
+	return nil, nil, status.Errorf(codes.NotFound, "the method:%s of the service:%s not found", method, service)
+}
+
+// fetch the descriptors of the service again and cache them
+func (d *descriptorSource) refresh(ctx context.Context, service string) (*serviceDescriptor, error) {
+
+	sd, err := d.fetch(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	d.Lock()
+	d.services[service] = sd
+	d.Unlock()
+	return sd, nil
+}
+
+// fetch the file descriptors of the service and the dependencies
+func (d *descriptorSource) fetch(ctx context.Context, service string) (*serviceDescriptor, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(d.cc).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	requested := make(map[string]bool)
+	request := &rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service}}
+	for request != nil {
+		if err = stream.Send(request); err != nil {
+			return nil, err
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := response.GetErrorResponse(); e != nil {
+			return nil, status.Errorf(codes.Code(e.ErrorCode), "the reflection of the service:%s fail:%s", service, e.ErrorMessage)
+		}
+		for _, content := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := new(descriptorpb.FileDescriptorProto)
+			if err = proto.Unmarshal(content, file); err != nil {
+				return nil, err
+			}
+			files[file.GetName()] = file
+		}
+		// request the missing dependencies one by one
+		request = nil
+		for _, file := range files {
+			for _, dependency := range file.GetDependency() {
+				if _, ok := files[dependency]; !ok {
+					if requested[dependency] {
+						return nil, status.Errorf(codes.NotFound, "the dependency:%s of the service:%s not found", dependency, service)
+					}
+					requested[dependency] = true
+					request = &rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dependency}}
+					break
+				}
+			}
+			if request != nil {
+				break
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range files {
+		set.File = append(set.File, file)
+	}
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := registry.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "the service:%s not found", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "the symbol:%s is not a service", service)
+	}
+	return &serviceDescriptor{desc: sd, files: registry, types: &typeResolver{files: registry}, fetched: time.Now()}, nil
+}
+
+// the type resolver of the dynamic messages for the Any fields, fall back to the global types
+type typeResolver struct {
+	files *protoregistry.Files
+}
+
+func (r *typeResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	desc, err := r.files.FindDescriptorByName(name)
+	if err != nil {
+		return protoregistry.GlobalTypes.FindMessageByName(name)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("the symbol:%s is not a message", name)
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+func (r *typeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := url
+	if index := strings.LastIndex(url, "/"); index >= 0 {
+		name = url[index+1:]
+	}
+	return r.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (r *typeResolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByName(field)
+}
+
+func (r *typeResolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+}
+
+// no service has been fetched
+func (d *descriptorSource) empty() bool {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.services) == 0
+}
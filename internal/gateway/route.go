@@ -0,0 +1,467 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+)
+
+const (
+	DefaultReloadInterval   = time.Second * 5  // the default interval to check the route file changed
+	DefaultDiscoverInterval = time.Second * 30 // the default interval to discover the routes of the services
+)
+
+// the route matches any http method
+const anyMethod = "*"
+
+// the duration in the route file like 3s or 500ms
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(content []byte) error {
+
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v))
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+	default:
+		return fmt.Errorf("the duration:%s is invalid", string(content))
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// the route of the gateway mapping the http request to the backend method
+type Route struct {
+	Name          string           `json:"name,omitempty"`
+	Method        string           `json:"method"`                  // the http method, * matches any method
+	Path          string           `json:"path"`                    // the path template like /v1/orders/{id}
+	Segment       string           `json:"segment,omitempty"`       // the target segment, default is the segment of the registry stub
+	Service       string           `json:"service"`                 // the full service name like com.busgo.trade.proto.TradeService
+	RPC           string           `json:"rpc"`                     // the method name of the service
+	Body          string           `json:"body,omitempty"`          // * binds the whole body, a field path binds the body to the field, empty means no body
+	ResponseBody  string           `json:"responseBody,omitempty"`  // the response field written as the body, empty means the whole response
+	Timeout       Duration         `json:"timeout,omitempty"`       // the timeout of the backend call, default is the gateway timeout
+	Source        string           `json:"source,omitempty"`        // the source of the route set by the gateway, file or annotation
+	Auth          *AuthRequirement `json:"auth,omitempty"`          // the auth requirement, default is the default auth of the route file
+	Limits        *Limits          `json:"limits,omitempty"`        // the rate limits and the daily quotas, default is the default limits of the route file
+	Transform     *Transform       `json:"transform,omitempty"`     // the headers, the fields, the cors and the status codes, default is the default transform of the route file
+	LogSampleRate *float64         `json:"logSampleRate,omitempty"` // the access log sample rate of the successful requests, default is the rate of the gateway
+	Cache         *CachePolicy     `json:"cache,omitempty"`         // cache the responses of the idempotent route, the responses are not cached by default
+}
+
+// the route file of the gateway
+type RouteConfig struct {
+	Routes              []Route          `json:"routes"`
+	Services            []string         `json:"services,omitempty"`            // the services generating the routes by the google.api.http rules, like dev/com.busgo.trade.proto.TradeService
+	DisableDefaultRoute bool             `json:"disableDefaultRoute,omitempty"` // disable the route POST /{segment}/{service}/{method} enabled by the gateway
+	DefaultAuth         *AuthRequirement `json:"defaultAuth,omitempty"`         // the auth requirement of the routes without the auth, the default route and the grpc-web calls
+	DefaultLimits       *Limits          `json:"defaultLimits,omitempty"`       // the limits of the routes without the limits, the default route and the grpc-web calls
+	DefaultTransform    *Transform       `json:"defaultTransform,omitempty"`    // the transform of the routes without the transform, the default route and the grpc-web calls
+}
+
+// load the route config from the json file
+func LoadRouteConfig(file string) (RouteConfig, error) {
+
+	config := RouteConfig{}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("the route file:%s is invalid:%s", file, err.Error())
+	}
+	for i := range config.Routes {
+		config.Routes[i].Source = FileSource
+	}
+	return config, nil
+}
+
+// the compiled route
+type route struct {
+	config        Route
+	name          string
+	method        string
+	template      *pathTemplate
+	segment       string
+	service       string
+	rpc           string
+	body          string
+	responseBody  string
+	timeout       time.Duration
+	auth          *AuthRequirement
+	limits        *Limits
+	transform     *transform
+	logSampleRate *float64
+	cache         *CachePolicy
+}
+
+// compile the route
+func compileRoute(r Route) (*route, error) {
+
+	if r.Service == "" || r.RPC == "" {
+		return nil, errors.New("the service and the rpc of the route are required")
+	}
+	method := strings.ToUpper(r.Method)
+	if method == "" {
+		return nil, errors.New("the method of the route is required")
+	}
+	template, err := parseTemplate(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	// the path variables must not be bound by the body field
+	for _, v := range template.variables {
+		if r.Body != "" && r.Body != bodyWildcard && (v.field == r.Body || strings.HasPrefix(v.field, r.Body+".")) {
+			return nil, fmt.Errorf("the path variable:%s is bound by the body field:%s", v.field, r.Body)
+		}
+	}
+	if r.LogSampleRate != nil && (*r.LogSampleRate < 0 || *r.LogSampleRate > 1) {
+		return nil, fmt.Errorf("the log sample rate:%v must be in [0,1]", *r.LogSampleRate)
+	}
+	if r.Cache != nil && r.Cache.TTL <= 0 {
+		return nil, errors.New("the ttl of the cache policy must be positive")
+	}
+	transform, err := compileTransform(r.Transform)
+	if err != nil {
+		return nil, err
+	}
+	name := r.Name
+	if name == "" {
+		name = fmt.Sprintf("%s %s", method, r.Path)
+	}
+	r.Name = name
+	return &route{
+		config:        r,
+		name:          name,
+		method:        method,
+		template:      template,
+		segment:       r.Segment,
+		service:       r.Service,
+		rpc:           r.RPC,
+		body:          r.Body,
+		responseBody:  r.ResponseBody,
+		timeout:       time.Duration(r.Timeout),
+		auth:          r.Auth,
+		limits:        r.Limits,
+		transform:     transform,
+		logSampleRate: r.LogSampleRate,
+		cache:         r.Cache,
+	}, nil
+}
+
+// the router matches the routes by the http method and the path
+type router struct {
+	routes              []*route
+	disableDefaultRoute bool
+	defaultAuth         *AuthRequirement
+	defaultLimits       *Limits
+	defaultTransform    *transform
+}
+
+// new a router of the config, the invalid and the conflicted routes are skipped and reported, the former route wins the conflict
+func newRouter(config RouteConfig) (*router, []error) {
+
+	rt := &router{disableDefaultRoute: config.DisableDefaultRoute, defaultAuth: config.DefaultAuth, defaultLimits: config.DefaultLimits}
+	problems := make([]error, 0)
+	transform, err := compileTransform(config.DefaultTransform)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("the default transform is invalid:%s", err.Error()))
+	}
+	rt.defaultTransform = transform
+	keys := make(map[string]string)
+	for _, r := range config.Routes {
+		compiled, err := compileRoute(r)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("the route:%s %s is invalid:%s", r.Method, r.Path, err.Error()))
+			continue
+		}
+		key := compiled.method + " " + compiled.template.normalized()
+		if name, ok := keys[key]; ok {
+			problems = append(problems, fmt.Errorf("the route:%s conflicts with the route:%s", compiled.name, name))
+			continue
+		}
+		keys[key] = compiled.name
+		rt.routes = append(rt.routes, compiled)
+	}
+	// the routes with more literal segments are matched first
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].template.literals() > rt.routes[j].template.literals()
+	})
+	return rt, problems
+}
+
+// match the route, the allowed methods are returned if the path matches but the method does not
+func (rt *router) match(method, path string) (*route, map[string]string, []string) {
+
+	allowed := make([]string, 0)
+	for _, r := range rt.routes {
+		variables, ok := r.template.match(path)
+		if !ok {
+			continue
+		}
+		if r.method == method || r.method == anyMethod {
+			return r, variables, nil
+		}
+		allowed = append(allowed, r.method)
+	}
+	return nil, nil, allowed
+}
+
+// the configured route of the backend method in the segment, nil if no route calls the method
+func (rt *router) find(segment, service, method string) *route {
+
+	for _, r := range rt.routes {
+		if r.segment == segment && r.service == service && r.rpc == method {
+			return r
+		}
+	}
+	return nil
+}
+
+// the call of the route, the policies missing in the route are the defaults of the router
+func (rt *router) call(r *route, segment, service, method string, variables map[string]string) call {
+
+	c := call{segment: segment, service: service, method: method, route: r, variables: variables,
+		auth: rt.defaultAuth, limits: rt.defaultLimits, transform: rt.defaultTransform}
+	if r == nil {
+		return c
+	}
+	if r.auth != nil {
+		c.auth = r.auth
+	}
+	if r.limits != nil {
+		c.limits = r.limits
+	}
+	if r.transform != nil {
+		c.transform = r.transform
+	}
+	return c
+}
+
+// discover the routes of the service in the segment
+type discoverFunc func(segment, service string) ([]Route, []error, error)
+
+// the routes of the route file and the discovered services with the problems
+type RouteReport struct {
+	Routes   []Route   `json:"routes"`
+	Problems []string  `json:"problems"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// the route table reloaded from the route file when the file changed, the services are discovered periodically
+type routeTable struct {
+	file             string
+	interval         time.Duration
+	discoverInterval time.Duration
+	discover         discoverFunc
+	modTime          time.Time
+	config           RouteConfig
+	discovered       map[string][]Route
+	discoverProblems map[string][]error
+	router           *router
+	problems         []string
+	loadedAt         time.Time
+	closedChan       chan bool
+	sync.RWMutex
+}
+
+// new a route table, the empty file means no route
+func newRouteTable(file string, interval, discoverInterval time.Duration, discover discoverFunc) (*routeTable, error) {
+
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	if discoverInterval <= 0 {
+		discoverInterval = DefaultDiscoverInterval
+	}
+	t := &routeTable{
+		file:             file,
+		interval:         interval,
+		discoverInterval: discoverInterval,
+		discover:         discover,
+		discovered:       make(map[string][]Route),
+		discoverProblems: make(map[string][]error),
+		router:           &router{},
+		problems:         make([]string, 0),
+		loadedAt:         time.Now(),
+		closedChan:       make(chan bool),
+		RWMutex:          sync.RWMutex{},
+	}
+	if file == "" {
+		return t, nil
+	}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	go t.lookup()
+	return t, nil
+}
+
+// load the route file and discover the services, the backends are kept
+func (t *routeTable) load() error {
+
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return err
+	}
+	config, err := LoadRouteConfig(t.file)
+	if err != nil {
+		return err
+	}
+	t.Lock()
+	t.config = config
+	t.modTime = info.ModTime()
+	t.Unlock()
+	t.discoverAll()
+	return nil
+}
+
+// discover the routes of the services and rebuild the router, keep the routes discovered before if the service is unavailable
+func (t *routeTable) discoverAll() {
+
+	t.RLock()
+	services := t.config.Services
+	t.RUnlock()
+
+	discovered := make(map[string][]Route)
+	discoverProblems := make(map[string][]error)
+	for _, name := range services {
+		segment, service := splitService(name)
+		routes, problems, err := t.discover(segment, service)
+		if err != nil {
+			t.RLock()
+			routes = t.discovered[name]
+			t.RUnlock()
+			problems = []error{fmt.Errorf("discover the service:%s fail:%s", name, err.Error())}
+		}
+		discovered[name] = routes
+		discoverProblems[name] = problems
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	t.discovered = discovered
+	t.discoverProblems = discoverProblems
+	t.rebuild()
+}
+
+// rebuild the router with the routes of the file and the services, the routes of the file win the conflicts
+func (t *routeTable) rebuild() {
+
+	routes := append(make([]Route, 0), t.config.Routes...)
+	problems := make([]string, 0)
+	for _, name := range t.config.Services {
+		routes = append(routes, t.discovered[name]...)
+		for _, problem := range t.discoverProblems[name] {
+			problems = append(problems, problem.Error())
+		}
+	}
+	config := t.config
+	config.Routes = routes
+	rt, routeProblems := newRouter(config)
+	for _, problem := range routeProblems {
+		problems = append(problems, problem.Error())
+	}
+
+	// only report the new problems
+	reported := make(map[string]bool, len(t.problems))
+	for _, problem := range t.problems {
+		reported[problem] = true
+	}
+	for _, problem := range problems {
+		if !reported[problem] {
+			log.Warnf("the gateway route problem:%s", problem)
+		}
+	}
+	t.router = rt
+	t.problems = problems
+	t.loadedAt = time.Now()
+}
+
+// check the route file changed
+func (t *routeTable) changed() bool {
+
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return false
+	}
+	t.RLock()
+	defer t.RUnlock()
+	return !info.ModTime().Equal(t.modTime)
+}
+
+func (t *routeTable) lookup() {
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	discoverTicker := time.NewTicker(t.discoverInterval)
+	defer discoverTicker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !t.changed() {
+				continue
+			}
+			// keep the old routes when the new file is broken, e.g. the file is being written
+			if err := t.load(); err != nil {
+				log.Warnf("reload the route file:%s fail:%s", t.file, err.Error())
+				continue
+			}
+			log.Infof("reload the route file:%s success", t.file)
+		case <-discoverTicker.C:
+			t.discoverAll()
+		case <-t.closedChan:
+			return
+		}
+	}
+}
+
+// the current router
+func (t *routeTable) current() *router {
+	t.RLock()
+	defer t.RUnlock()
+	return t.router
+}
+
+// the report of the current routes and the problems
+func (t *routeTable) report() RouteReport {
+
+	t.RLock()
+	defer t.RUnlock()
+	report := RouteReport{
+		Routes:   make([]Route, 0, len(t.router.routes)),
+		Problems: append(make([]string, 0), t.problems...),
+		LoadedAt: t.loadedAt,
+	}
+	for _, r := range t.router.routes {
+		report.Routes = append(report.Routes, r.config)
+	}
+	return report
+}
+
+// stop watching the route file
+func (t *routeTable) close() {
+	if t.file != "" {
+		close(t.closedChan)
+	}
+}
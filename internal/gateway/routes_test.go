@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathTemplate_Match(t *testing.T) {
+
+	cases := []struct {
+		template  string
+		path      string
+		ok        bool
+		variables map[string]string
+	}{
+		{"/v1/orders/{id}", "/v1/orders/1", true, map[string]string{"id": "1"}},
+		{"/v1/orders/{id}", "/v1/orders/1/items", false, nil},
+		{"/v1/orders/{id}", "/v1/orders", false, nil},
+		{"/v1/orders/{order.id}/items/*", "/v1/orders/a%2Fb/items/2", true, map[string]string{"order.id": "a/b"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", true, map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c", true, map[string]string{"path": "a/b/c"}},
+		{"/v1/orders/{id}:cancel", "/v1/orders/1:cancel", true, map[string]string{"id": "1"}},
+		{"/v1/orders/{id}", "/v1/orders/1:cancel", false, nil},
+	}
+	for _, c := range cases {
+		template, err := parseTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		variables, ok := template.match(c.path)
+		if ok != c.ok {
+			t.Fatalf("the template:%s match the path:%s must be %v", c.template, c.path, c.ok)
+		}
+		for name, value := range c.variables {
+			if variables[name] != value {
+				t.Fatalf("the variable:%s of the path:%s must be %s but %s", name, c.path, value, variables[name])
+			}
+		}
+	}
+
+	for _, invalid := range []string{"v1/orders", "/v1/orders/", "/v1/{id", "/v1/**/orders", "/v1/{id}/{id}", "/v1/or*ders"} {
+		if _, err := parseTemplate(invalid); err == nil {
+			t.Fatalf("the template:%s must be invalid", invalid)
+		}
+	}
+}
+
+func TestRouter_Conflicts(t *testing.T) {
+
+	rt, problems := newRouter(RouteConfig{Routes: []Route{
+		{Method: "get", Path: "/v1/orders/{id}", Service: "s", RPC: "Get"},
+		{Method: "GET", Path: "/v1/orders/{orderId}", Service: "s", RPC: "Find"},
+		{Method: "GET", Path: "/v1/orders/latest", Service: "s", RPC: "Latest"},
+		{Method: "POST", Path: "/v1/orders", Service: "s"},
+	}})
+	if len(problems) != 2 || len(rt.routes) != 2 {
+		t.Fatalf("the router must have 2 routes and 2 problems but %d and %v", len(rt.routes), problems)
+	}
+	if r, _, _ := rt.match(http.MethodGet, "/v1/orders/latest"); r == nil || r.rpc != "Latest" {
+		t.Fatalf("the literal route must be matched first")
+	}
+	if r, _, allowed := rt.match(http.MethodDelete, "/v1/orders/1"); r != nil || len(allowed) != 1 || allowed[0] != http.MethodGet {
+		t.Fatalf("the allowed methods must be GET but %v", allowed)
+	}
+}
+
+func writeRoutes(t *testing.T, file string, config RouteConfig) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGateway_Routes(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes.json")
+	writeRoutes(t, file, RouteConfig{Routes: []Route{
+		{Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch", ResponseBody: "instances"},
+		{Method: http.MethodPut, Path: "/v1/{segment}/services/{serviceName}/instances/{ip}", Service: "com.busgo.registry.proto.RegistryService", RPC: "renew", Body: "port", Timeout: Duration(time.Second)},
+	}})
+
+	g, closeFunc := newTestGateway(t, WithRouteFile(file, time.Millisecond*10))
+	defer closeFunc()
+
+	recorder := serve(g, http.MethodGet, "/v1/dev/services/trade", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the status must be 200 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	instances := make([]map[string]interface{}, 0)
+	if err = json.Unmarshal(recorder.Body.Bytes(), &instances); err != nil || len(instances) != 1 || instances[0]["serviceName"] != "trade" {
+		t.Fatalf("the response body must be the instances but %s", recorder.Body.String())
+	}
+
+	recorder = serve(g, http.MethodPut, "/v1/dev/services/trade/instances/10.0.0.1?syncType=Yes&unknown=1", "8001")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("the status must be 200 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	response := struct {
+		Code     int `json:"code"`
+		Instance struct {
+			Segment string `json:"segment"`
+			Ip      string `json:"ip"`
+			Port    int    `json:"port"`
+		} `json:"instance"`
+	}{}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != 1 || response.Instance.Segment != "dev" || response.Instance.Ip != "10.0.0.1" || response.Instance.Port != 8001 {
+		t.Fatalf("the response is unexpected:%s", recorder.Body.String())
+	}
+	if recorder = serve(g, http.MethodPut, "/v1/dev/services/trade/instances/10.0.0.1?port=x", ""); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("the status must be 400 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+
+	// reload the routes and disable the default route, the backend conns are kept
+	cc, err := g.conns.get("", "com.busgo.registry.proto.RegistryService")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.conns.release(cc)
+	time.Sleep(time.Millisecond * 20)
+	writeRoutes(t, file, RouteConfig{DisableDefaultRoute: true, Routes: []Route{
+		{Method: http.MethodGet, Path: "/v2/{segment}/services/{serviceName}", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch"},
+	}})
+	deadline := time.Now().Add(time.Second * 3)
+	for serve(g, http.MethodGet, "/v2/dev/services/trade", "").Code != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("the routes must be reloaded")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if recorder = serve(g, http.MethodGet, "/v1/dev/services/trade", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("the old route must be removed but %d", recorder.Code)
+	}
+	if recorder = serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{"serviceName":"trade"}`); recorder.Code != http.StatusNotFound {
+		t.Fatalf("the default route must be disabled but %d", recorder.Code)
+	}
+	reused, err := g.conns.get("", "com.busgo.registry.proto.RegistryService")
+	if err != nil || reused != cc {
+		t.Fatal("the backend conn must be kept after the reload")
+	}
+	g.conns.release(reused)
+}
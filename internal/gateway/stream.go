@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	ndjsonContentType      = "application/x-ndjson"
+)
+
+// the writer of the server streaming messages
+type streamWriter interface {
+	// the content type of the stream
+	contentType() string
+	// write a message
+	writeMessage(w io.Writer, content []byte) error
+	// write the error ending the stream
+	writeError(w io.Writer, s *status.Status) error
+}
+
+// the server sent events, the messages are the message events and the error is the error event
+type eventStreamWriter struct{}
+
+func (eventStreamWriter) contentType() string {
+	return eventStreamContentType
+}
+
+func (eventStreamWriter) writeMessage(w io.Writer, content []byte) error {
+	_, err := fmt.Fprintf(w, "data: %s\n\n", content)
+	return err
+}
+
+func (eventStreamWriter) writeError(w io.Writer, s *status.Status) error {
+	content, err := json.Marshal(newErrorBody(s))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: error\ndata: %s\n\n", content)
+	return err
+}
+
+// the newline delimited json, the lines are {"result":...} or {"error":...}
+type ndjsonWriter struct{}
+
+func (ndjsonWriter) contentType() string {
+	return ndjsonContentType
+}
+
+func (ndjsonWriter) writeMessage(w io.Writer, content []byte) error {
+	_, err := fmt.Fprintf(w, "{\"result\":%s}\n", content)
+	return err
+}
+
+func (ndjsonWriter) writeError(w io.Writer, s *status.Status) error {
+	content, err := json.Marshal(map[string]errorBody{"error": newErrorBody(s)})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
+
+// the stream writer accepted by the request, default is the newline delimited json
+func acceptedStreamWriter(r *http.Request) streamWriter {
+	if strings.Contains(r.Header.Get("Accept"), eventStreamContentType) {
+		return eventStreamWriter{}
+	}
+	return ndjsonWriter{}
+}
+
+// call the server streaming method and write the messages as the server sent events or the newline delimited json
+func (g *Gateway) serverStream(ctx context.Context, w http.ResponseWriter, r *http.Request, b *backend, md protoreflect.MethodDescriptor, types *typeResolver, c call, request *dynamicpb.Message) {
+
+	stream, err := b.cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, c.fullMethod())
+	if err == nil {
+		if p, ok := peer.FromContext(stream.Context()); ok {
+			recordInstance(r, p)
+		}
+		err = stream.SendMsg(request)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		log.Warnf("the gateway stream the method:%s of segment:%s fail:%s", c.fullMethod(), c.segment, err.Error())
+		c.writeError(w, err)
+		return
+	}
+
+	sw := acceptedStreamWriter(r)
+	flusher, _ := w.(http.Flusher)
+	started := false
+	for {
+		response := dynamicpb.NewMessage(md.Output())
+		if err = stream.RecvMsg(response); err != nil {
+			break
+		}
+		content, err := marshalResponse(response, c.route.responseBody, types)
+		if err == nil {
+			content, err = c.transform.responseBody(content, responseDescriptor(md.Output(), c.route.responseBody))
+		}
+		if err != nil {
+			log.Warnf("the gateway marshal the stream message of %s fail:%s", c.fullMethod(), err.Error())
+			continue
+		}
+		if !started {
+			started = true
+			if header, e := stream.Header(); e == nil {
+				c.transform.writeResponseHeaders(w.Header(), header)
+			}
+			w.Header().Set("Content-Type", sw.contentType())
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+		}
+		if err = sw.writeMessage(w, content); err != nil {
+			// the client has gone
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err == io.EOF {
+		if !started {
+			w.Header().Set("Content-Type", sw.contentType())
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+	// the error before the first message is written as the http status
+	if !started {
+		c.writeError(w, err)
+		return
+	}
+	if e := sw.writeError(w, status.Convert(err)); e == nil && flusher != nil {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,180 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/busgo/elsa/pkg/proto/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// serve the request canceled after the timeout
+func serveWithTimeout(g *Gateway, r *http.Request, timeout time.Duration) *httptest.ResponseRecorder {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	recorder := httptest.NewRecorder()
+	g.ServeHTTP(recorder, r.WithContext(ctx))
+	return recorder
+}
+
+func TestGateway_ServerStream(t *testing.T) {
+
+	g, closeFunc := newTestGateway(t)
+	defer closeFunc()
+
+	path := "/dev/grpc.health.v1.Health/Watch"
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"service":""}`))
+	recorder := serveWithTimeout(g, r, time.Millisecond*300)
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if recorder.Header().Get("Content-Type") != ndjsonContentType || lines[0] != `{"result":{"status":"SERVING"}}` {
+		t.Fatalf("the ndjson stream is unexpected:%s", recorder.Body.String())
+	}
+	if !strings.HasPrefix(lines[len(lines)-1], `{"error":`) {
+		t.Fatalf("the ndjson stream must end with the error:%s", recorder.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"service":""}`))
+	r.Header.Set("Accept", eventStreamContentType)
+	recorder = serveWithTimeout(g, r, time.Millisecond*300)
+	if recorder.Header().Get("Content-Type") != eventStreamContentType || !strings.HasPrefix(recorder.Body.String(), "data: {\"status\":\"SERVING\"}\n\n") {
+		t.Fatalf("the event stream is unexpected:%s", recorder.Body.String())
+	}
+	if !strings.Contains(recorder.Body.String(), "event: error\n") {
+		t.Fatalf("the event stream must end with the error event:%s", recorder.Body.String())
+	}
+
+	// the client streaming method is not supported
+	r = httptest.NewRequest(http.MethodPost, "/dev/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", strings.NewReader(`{}`))
+	if recorder = serveWithTimeout(g, r, time.Second); recorder.Code != http.StatusNotImplemented {
+		t.Fatalf("the status must be 501 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// the grpc-web frames of the response
+func readFrames(t *testing.T, body []byte) ([][]byte, []byte) {
+
+	messages := make([][]byte, 0)
+	for len(body) >= frameHeaderSize {
+		flag, length := body[0], binary.BigEndian.Uint32(body[1:frameHeaderSize])
+		payload := body[frameHeaderSize : frameHeaderSize+int(length)]
+		body = body[frameHeaderSize+int(length):]
+		if flag&trailerFlag != 0 {
+			return messages, payload
+		}
+		messages = append(messages, payload)
+	}
+	t.Fatal("the trailer frame is missing")
+	return nil, nil
+}
+
+func TestGateway_GRPCWeb(t *testing.T) {
+
+	g, closeFunc := newTestGateway(t)
+	defer closeFunc()
+
+	content, err := proto.Marshal(&pb.FetchRequest{Segment: "dev", ServiceName: "trade"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, frameHeaderSize+len(content))
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(content)))
+	copy(frame[frameHeaderSize:], content)
+
+	for _, contentType := range []string{"application/grpc-web+proto", "application/grpc-web-text"} {
+		body := frame
+		text := strings.HasPrefix(contentType, grpcWebTextContentType)
+		if text {
+			body = []byte(base64.StdEncoding.EncodeToString(frame))
+		}
+		r := httptest.NewRequest(http.MethodPost, "/com.busgo.registry.proto.RegistryService/fetch", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Grpc-Timeout", "1S")
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, r)
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != contentType {
+			t.Fatalf("the grpc-web response is unexpected:%d %v", recorder.Code, recorder.Header())
+		}
+		response := recorder.Body.Bytes()
+		if text {
+			if response, err = decodeText(response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		messages, trailer := readFrames(t, response)
+		if len(messages) != 1 || !strings.Contains(string(trailer), "grpc-status: 0\r\n") {
+			t.Fatalf("the grpc-web frames are unexpected:%v %s", messages, trailer)
+		}
+		fetched := &pb.FetchResponse{}
+		if err = proto.Unmarshal(messages[0], fetched); err != nil || len(fetched.Instances) != 1 || fetched.Instances[0].ServiceName != "trade" {
+			t.Fatalf("the grpc-web message is unexpected:%v", fetched)
+		}
+	}
+
+	// the error is the trailer frame
+	empty := make([]byte, frameHeaderSize)
+	r := httptest.NewRequest(http.MethodPost, "/com.busgo.registry.proto.RegistryService/fetch", bytes.NewReader(empty))
+	r.Header.Set("Content-Type", grpcWebContentType)
+	recorder := httptest.NewRecorder()
+	g.ServeHTTP(recorder, r)
+	if _, trailer := readFrames(t, recorder.Body.Bytes()); !strings.Contains(string(trailer), "grpc-status: 3\r\n") {
+		t.Fatalf("the grpc-web trailer must be InvalidArgument:%s", trailer)
+	}
+}
+
+// the grpc-web calls are limited to the routed methods and the services allowed by the default route
+func TestGateway_GRPCWebAllowed(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	routeFile := filepath.Join(dir, "routes.json")
+	writeRoutes(t, routeFile, RouteConfig{Routes: []Route{
+		{Method: http.MethodGet, Path: "/v1/{segment}/services/{serviceName}", Segment: "prod", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch"},
+	}})
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour), WithDefaultRoute("dev/com.busgo.registry.proto.RegistryService"))
+	defer closeFunc()
+
+	content, err := proto.Marshal(&pb.FetchRequest{Segment: "dev", ServiceName: "trade"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, frameHeaderSize+len(content))
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(content)))
+	copy(frame[frameHeaderSize:], content)
+	cases := []struct {
+		segment string
+		path    string
+		code    string
+	}{
+		{"dev", "/com.busgo.registry.proto.RegistryService/fetch", "grpc-status: 0\r\n"},
+		{"prod", "/com.busgo.registry.proto.RegistryService/fetch", "grpc-status: 0\r\n"},
+		{"prod", "/com.busgo.registry.proto.RegistryService/register", "grpc-status: 12\r\n"},
+		{"test", "/com.busgo.registry.proto.RegistryService/fetch", "grpc-status: 12\r\n"},
+		{"dev", "/grpc.health.v1.Health/Check", "grpc-status: 12\r\n"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, c.path, bytes.NewReader(frame))
+		r.Header.Set("Content-Type", grpcWebContentType)
+		r.Header.Set(SegmentHeader, c.segment)
+		recorder := httptest.NewRecorder()
+		g.ServeHTTP(recorder, r)
+		if _, trailer := readFrames(t, recorder.Body.Bytes()); !strings.Contains(string(trailer), c.code) {
+			t.Fatalf("the grpc-web call of segment:%s path:%s must be %q but %s", c.segment, c.path, c.code, trailer)
+		}
+	}
+	if size := len(g.conns.backends); size != 2 {
+		t.Fatalf("the gateway must only dial the allowed services but %d conns", size)
+	}
+}
@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// the kind of the template segment
+type segmentKind int
+
+const (
+	literalSegment  segmentKind = iota
+	wildcardSegment             // * matches a segment
+	deepSegment                 // ** matches the rest segments
+)
+
+type templateSegment struct {
+	kind    segmentKind
+	literal string
+}
+
+// the variable binds the segments in [start,end) to the field path, the end -1 means the rest segments
+type templateVariable struct {
+	field string
+	start int
+	end   int
+}
+
+// the path template like /v1/{name=shelves/*}/books/{id}:publish
+type pathTemplate struct {
+	raw       string
+	segments  []templateSegment
+	variables []templateVariable
+	verb      string
+}
+
+// parse the path template
+func parseTemplate(raw string) (*pathTemplate, error) {
+
+	if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("the path template:%s must start with /", raw)
+	}
+	t := &pathTemplate{raw: raw}
+	path := raw[1:]
+	// the verb is after the last colon out of the variables
+	if index := strings.LastIndex(path, ":"); index >= 0 && index > strings.LastIndex(path, "}") {
+		t.verb = path[index+1:]
+		path = path[:index]
+	}
+
+	for len(path) > 0 {
+		var part string
+		if strings.HasPrefix(path, "{") {
+			end := strings.Index(path, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("the variable of the path template:%s is not closed", raw)
+			}
+			part, path = path[:end+1], path[end+1:]
+			if err := t.parseVariable(part[1 : len(part)-1]); err != nil {
+				return nil, fmt.Errorf("the path template:%s is invalid:%s", raw, err.Error())
+			}
+		} else {
+			end := strings.Index(path, "/")
+			if end < 0 {
+				end = len(path)
+			}
+			part, path = path[:end], path[end:]
+			if err := t.appendSegment(part); err != nil {
+				return nil, fmt.Errorf("the path template:%s is invalid:%s", raw, err.Error())
+			}
+		}
+		if strings.HasPrefix(path, "/") {
+			path = path[1:]
+			if path == "" {
+				return nil, fmt.Errorf("the path template:%s must not end with /", raw)
+			}
+		} else if path != "" {
+			return nil, fmt.Errorf("the path template:%s is invalid near %s", raw, path)
+		}
+	}
+	for i, segment := range t.segments {
+		if segment.kind == deepSegment && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("the ** of the path template:%s must be the last segment", raw)
+		}
+	}
+	return t, nil
+}
+
+// append a literal or wildcard segment
+func (t *pathTemplate) appendSegment(part string) error {
+
+	switch {
+	case part == "":
+		return fmt.Errorf("the empty segment")
+	case part == "*":
+		t.segments = append(t.segments, templateSegment{kind: wildcardSegment})
+	case part == "**":
+		t.segments = append(t.segments, templateSegment{kind: deepSegment})
+	case strings.ContainsAny(part, "{}*"):
+		return fmt.Errorf("the segment:%s is invalid", part)
+	default:
+		t.segments = append(t.segments, templateSegment{kind: literalSegment, literal: part})
+	}
+	return nil
+}
+
+// parse the variable like id or name=shelves/*
+func (t *pathTemplate) parseVariable(content string) error {
+
+	field, pattern := content, "*"
+	if index := strings.Index(content, "="); index >= 0 {
+		field, pattern = content[:index], content[index+1:]
+	}
+	if field == "" || pattern == "" {
+		return fmt.Errorf("the variable:{%s} is invalid", content)
+	}
+	for _, v := range t.variables {
+		if v.field == field {
+			return fmt.Errorf("the variable:%s is duplicated", field)
+		}
+	}
+	start := len(t.segments)
+	for _, part := range strings.Split(pattern, "/") {
+		if err := t.appendSegment(part); err != nil {
+			return err
+		}
+	}
+	end := len(t.segments)
+	if t.segments[end-1].kind == deepSegment {
+		end = -1
+	}
+	t.variables = append(t.variables, templateVariable{field: field, start: start, end: end})
+	return nil
+}
+
+// match the path and return the variables
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	path = path[1:]
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	} else if index := strings.LastIndex(path, ":"); index > strings.LastIndex(path, "/") {
+		// the path with a verb only matches the template with the verb
+		return nil, false
+	}
+
+	parts := strings.Split(path, "/")
+	if path == "" {
+		parts = nil
+	}
+	for i, segment := range t.segments {
+		switch segment.kind {
+		case deepSegment:
+			if len(parts) < i {
+				return nil, false
+			}
+		case wildcardSegment:
+			if i >= len(parts) || parts[i] == "" {
+				return nil, false
+			}
+		case literalSegment:
+			if i >= len(parts) || parts[i] != segment.literal {
+				return nil, false
+			}
+		}
+	}
+	if len(t.segments) == 0 || t.segments[len(t.segments)-1].kind != deepSegment {
+		if len(parts) != len(t.segments) {
+			return nil, false
+		}
+	}
+
+	variables := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		end := v.end
+		if end < 0 {
+			end = len(parts)
+		}
+		values := make([]string, 0, end-v.start)
+		for _, part := range parts[v.start:end] {
+			value, err := url.PathUnescape(part)
+			if err != nil {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+		variables[v.field] = strings.Join(values, "/")
+	}
+	return variables, true
+}
+
+// the literal segments count, the routes with more literal segments are matched first
+func (t *pathTemplate) literals() int {
+	count := 0
+	for _, segment := range t.segments {
+		if segment.kind == literalSegment {
+			count++
+		}
+	}
+	return count
+}
+
+// the normalized template without the variable names to detect the conflicts
+func (t *pathTemplate) normalized() string {
+
+	parts := make([]string, 0, len(t.segments))
+	for _, segment := range t.segments {
+		switch segment.kind {
+		case literalSegment:
+			parts = append(parts, segment.literal)
+		case wildcardSegment:
+			parts = append(parts, "*")
+		case deepSegment:
+			parts = append(parts, "**")
+		}
+	}
+	normalized := "/" + strings.Join(parts, "/")
+	if t.verb != "" {
+		normalized += ":" + t.verb
+	}
+	return normalized
+}
@@ -0,0 +1,455 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// the transformation of the requests and the responses of a route
+type Transform struct {
+	RequestHeaders  *HeaderPolicy     `json:"requestHeaders,omitempty"`  // the request headers forwarded as the metadata, no headers are forwarded by the json routes by default
+	ResponseHeaders *HeaderPolicy     `json:"responseHeaders,omitempty"` // the response metadata written as the headers, no metadata is written by the json routes by default
+	Fields          map[string]string `json:"fields,omitempty"`          // rename the json fields, the key is the field path of the message like customer.id and the value is the external name
+	CORS            *CORSPolicy       `json:"cors,omitempty"`            // the cors policy, the cross origin requests are not allowed by default
+	StatusCodes     map[string]int    `json:"statusCodes,omitempty"`     // override the http status of the grpc codes like {"NotFound":410}
+}
+
+// the header policy
+type HeaderPolicy struct {
+	Allow  []string          `json:"allow,omitempty"`  // the allowed header patterns like x-trace-*, empty means any header
+	Deny   []string          `json:"deny,omitempty"`   // the denied header patterns winning the allowed patterns
+	Rename map[string]string `json:"rename,omitempty"` // rename the headers to the metadata keys of the requests, the metadata keys to the headers of the responses
+	Set    map[string]string `json:"set,omitempty"`    // the fixed values set after the rename
+}
+
+// the cors policy
+type CORSPolicy struct {
+	AllowOrigins     []string `json:"allowOrigins"`               // the allowed origin patterns like https://*.busgo.com, * means any origin
+	AllowMethods     []string `json:"allowMethods,omitempty"`     // the allowed methods of the preflight, default is the requested method
+	AllowHeaders     []string `json:"allowHeaders,omitempty"`     // the allowed headers of the preflight, default is the requested headers
+	ExposeHeaders    []string `json:"exposeHeaders,omitempty"`    // the response headers exposed to the browsers
+	AllowCredentials bool     `json:"allowCredentials,omitempty"` // allow the cookies and the authorization headers
+	MaxAge           Duration `json:"maxAge,omitempty"`           // the cache duration of the preflight
+}
+
+// the compiled header policy
+type headerPolicy struct {
+	allow  []string
+	deny   []string
+	rename map[string]string
+	set    map[string]string
+}
+
+// the renamed field
+type fieldRename struct {
+	parent   []string // the field path of the parent in the message
+	name     string   // the field name of the message
+	external string   // the external field name
+}
+
+// the compiled transform
+type transform struct {
+	requestHeaders  *headerPolicy
+	responseHeaders *headerPolicy
+	renames         []fieldRename     // sorted by the depth
+	messageNames    map[string]string // the message field path of the parent path and the external name
+	cors            *CORSPolicy
+	statusCodes     map[codes.Code]int
+}
+
+// compile the transform, the nil transform is compiled to nil
+func compileTransform(t *Transform) (*transform, error) {
+
+	if t == nil {
+		return nil, nil
+	}
+	compiled := &transform{cors: t.CORS, messageNames: make(map[string]string), statusCodes: make(map[codes.Code]int)}
+	var err error
+	if compiled.requestHeaders, err = compileHeaderPolicy(t.RequestHeaders); err != nil {
+		return nil, fmt.Errorf("the request header policy is invalid:%s", err.Error())
+	}
+	if compiled.responseHeaders, err = compileHeaderPolicy(t.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("the response header policy is invalid:%s", err.Error())
+	}
+	for field, external := range t.Fields {
+		parts := strings.Split(field, ".")
+		if field == "" || external == "" || strings.Contains(external, ".") {
+			return nil, fmt.Errorf("the field rename:%s to %s is invalid", field, external)
+		}
+		parent, name := parts[:len(parts)-1], parts[len(parts)-1]
+		compiled.renames = append(compiled.renames, fieldRename{parent: parent, name: name, external: external})
+		compiled.messageNames[joinPath(strings.Join(parent, "."), external)] = name
+	}
+	// the parents are renamed before the children of the requests
+	sort.Slice(compiled.renames, func(i, j int) bool {
+		if len(compiled.renames[i].parent) != len(compiled.renames[j].parent) {
+			return len(compiled.renames[i].parent) < len(compiled.renames[j].parent)
+		}
+		return compiled.renames[i].name < compiled.renames[j].name
+	})
+	if t.CORS != nil {
+		if len(t.CORS.AllowOrigins) == 0 {
+			return nil, fmt.Errorf("the allowed origins of the cors policy are required")
+		}
+		if err = checkPatterns(t.CORS.AllowOrigins); err != nil {
+			return nil, err
+		}
+	}
+	for name, httpStatus := range t.StatusCodes {
+		code, ok := parseCode(name)
+		if !ok {
+			return nil, fmt.Errorf("the grpc code:%s is unknown", name)
+		}
+		if httpStatus < 100 || httpStatus > 599 {
+			return nil, fmt.Errorf("the http status:%d of the grpc code:%s is invalid", httpStatus, name)
+		}
+		compiled.statusCodes[code] = httpStatus
+	}
+	return compiled, nil
+}
+
+// compile the header policy, the names are lower cased as the metadata keys
+func compileHeaderPolicy(p *HeaderPolicy) (*headerPolicy, error) {
+
+	if p == nil {
+		return nil, nil
+	}
+	lower := func(values []string) []string {
+		result := make([]string, 0, len(values))
+		for _, value := range values {
+			result = append(result, strings.ToLower(value))
+		}
+		return result
+	}
+	compiled := &headerPolicy{allow: lower(p.Allow), deny: lower(p.Deny), rename: make(map[string]string), set: make(map[string]string)}
+	if err := checkPatterns(compiled.allow); err != nil {
+		return nil, err
+	}
+	if err := checkPatterns(compiled.deny); err != nil {
+		return nil, err
+	}
+	for from, to := range p.Rename {
+		compiled.rename[strings.ToLower(from)] = strings.ToLower(to)
+	}
+	for key, value := range p.Set {
+		compiled.set[strings.ToLower(key)] = value
+	}
+	return compiled, nil
+}
+
+// check the patterns of path.Match
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("the pattern:%s is invalid", pattern)
+		}
+	}
+	return nil
+}
+
+// match any pattern
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parse the grpc code name like NotFound or NOT_FOUND
+func parseCode(name string) (codes.Code, bool) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+	}
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if normalize(code.String()) == normalize(name) {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// the key is allowed by the policy
+func (p *headerPolicy) allowed(key string) bool {
+	if matchAny(p.deny, key) {
+		return false
+	}
+	return len(p.allow) == 0 || matchAny(p.allow, key)
+}
+
+// the header is named by the allowed headers or the renames, not matched by a pattern
+func (p *headerPolicy) names(key string) bool {
+	if _, ok := p.rename[key]; ok {
+		return !matchAny(p.deny, key)
+	}
+	for _, allow := range p.allow {
+		if allow == key {
+			return !matchAny(p.deny, key)
+		}
+	}
+	return false
+}
+
+// filter and rename the metadata
+func (p *headerPolicy) apply(md metadata.MD) metadata.MD {
+
+	result := metadata.MD{}
+	for key, values := range md {
+		if !p.allowed(key) {
+			continue
+		}
+		if name, ok := p.rename[key]; ok {
+			key = name
+		}
+		result.Append(key, values...)
+	}
+	for key, value := range p.set {
+		result.Set(key, value)
+	}
+	return result
+}
+
+// the request metadata of the header policy, nil if the policy is not set
+func (t *transform) requestMetadata(header http.Header) metadata.MD {
+
+	if t == nil || t.requestHeaders == nil {
+		return nil
+	}
+	forwarded := forwardedMetadata(header)
+	for key := range credentialHeaders {
+		if values := header.Values(key); len(values) > 0 && t.requestHeaders.names(key) {
+			forwarded.Set(key, values...)
+		}
+	}
+	md := t.requestHeaders.apply(forwarded)
+	// the elsa metadata like the principal must not be forged by the renames
+	for key := range md {
+		if strings.HasPrefix(key, "grpc-") || strings.HasPrefix(key, "x-elsa-") {
+			delete(md, key)
+		}
+	}
+	return md
+}
+
+// the response metadata of the header policy, the metadata is unchanged if the policy is not set
+func (t *transform) responseMetadata(md metadata.MD) metadata.MD {
+	if t == nil || t.responseHeaders == nil {
+		return md
+	}
+	return t.responseHeaders.apply(md)
+}
+
+// write the response metadata as the headers if the policy is set
+func (t *transform) writeResponseHeaders(header http.Header, mds ...metadata.MD) {
+
+	if t == nil || t.responseHeaders == nil {
+		return
+	}
+	for key, values := range t.responseHeaders.apply(metadata.Join(mds...)) {
+		if key == "content-type" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		for _, value := range values {
+			header.Add(key, encodeMetadataValue(key, value))
+		}
+	}
+}
+
+// rename the external fields of the request body to the message fields
+func (t *transform) requestBody(body []byte) ([]byte, error) {
+
+	if t == nil || len(t.renames) == 0 || len(bytes.TrimSpace(body)) == 0 {
+		return body, nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "the request body is invalid:%s", err.Error())
+	}
+	for _, r := range t.renames {
+		renameField(value, r.parent, r.external, r.name)
+	}
+	return json.Marshal(value)
+}
+
+// rename the message fields of the response body to the external fields, the field paths are resolved to
+// the json names written by the protojson against the descriptor of the body, the paths are kept without it
+func (t *transform) responseBody(body []byte, desc protoreflect.MessageDescriptor) ([]byte, error) {
+
+	if t == nil || len(t.renames) == 0 {
+		return body, nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return nil, err
+	}
+	// the children are renamed before the parents of the responses
+	for i := len(t.renames) - 1; i >= 0; i-- {
+		r := t.renames[i]
+		fields := jsonPath(desc, append(append(make([]string, 0, len(r.parent)+1), r.parent...), r.name))
+		renameField(value, fields[:len(fields)-1], fields[len(fields)-1], r.external)
+	}
+	return json.Marshal(value)
+}
+
+// the json names of the field path like service_name in the message, the names not found are kept
+func jsonPath(desc protoreflect.MessageDescriptor, fields []string) []string {
+
+	for i, name := range fields {
+		if desc == nil {
+			break
+		}
+		fd := findField(desc, name)
+		if fd == nil {
+			break
+		}
+		fields[i] = fd.JSONName()
+		desc = fd.Message()
+	}
+	return fields
+}
+
+// rename the external query parameters like client.clientId to the message field paths
+func (t *transform) requestQuery(query url.Values) url.Values {
+
+	if t == nil || len(t.renames) == 0 {
+		return query
+	}
+	result := make(url.Values, len(query))
+	for key, values := range query {
+		parts := strings.Split(key, ".")
+		parent := ""
+		for i, part := range parts {
+			if name, ok := t.messageNames[joinPath(parent, part)]; ok {
+				parts[i] = name
+			}
+			parent = joinPath(parent, parts[i])
+		}
+		result[parent] = append(result[parent], values...)
+	}
+	return result
+}
+
+// the http status of the grpc code
+func (t *transform) httpStatus(code codes.Code) int {
+	if t != nil {
+		if s, ok := t.statusCodes[code]; ok {
+			return s
+		}
+	}
+	return HTTPStatus(code)
+}
+
+// the cors policy allows the origin
+func (t *transform) allowOrigin(origin string) bool {
+	return t != nil && t.cors != nil && origin != "" && matchAny(t.cors.AllowOrigins, origin)
+}
+
+// write the cors headers of the cross origin request
+func (t *transform) writeCORS(w http.ResponseWriter, r *http.Request) {
+
+	origin := r.Header.Get("Origin")
+	if !t.allowOrigin(origin) {
+		return
+	}
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	if len(t.cors.AllowOrigins) == 1 && t.cors.AllowOrigins[0] == "*" && !t.cors.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if t.cors.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(t.cors.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(t.cors.ExposeHeaders, ", "))
+	}
+}
+
+// answer the cors preflight request
+func (t *transform) preflight(w http.ResponseWriter, r *http.Request) {
+
+	origin := r.Header.Get("Origin")
+	if !t.allowOrigin(origin) {
+		writeStatus(w, http.StatusForbidden, status.Newf(codes.PermissionDenied, "the origin:%s is not allowed", origin))
+		return
+	}
+	t.writeCORS(w, r)
+	header := w.Header()
+	methods := r.Header.Get("Access-Control-Request-Method")
+	if len(t.cors.AllowMethods) > 0 {
+		methods = strings.Join(t.cors.AllowMethods, ", ")
+	}
+	header.Set("Access-Control-Allow-Methods", methods)
+	headers := r.Header.Get("Access-Control-Request-Headers")
+	if len(t.cors.AllowHeaders) > 0 {
+		headers = strings.Join(t.cors.AllowHeaders, ", ")
+	}
+	if headers != "" {
+		header.Set("Access-Control-Allow-Headers", headers)
+	}
+	if t.cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(time.Duration(t.cors.MaxAge).Seconds()), 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the request is a cors preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// decode the json keeping the numbers
+func decodeJSON(content []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// rename the field of the objects under the parent path, the arrays are renamed by the elements
+func renameField(value interface{}, parent []string, from, to string) {
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, element := range v {
+			renameField(element, parent, from, to)
+		}
+	case map[string]interface{}:
+		if len(parent) > 0 {
+			if child, ok := v[parent[0]]; ok {
+				renameField(child, parent[1:], from, to)
+			}
+			return
+		}
+		if field, ok := v[from]; ok {
+			delete(v, from)
+			v[to] = field
+		}
+	}
+}
+
+// join the field path
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
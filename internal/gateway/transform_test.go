@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestTransform_Fields(t *testing.T) {
+
+	tf, err := compileTransform(&Transform{Fields: map[string]string{"customer": "client", "customer.id": "clientId"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := tf.requestBody([]byte(`{"client":{"clientId":12345678901234567},"items":[1]}`))
+	if err != nil || string(body) != `{"customer":{"id":12345678901234567},"items":[1]}` {
+		t.Fatalf("the request body must be renamed but %s %v", body, err)
+	}
+	body, err = tf.responseBody([]byte(`{"customer":[{"id":"1"},{"id":"2"}]}`), nil)
+	if err != nil || string(body) != `{"client":[{"clientId":"1"},{"clientId":"2"}]}` {
+		t.Fatalf("the response body must be renamed but %s %v", body, err)
+	}
+	query := tf.requestQuery(url.Values{"client.clientId": {"1"}, "page": {"2"}})
+	if query.Get("customer.id") != "1" || query.Get("page") != "2" {
+		t.Fatalf("the query must be renamed but %v", query)
+	}
+
+	invalid := []*Transform{
+		{Fields: map[string]string{"customer": "a.b"}},
+		{StatusCodes: map[string]int{"Missing": 400}},
+		{StatusCodes: map[string]int{"NOT_FOUND": 1000}},
+		{CORS: &CORSPolicy{}},
+		{RequestHeaders: &HeaderPolicy{Allow: []string{"["}}},
+	}
+	for _, transform := range invalid {
+		if _, err = compileTransform(transform); err == nil {
+			t.Fatalf("the transform:%+v must be invalid", transform)
+		}
+	}
+}
+
+func TestGateway_Transform(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	transform := &Transform{
+		RequestHeaders:  &HeaderPolicy{Allow: []string{"x-tenant*"}, Rename: map[string]string{"X-Tenant-Id": "x-tenant"}},
+		ResponseHeaders: &HeaderPolicy{Deny: []string{"x-internal-*"}},
+		Fields:          map[string]string{"serviceName": "service", "instances": "nodes", "instances.serviceName": "name"},
+		CORS:            &CORSPolicy{AllowOrigins: []string{"https://*.busgo.com"}, MaxAge: Duration(time.Minute * 10)},
+		StatusCodes:     map[string]int{"InvalidArgument": http.StatusUnprocessableEntity},
+	}
+	routeFile := filepath.Join(dir, "routes.json")
+	writeRoutes(t, routeFile, RouteConfig{Routes: []Route{
+		{Method: http.MethodPost, Path: "/v1/fetch", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch", Body: "*", Transform: transform},
+		{Method: http.MethodGet, Path: "/v1/services", Service: "com.busgo.registry.proto.RegistryService", RPC: "fetch", Transform: transform},
+	}})
+	g, closeFunc := newTestGateway(t, WithRouteFile(routeFile, time.Hour))
+	defer closeFunc()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/fetch", strings.NewReader(`{"segment":"dev","service":"trade"}`))
+	r.Header.Set("X-Tenant-Id", "t1")
+	r.Header.Set("X-Other", "o1")
+	r.Header.Set("Origin", "https://app.busgo.com")
+	recorder := httptest.NewRecorder()
+	g.ServeHTTP(recorder, r)
+	response := struct {
+		Message string `json:"message"`
+		Nodes   []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	}{}
+	if err = json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("the status must be 200 but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	if response.Message != "t1" || len(response.Nodes) != 1 || response.Nodes[0].Name != "trade" {
+		t.Fatalf("the metadata and the fields must be transformed but %s", recorder.Body.String())
+	}
+	header := recorder.Header()
+	if header.Get("X-Backend-Version") != "1" || header.Get("X-Internal-Node") != "" || header.Get("Access-Control-Allow-Origin") != "https://app.busgo.com" {
+		t.Fatalf("the response headers are wrong:%v", header)
+	}
+
+	if recorder = serve(g, http.MethodGet, "/v1/services?segment=dev&service=trade", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("the query must be renamed but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = serve(g, http.MethodPost, "/v1/fetch", `{"segment":"dev"}`); recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("the status must be overridden but %d:%s", recorder.Code, recorder.Body.String())
+	}
+	// the default route is not transformed
+	if recorder = serve(g, http.MethodPost, "/dev/com.busgo.registry.proto.RegistryService/fetch", `{"segment":"dev"}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("the default status must be 400 but %d", recorder.Code)
+	}
+
+	cases := []struct {
+		origin string
+		status int
+	}{
+		{"https://app.busgo.com", http.StatusNoContent},
+		{"https://evil.com", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		r = httptest.NewRequest(http.MethodOptions, "/v1/fetch", nil)
+		r.Header.Set("Origin", c.origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "content-type")
+		recorder = httptest.NewRecorder()
+		if g.ServeHTTP(recorder, r); recorder.Code != c.status {
+			t.Fatalf("the preflight of the origin:%s must be %d but %d", c.origin, c.status, recorder.Code)
+		}
+	}
+	if header = recorder.Header(); header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("the denied origin must not be allowed:%v", header)
+	}
+}
+
+// the credentials consumed by the gateway are not forwarded unless the header policy names them
+func TestTransform_CredentialHeaders(t *testing.T) {
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	header.Set("X-API-Key", "key")
+	header.Set("X-Trace-Id", "1")
+	if md := forwardedMetadata(header); len(md["authorization"]) != 0 || len(md["x-api-key"]) != 0 || md.Get("x-trace-id")[0] != "1" {
+		t.Fatalf("the credentials must not be forwarded but %v", md)
+	}
+
+	cases := []struct {
+		policy *HeaderPolicy
+		key    string
+		want   string
+	}{
+		{&HeaderPolicy{}, "authorization", ""},
+		{&HeaderPolicy{Allow: []string{"*"}}, "authorization", ""},
+		{&HeaderPolicy{Allow: []string{"Authorization"}}, "authorization", "Bearer token"},
+		{&HeaderPolicy{Rename: map[string]string{"X-API-Key": "x-client-key"}}, "x-client-key", "key"},
+		{&HeaderPolicy{Allow: []string{"authorization"}, Deny: []string{"auth*"}}, "authorization", ""},
+	}
+	for _, c := range cases {
+		tf, err := compileTransform(&Transform{RequestHeaders: c.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := tf.requestMetadata(header)
+		got := ""
+		if values := md.Get(c.key); len(values) > 0 {
+			got = values[0]
+		}
+		if got != c.want {
+			t.Fatalf("the metadata:%s of the policy %+v must be %q but %q", c.key, c.policy, c.want, got)
+		}
+	}
+}
+
+// the renames of the snake case proto names apply to the lower camel case json names of the responses
+func TestTransform_ProtoNames(t *testing.T) {
+
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Label: label.Enum(), Type: typ.Enum()}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	optional, repeated := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test/shipment.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Shipment"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("tracking_no", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("line_items", 2, repeated, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.LineItem"),
+			}},
+			{Name: proto.String("LineItem"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("item_id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := file.Messages().ByName("Shipment")
+
+	tf, err := compileTransform(&Transform{Fields: map[string]string{"tracking_no": "tracking", "line_items": "items", "line_items.item_id": "id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the request body is decoded with the proto names
+	body, err := tf.requestBody([]byte(`{"tracking":"T1","items":[{"id":"I1"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipment := dynamicpb.NewMessage(desc)
+	if err = protojson.Unmarshal(body, shipment); err != nil {
+		t.Fatalf("the request body must be decoded but %s %v", body, err)
+	}
+	content, err := marshalResponse(shipment, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err = tf.responseBody(content, responseDescriptor(desc, "")); err != nil || string(body) != `{"items":[{"id":"I1"}],"tracking":"T1"}` {
+		t.Fatalf("the response body must be renamed by the json names but %s %v", body, err)
+	}
+	// the renames relative to the response field
+	tf, err = compileTransform(&Transform{Fields: map[string]string{"item_id": "id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err = tf.responseBody([]byte(`[{"itemId":"I1"}]`), responseDescriptor(desc, "line_items")); err != nil || string(body) != `[{"id":"I1"}]` {
+		t.Fatalf("the response field must be renamed by the json names but %s %v", body, err)
+	}
+}
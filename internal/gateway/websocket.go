@@ -0,0 +1,198 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	DefaultPingInterval = time.Second * 30
+
+	// the timeout of writing a frame
+	writeWait = time.Second * 10
+	// the close codes of the grpc status are 4000 plus the grpc code
+	closeCodeBase = 4000
+	// the max length of the close reason in the control frame
+	maxCloseReason = 123
+)
+
+// the request upgrades to the websocket
+func isWebSocket(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// the origin of the websocket is the host or allowed by the cors policy of the route
+func (c call) checkOrigin(r *http.Request) bool {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return c.transform.allowOrigin(origin)
+}
+
+// bridge the websocket to the client streaming or the bidi streaming method,
+// the client sends the json messages and an empty message to close sending,
+// the gateway sends the {"result":...} messages and closes the websocket with the grpc status
+func (g *Gateway) bridge(ctx context.Context, w http.ResponseWriter, r *http.Request, b *backend, md protoreflect.MethodDescriptor, types *typeResolver, c call) {
+
+	upgrader := websocket.Upgrader{CheckOrigin: c.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has written the http error
+		log.Warnf("the gateway upgrade the websocket of %s fail:%s", c.fullMethod(), err.Error())
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(g.opts.maxBodySize)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := b.cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: md.IsStreamingServer(), ClientStreams: true}, c.fullMethod())
+	if err != nil {
+		log.Warnf("the gateway stream the method:%s of segment:%s fail:%s", c.fullMethod(), c.segment, err.Error())
+		closeWebSocket(conn, status.Convert(err))
+		return
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		recordInstance(r, p)
+	}
+
+	// the pongs and the messages keep the websocket alive
+	pongWait := g.opts.pingInterval * 2
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	closedChan := make(chan bool)
+	defer close(closedChan)
+	go ping(conn, g.opts.pingInterval, closedChan)
+
+	aborted := make(chan *status.Status, 1)
+	go readWebSocket(conn, stream, md, types, c, pongWait, aborted, cancel)
+
+	for {
+		response := dynamicpb.NewMessage(md.Output())
+		if err = stream.RecvMsg(response); err != nil {
+			break
+		}
+		content, err := marshalResponse(response, c.route.responseBody, types)
+		if err == nil {
+			content, err = c.transform.responseBody(content, responseDescriptor(md.Output(), c.route.responseBody))
+		}
+		if err != nil {
+			log.Warnf("the gateway marshal the websocket message of %s fail:%s", c.fullMethod(), err.Error())
+			continue
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("{\"result\":%s}", content))); err != nil {
+			// the client has gone
+			return
+		}
+	}
+
+	s := status.Convert(err)
+	if err == io.EOF {
+		s = status.New(codes.OK, "")
+	}
+	select {
+	case a := <-aborted:
+		s = a
+	default:
+	}
+	closeWebSocket(conn, s)
+}
+
+// read the json messages and send them to the stream, the empty message closes sending
+func readWebSocket(conn *websocket.Conn, stream grpc.ClientStream, md protoreflect.MethodDescriptor, types *typeResolver, c call,
+	pongWait time.Duration, aborted chan<- *status.Status, cancel context.CancelFunc) {
+
+	sendClosed := false
+	for {
+		_, content, err := conn.ReadMessage()
+		if err != nil {
+			// the client has gone or closed the websocket
+			cancel()
+			return
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+		if sendClosed {
+			aborted <- status.New(codes.InvalidArgument, "the message after the end of the stream")
+			cancel()
+			return
+		}
+		if len(content) == 0 {
+			sendClosed = true
+			_ = stream.CloseSend()
+			continue
+		}
+		request := dynamicpb.NewMessage(md.Input())
+		content, err = c.transform.requestBody(content)
+		if err == nil {
+			err = protojson.UnmarshalOptions{Resolver: types}.Unmarshal(content, request)
+		}
+		if err != nil {
+			aborted <- status.Newf(codes.InvalidArgument, "the message is invalid:%s", err.Error())
+			cancel()
+			return
+		}
+		if err = stream.SendMsg(request); err != nil {
+			// the stream is ended, the status is received by the writer
+			return
+		}
+	}
+}
+
+// ping the client periodically
+func ping(conn *websocket.Conn, interval time.Duration, closedChan chan bool) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-closedChan:
+			return
+		}
+	}
+}
+
+// close the websocket with the grpc status, the error message is sent before the close frame
+func closeWebSocket(conn *websocket.Conn, s *status.Status) {
+
+	code := websocket.CloseNormalClosure
+	if s.Code() != codes.OK {
+		code = closeCodeBase + int(s.Code())
+		if content, err := json.Marshal(map[string]errorBody{"error": newErrorBody(s)}); err == nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			_ = conn.WriteMessage(websocket.TextMessage, content)
+		}
+	}
+	reason := s.Message()
+	if len(reason) > maxCloseReason {
+		reason = strings.ToValidUTF8(reason[:maxCloseReason], "")
+	}
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+}
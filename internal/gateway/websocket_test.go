@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGateway_WebSocket(t *testing.T) {
+
+	g, closeFunc := newTestGateway(t, WithPingInterval(time.Millisecond*100))
+	defer closeFunc()
+	server := httptest.NewServer(g)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/dev/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+	conn, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial the websocket fail:%v %v", err, response)
+	}
+	defer conn.Close()
+	pings := 0
+	conn.SetPingHandler(func(data string) error {
+		pings++
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// the bidi stream keeps alive by the pongs answered when the client reads
+	for i := 0; i < 2; i++ {
+		if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"listServices":""}`)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 150)
+		_, content, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := struct {
+			Result struct {
+				ListServicesResponse struct {
+					Service []struct {
+						Name string `json:"name"`
+					} `json:"service"`
+				} `json:"listServicesResponse"`
+			} `json:"result"`
+		}{}
+		if err = json.Unmarshal(content, &message); err != nil || len(message.Result.ListServicesResponse.Service) == 0 {
+			t.Fatalf("the result message is unexpected:%s", content)
+		}
+	}
+	// the empty message closes sending and the websocket is closed with the ok status
+	if err = conn.WriteMessage(websocket.TextMessage, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("the websocket must be closed normally but %v", err)
+	}
+	if pings == 0 {
+		t.Fatalf("the client must be pinged")
+	}
+
+	// the invalid message closes the websocket with the grpc status
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"unknown":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, content, err := conn.ReadMessage(); err != nil || !strings.Contains(string(content), `"status":"InvalidArgument"`) {
+		t.Fatalf("the error message is unexpected:%s %v", content, err)
+	}
+	if _, _, err = conn.ReadMessage(); !websocket.IsCloseError(err, closeCodeBase+int(codes.InvalidArgument)) {
+		t.Fatalf("the websocket must be closed with the invalid argument but %v", err)
+	}
+
+	// the cross origin and the unary websocket are rejected
+	header := http.Header{"Origin": {"https://evil.com"}}
+	if _, response, err = websocket.DefaultDialer.Dial(url, header); err == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("the cross origin websocket must be forbidden but %v", err)
+	}
+	unary := "ws" + strings.TrimPrefix(server.URL, "http") + "/dev/com.busgo.registry.proto.RegistryService/fetch"
+	if _, response, err = websocket.DefaultDialer.Dial(unary, nil); err == nil || response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("the websocket of the unary method must be rejected but %v", err)
+	}
+}
@@ -40,12 +40,16 @@ func (in *Instance) String() string {
 func NewInstance(req *pb.RegisterRequest) *Instance {
 
 	now := time.Now().UnixNano()
+	metadata := make(map[string]string)
+	for key, value := range req.Metadata {
+		metadata[key] = value
+	}
 	return &Instance{
 		Segment:         req.Segment,
 		ServiceName:     req.ServiceName,
 		Ip:              req.Ip,
 		Port:            req.Port,
-		Metadata:        make(map[string]string),
+		Metadata:        metadata,
 		RegTimestamp:    now,
 		UpTimestamp:     now,
 		RenewTimestamp:  now,
@@ -6,7 +6,6 @@ import (
 	"github.com/busgo/elsa/pkg/proto/pb"
 	"github.com/busgo/elsa/pkg/utils"
 	"google.golang.org/grpc"
-	"strings"
 	"time"
 )
 
@@ -41,8 +40,8 @@ type Peer struct {
 	cli      pb.RegistryServiceClient
 }
 
-// new a peer pool with endpoints
-func NewPeerPoolWithEndpoints(endpoints []string) (*PeerPool, error) {
+// new a peer pool with endpoints, the peers dial with the insecure transport without the dial options
+func NewPeerPoolWithEndpoints(endpoints []string, dialOpts ...grpc.DialOption) (*PeerPool, error) {
 
 	peers := make([]*Peer, 0)
 
@@ -50,7 +49,7 @@ func NewPeerPoolWithEndpoints(endpoints []string) (*PeerPool, error) {
 		endpoints = []string{DefaultEndpoint}
 	}
 	for _, endpoint := range endpoints {
-		p, err := NewPeerEndpoint(endpoint)
+		p, err := NewPeerEndpoint(endpoint, dialOpts...)
 		if err != nil {
 			return nil, err
 		}
@@ -186,15 +185,18 @@ func (pool *PeerPool) handleCancelMsg(msg *SyncMsg) {
 }
 
 // new a peer with endpoint
-func NewPeerEndpoint(endpoint string) (*Peer, error) {
+func NewPeerEndpoint(endpoint string, dialOpts ...grpc.DialOption) (*Peer, error) {
 
-	cc, err := grpc.Dial(endpoint, grpc.WithInsecure())
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	cc, err := grpc.Dial(endpoint, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
 	return &Peer{
 		endpoint: endpoint,
 		cli:      pb.NewRegistryServiceClient(cc),
-		local:    strings.HasPrefix(endpoint, utils.GetLocalIp()) || strings.HasPrefix(endpoint, utils.LocalIp) || strings.HasPrefix(endpoint, utils.LocalHost),
+		local:    utils.IsLocalEndpoint(endpoint),
 	}, nil
 }
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/proto/pb"
+	"github.com/busgo/elsa/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the action of the registry mutations
+type Action string
+
+const (
+	ActionRegister Action = "register"
+	ActionRenew    Action = "renew"
+	ActionCancel   Action = "cancel"
+	ActionAll      Action = "*"
+)
+
+const AnyIdentity = "*"
+
+// the acl rule grants the actions of the services to an identity
+type Rule struct {
+	Identity string   `json:"identity"`          // the identity of the token, '*' matches every authenticated identity
+	Segment  string   `json:"segment,omitempty"` // the segment, empty or '*' matches every segment
+	Services []string `json:"services"`          // the service name patterns like com.busgo.trade.proto.*
+	Actions  []Action `json:"actions"`           // the granted actions
+}
+
+// the auth config of the registry server,the fetch is not authenticated
+type AuthConfig struct {
+	Segments map[string]security.TokenConfig `json:"segments"` // the token config of each segment, '*' for every segment
+	Rules    []Rule                          `json:"rules"`
+}
+
+// load the auth config from a json file
+func LoadAuthConfig(file string) (AuthConfig, error) {
+
+	config := AuthConfig{}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// the authenticator of the registry mutations
+type authenticator struct {
+	verifier *security.TokenVerifier
+	rules    []Rule
+}
+
+func newAuthenticator(config AuthConfig) *authenticator {
+	return &authenticator{
+		verifier: security.NewTokenVerifier(config.Segments),
+		rules:    config.Rules,
+	}
+}
+
+// check the identity has been granted the action of the service
+func (a *authenticator) allow(identity, segment, serviceName string, action Action) bool {
+
+	for _, rule := range a.rules {
+		if rule.Identity != AnyIdentity && rule.Identity != identity {
+			continue
+		}
+		if rule.Segment != "" && rule.Segment != "*" && rule.Segment != segment {
+			continue
+		}
+		if !matchService(rule.Services, serviceName) {
+			continue
+		}
+		for _, granted := range rule.Actions {
+			if granted == action || granted == ActionAll {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchService(patterns []string, serviceName string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, serviceName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the segment, service name and actions of the request
+func requestActions(req interface{}) (string, string, []Action, bool) {
+
+	switch request := req.(type) {
+	case *pb.RegisterRequest:
+		return request.Segment, request.ServiceName, []Action{ActionRegister}, true
+	case *pb.RenewRequest:
+		return request.Segment, request.ServiceName, []Action{ActionRenew}, true
+	case *pb.CancelRequest:
+		return request.Segment, request.ServiceName, []Action{ActionCancel}, true
+	}
+	return "", "", nil, false
+}
+
+// the unary server interceptor authenticates and authorizes the mutations
+func (a *authenticator) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	segment, serviceName, actions, ok := requestActions(req)
+	if !ok {
+		return handler(ctx, req)
+	}
+	identity, err := a.verifier.Verify(segment, security.TokenFromContext(ctx))
+	if err != nil {
+		log.Warnf("the registry method:%s of segment:%s,serviceName:%s unauthenticated:%s", info.FullMethod, segment, serviceName, err.Error())
+		return nil, status.Errorf(codes.Unauthenticated, "the registry request unauthenticated:%s", err.Error())
+	}
+	for _, action := range actions {
+		if !a.allow(identity, segment, serviceName, action) {
+			log.Warnf("the identity:%s has been denied the %s of segment:%s,serviceName:%s", identity, action, segment, serviceName)
+			return nil, status.Errorf(codes.PermissionDenied, "the identity:%s has no %s permission of %s", identity, action, serviceName)
+		}
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/busgo/elsa/pkg/proto/pb"
+	"github.com/busgo/elsa/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthenticator_UnaryServerInterceptor(t *testing.T) {
+
+	a := newAuthenticator(AuthConfig{
+		Segments: map[string]security.TokenConfig{
+			"dev": {Tokens: map[string]string{"trade-token": "trade", "ops-token": "ops"}},
+		},
+		Rules: []Rule{
+			{Identity: "trade", Services: []string{"com.busgo.trade.proto.*"}, Actions: []Action{ActionRegister, ActionRenew}},
+			{Identity: "ops", Segment: "dev", Services: []string{"*"}, Actions: []Action{ActionAll}},
+		},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	call := func(token string, req interface{}) codes.Code {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(security.AuthorizationKey, security.BearerPrefix+token))
+		}
+		_, err := a.UnaryServerInterceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/com.busgo.registry.proto.RegistryService/register"}, handler)
+		return status.Code(err)
+	}
+
+	register := &pb.RegisterRequest{Segment: "dev", ServiceName: "com.busgo.trade.proto.TradeService"}
+	cases := []struct {
+		token string
+		req   interface{}
+		code  codes.Code
+	}{
+		{"", register, codes.Unauthenticated},
+		{"wrong-token", register, codes.Unauthenticated},
+		{"trade-token", register, codes.OK},
+		{"trade-token", &pb.RegisterRequest{Segment: "dev", ServiceName: "com.busgo.user.proto.UserService"}, codes.PermissionDenied},
+		{"trade-token", &pb.CancelRequest{Segment: "dev", ServiceName: "com.busgo.trade.proto.TradeService"}, codes.PermissionDenied},
+		{"ops-token", &pb.CancelRequest{Segment: "dev", ServiceName: "com.busgo.trade.proto.TradeService"}, codes.OK},
+		{"", &pb.FetchRequest{Segment: "dev", ServiceName: "com.busgo.trade.proto.TradeService"}, codes.OK},
+	}
+	for i, c := range cases {
+		if code := call(c.token, c.req); code != c.code {
+			t.Fatalf("the case %d must be %s but %s", i, c.code, code)
+		}
+	}
+}
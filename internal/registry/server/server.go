@@ -2,27 +2,93 @@ package server
 
 import (
 	"context"
+	"errors"
 	"github.com/busgo/elsa/internal/registry"
 	"github.com/busgo/elsa/internal/registry/p2p"
 	"github.com/busgo/elsa/pkg/log"
 	"github.com/busgo/elsa/pkg/proto/pb"
+	"github.com/busgo/elsa/pkg/security"
+	"github.com/busgo/elsa/pkg/tracing"
 	"github.com/busgo/elsa/pkg/utils"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"net"
-	"strings"
 )
 
+type Options struct {
+	tls       *security.TLSConfig
+	auth      *AuthConfig
+	peerCreds credentials.PerRPCCredentials
+}
+
+type Option func(options *Options)
+
+// serve and sync the peers with the tls, the CA file enables the mutual tls
+func WithTLS(config security.TLSConfig) Option {
+	return func(options *Options) {
+		options.tls = &config
+	}
+}
+
+// authenticate the registry mutations with the tokens and authorize them with the acl rules
+func WithAuth(config AuthConfig) Option {
+	return func(options *Options) {
+		options.auth = &config
+	}
+}
+
+// the credentials attached to the sync requests of the peers
+func WithPeerCredentials(creds credentials.PerRPCCredentials) Option {
+	return func(options *Options) {
+		options.peerCreds = creds
+	}
+}
+
 type RegistryServer struct {
 	endpoint string
 	r        registry.Registry
 	pool     *p2p.PeerPool
 	server   *grpc.Server
+	reloader *security.Reloader
 	pb.UnimplementedRegistryServiceServer
 }
 
 // new  registry server
-func NewRegistryServerWithEndpoints(endpoints []string) (*RegistryServer, error) {
-	pool, err := p2p.NewPeerPoolWithEndpoints(endpoints)
+func NewRegistryServerWithEndpoints(endpoints []string, options ...Option) (*RegistryServer, error) {
+
+	opts := Options{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	interceptors := []grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor()}
+	if opts.auth != nil {
+		interceptors = append(interceptors, newAuthenticator(*opts.auth).UnaryServerInterceptor)
+	}
+	serverOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+	dialOpts := make([]grpc.DialOption, 0)
+	var reloader *security.Reloader
+	if opts.tls != nil {
+		if opts.tls.CertFile == "" {
+			return nil, errors.New("the registry server tls cert file is empty")
+		}
+		r, err := security.NewReloader(*opts.tls)
+		if err != nil {
+			return nil, err
+		}
+		reloader = r
+		serverOpts = append(serverOpts, grpc.Creds(reloader.ServerCredentials()))
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(reloader.ClientCredentials()))
+	}
+	if opts.peerCreds != nil {
+		if reloader == nil {
+			dialOpts = append(dialOpts, grpc.WithInsecure())
+		}
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(opts.peerCreds))
+	}
+
+	pool, err := p2p.NewPeerPoolWithEndpoints(endpoints, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +96,8 @@ func NewRegistryServerWithEndpoints(endpoints []string) (*RegistryServer, error)
 		endpoint: getLocalEndpoint(endpoints),
 		r:        registry.NewRegistry(),
 		pool:     pool,
-		server:   grpc.NewServer(),
+		server:   grpc.NewServer(serverOpts...),
+		reloader: reloader,
 	}, nil
 }
 
@@ -56,20 +123,27 @@ func getLocalEndpoint(endpoints []string) string {
 	if len(endpoints) == 0 {
 		return p2p.DefaultEndpoint
 	}
-	ip := utils.GetLocalIp()
 	for _, endpoint := range endpoints {
-		if strings.HasPrefix(endpoint, ip) ||
-			strings.HasPrefix(endpoint, utils.LocalIp) ||
-			strings.HasPrefix(endpoint, utils.LocalHost) {
+		if utils.IsLocalEndpoint(endpoint) {
 			return endpoint
 		}
 	}
 	return p2p.DefaultEndpoint
 }
 
+// set the instance attributes of the span
+func setSpanAttributes(ctx context.Context, segment, serviceName, ip string, port int32) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.SegmentKey.String(segment),
+		tracing.ServiceKey.String(serviceName),
+		tracing.InstanceKey.String(utils.JoinHostPort(ip, port)),
+	)
+}
+
 // register a service instance
 func (s *RegistryServer) Register(ctx context.Context, request *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 
+	setSpanAttributes(ctx, request.Segment, request.ServiceName, request.Ip, request.Port)
 	instance := registry.NewInstance(request)
 	in, _ := s.r.Register(instance)
 
@@ -83,6 +157,7 @@ func (s *RegistryServer) Register(ctx context.Context, request *pb.RegisterReque
 // renew a service instance
 func (s *RegistryServer) Renew(ctx context.Context, request *pb.RenewRequest) (*pb.RenewResponse, error) {
 
+	setSpanAttributes(ctx, request.Segment, request.ServiceName, request.Ip, request.Port)
 	in, err := s.r.Renew(request.Segment, request.ServiceName, request.Ip, request.Port)
 	if err != nil {
 		e := err.(registry.RegistryError)
@@ -110,7 +185,9 @@ func (s *RegistryServer) Renew(ctx context.Context, request *pb.RenewRequest) (*
 
 // cancel a service instance
 func (s *RegistryServer) Cancel(ctx context.Context, request *pb.CancelRequest) (*pb.CancelResponse, error) {
-	in, err := s.r.Renew(request.Segment, request.ServiceName, request.Ip, request.Port)
+
+	setSpanAttributes(ctx, request.Segment, request.ServiceName, request.Ip, request.Port)
+	in, err := s.r.Cancel(request.Segment, request.ServiceName, request.Ip, request.Port)
 	if err != nil {
 		e := err.(registry.RegistryError)
 		return &pb.CancelResponse{
@@ -138,6 +215,7 @@ func (s *RegistryServer) Cancel(ctx context.Context, request *pb.CancelRequest)
 // fetch service instance list
 func (s *RegistryServer) Fetch(ctx context.Context, request *pb.FetchRequest) (*pb.FetchResponse, error) {
 
+	trace.SpanFromContext(ctx).SetAttributes(tracing.SegmentKey.String(request.Segment), tracing.ServiceKey.String(request.ServiceName))
 	instances, err := s.r.Fetch(request.Segment, request.ServiceName)
 	if err != nil {
 		e := err.(registry.RegistryError)
@@ -1,8 +1,12 @@
 package server
 
 import (
-	"github.com/busgo/elsa/pkg/log"
+	"context"
 	"testing"
+
+	"github.com/busgo/elsa/internal/registry"
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/proto/pb"
 )
 
 var endpoints = []string{"127.0.0.1:8005"}
@@ -26,3 +30,26 @@ func TestRegistryServer_Start(t *testing.T) {
 
 	log.Infof("new registry server success %#v", s)
 }
+
+// test the cancel removes the instance instead of renewing it
+func TestRegistryServer_Cancel(t *testing.T) {
+
+	s := &RegistryServer{r: registry.NewRegistry()}
+	ctx := context.Background()
+	request := &pb.RegisterRequest{Segment: "dev", ServiceName: "com.busgo.trade.proto.TradeService", Ip: "127.0.0.1", Port: 8001}
+	if _, err := s.Register(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := s.Cancel(ctx, &pb.CancelRequest{Segment: "dev", ServiceName: request.ServiceName, Ip: request.Ip, Port: request.Port})
+	if err != nil || response.Code != 0 {
+		t.Fatalf("the cancel must succeed but %v %+v", err, response)
+	}
+	fetched, err := s.Fetch(ctx, &pb.FetchRequest{Segment: "dev", ServiceName: request.ServiceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched.Instances) != 0 {
+		t.Fatalf("the canceled instance must be removed but %+v", fetched.Instances)
+	}
+}
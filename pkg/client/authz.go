@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/middleware"
+	"github.com/busgo/elsa/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the metadata key of the caller token signed by the stubs
+	CallerTokenKey = "x-elsa-caller"
+	// the ttl of the caller tokens
+	DefaultCallerTokenTTL = time.Hour
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// the policy rule allows or denies the callers to call the methods
+type PolicyRule struct {
+	Callers []string `json:"callers"` // the caller name patterns, '*' matches every caller including the anonymous
+	Methods []string `json:"methods"` // the service name patterns or the full method patterns like /com.busgo.trade.proto.TradeService/*
+	Effect  string   `json:"effect"`  // allow or deny
+}
+
+// the authorization policy of the server,the deny rules take precedence over the allow rules
+type Policy struct {
+	Default string       `json:"default"` // the effect when no rule matches, default is deny
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// load the authorization policy from a json file
+func LoadPolicy(file string) (Policy, error) {
+
+	policy := Policy{}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return policy, err
+	}
+	if err = json.Unmarshal(content, &policy); err != nil {
+		return policy, err
+	}
+	for _, rule := range policy.Rules {
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return policy, fmt.Errorf("the policy rule effect:%s must be allow or deny", rule.Effect)
+		}
+	}
+	return policy, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the rule matches the caller and the method
+func (r PolicyRule) match(caller, fullMethod string) bool {
+
+	if !matchAny(r.Callers, caller) {
+		return false
+	}
+	service := serviceName(fullMethod)
+	for _, method := range r.Methods {
+		name := service
+		if strings.HasPrefix(method, "/") {
+			name = fullMethod
+		}
+		if ok, _ := path.Match(method, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check the caller is allowed to call the method
+func (p Policy) Allow(caller, fullMethod string) bool {
+
+	allowed := false
+	for _, rule := range p.Rules {
+		if !rule.match(caller, fullMethod) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed || p.Default == EffectAllow
+}
+
+type callerKey struct{}
+
+// the caller name of the request authorized by the server
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// the caller identity of the mutual tls certificate, the uri SAN is preferred to the dns SAN
+func certificateIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// the authorizer of the elsa server requests
+type authorizer struct {
+	name   string
+	policy Policy
+	secret string
+}
+
+// identify the caller with the mutual tls certificate or the caller token
+func (a *authorizer) identify(ctx context.Context) (string, error) {
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 && len(info.State.VerifiedChains[0]) > 0 {
+			return certificateIdentity(info.State.VerifiedChains[0][0]), nil
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(CallerTokenKey)
+	if len(tokens) == 0 || a.secret == "" {
+		return AnonymousCaller, nil
+	}
+	claims, err := security.ParseToken(a.secret, tokens[0])
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// authorize the request and return the context with the caller
+func (a *authorizer) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+
+	caller, err := a.identify(ctx)
+	if err != nil {
+		log.Warnf("the %s server denied the method:%s with the caller token:%s", a.name, fullMethod, err.Error())
+		return nil, status.Errorf(codes.Unauthenticated, "the caller token is invalid:%s", err.Error())
+	}
+	if !a.policy.Allow(caller, fullMethod) {
+		log.Warnf("the %s server denied the caller:%s to call the method:%s", a.name, caller, fullMethod)
+		return nil, status.Errorf(codes.PermissionDenied, "the caller:%s is not allowed to call the method:%s", caller, fullMethod)
+	}
+	log.Debugf("the %s server allowed the caller:%s to call the method:%s", a.name, caller, fullMethod)
+	return context.WithValue(ctx, callerKey{}, caller), nil
+}
+
+// the unary server interceptor
+func (a *authorizer) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// the stream server interceptor
+func (a *authorizer) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, middleware.WrapServerStream(ss, ctx))
+}
+
+// the client interceptors attach the caller token signed with the server name
+type callerTokenInterceptor struct {
+	signer *security.TokenSigner
+}
+
+// the context with the caller token
+func (c *callerTokenInterceptor) withToken(ctx context.Context) (context.Context, error) {
+	token, err := c.signer.Token()
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, CallerTokenKey, token), nil
+}
+
+// the unary client interceptor
+func (c *callerTokenInterceptor) Unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return err
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// the stream client interceptor
+func (c *callerTokenInterceptor) Stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+	ctx, err := c.withToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
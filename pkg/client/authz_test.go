@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/busgo/elsa/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestPolicy_Allow(t *testing.T) {
+
+	policy := Policy{
+		Rules: []PolicyRule{
+			{Callers: []string{"order-*"}, Methods: []string{"com.busgo.trade.proto.*"}, Effect: EffectAllow},
+			{Callers: []string{"*"}, Methods: []string{"/com.busgo.trade.proto.TradeService/Refund"}, Effect: EffectDeny},
+			{Callers: []string{"admin"}, Methods: []string{"*"}, Effect: EffectAllow},
+		},
+	}
+	cases := []struct {
+		caller string
+		method string
+		allow  bool
+	}{
+		{"order-api", "/com.busgo.trade.proto.TradeService/Ping", true},
+		{"order-api", "/com.busgo.trade.proto.TradeService/Refund", false},
+		{"user-api", "/com.busgo.trade.proto.TradeService/Ping", false},
+		{"admin", "/com.busgo.user.proto.UserService/Get", true},
+		{"admin", "/com.busgo.trade.proto.TradeService/Refund", false},
+		{AnonymousCaller, "/com.busgo.trade.proto.TradeService/Ping", false},
+	}
+	for _, c := range cases {
+		if allow := policy.Allow(c.caller, c.method); allow != c.allow {
+			t.Fatalf("the caller:%s of method:%s must be allowed %v", c.caller, c.method, c.allow)
+		}
+	}
+	policy.Default = EffectAllow
+	if !policy.Allow(AnonymousCaller, "/com.busgo.user.proto.UserService/Get") {
+		t.Fatal("the default allow policy must allow the unmatched calls")
+	}
+}
+
+func TestAuthorizer_Unary(t *testing.T) {
+
+	a := &authorizer{
+		name:   "trade",
+		secret: "secret",
+		policy: Policy{Rules: []PolicyRule{{Callers: []string{"order"}, Methods: []string{"*"}, Effect: EffectAllow}}},
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/com.busgo.trade.proto.TradeService/Ping"}
+	call := func(ctx context.Context) (string, codes.Code) {
+		caller := ""
+		_, err := a.Unary(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			caller = CallerFromContext(ctx)
+			return nil, nil
+		})
+		return caller, status.Code(err)
+	}
+
+	if _, code := call(context.Background()); code != codes.PermissionDenied {
+		t.Fatalf("the anonymous caller must be denied but %s", code)
+	}
+	token, _ := security.SignToken("secret", "order", "", 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CallerTokenKey, token))
+	if caller, code := call(ctx); code != codes.OK || caller != "order" {
+		t.Fatalf("the order caller must be allowed but %s,%s", caller, code)
+	}
+	forged, _ := security.SignToken("other", "order", "", 0)
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(CallerTokenKey, forged))
+	if _, code := call(ctx); code != codes.Unauthenticated {
+		t.Fatalf("the forged caller token must be unauthenticated but %s", code)
+	}
+}
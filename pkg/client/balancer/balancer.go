@@ -1 +1,226 @@
 package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// the elsa balancer name
+const Name = "elsa_round_robin"
+
+func init() {
+	balancer.Register(&elsaBalancerBuilder{})
+}
+
+// the elsa balancer config carried by the grpc service config
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	OutlierDetection                  *OutlierDetectionConfig `json:"outlierDetection,omitempty"`
+	WarmUp                            *WarmUpConfig           `json:"warmUp,omitempty"`
+}
+
+// the duration of the config, the duration string like 30s or the nanoseconds in the json
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(content []byte) error {
+
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = duration(time.Duration(v))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = duration(parsed)
+	default:
+		return fmt.Errorf("the duration:%s is invalid", string(content))
+	}
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// build the service config json using the elsa balancer
+func BuildServiceConfig(config Config) (string, error) {
+
+	content, err := json.Marshal(map[string]interface{}{
+		"loadBalancingConfig": []map[string]interface{}{
+			{Name: config},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+type elsaBalancerBuilder struct {
+}
+
+// Build creates a new balancer with the ClientConn.
+func (b *elsaBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+
+	detector := newOutlierDetector()
+	pickerBuilder := &elsaPickerBuilder{detector: detector}
+	builder := base.NewBalancerBuilder(Name, pickerBuilder, base.Config{HealthCheck: true})
+	return &elsaBalancer{
+		Balancer:      builder.Build(cc, opts),
+		detector:      detector,
+		pickerBuilder: pickerBuilder,
+	}
+}
+
+// Name returns the name of balancers built by this builder.
+func (b *elsaBalancerBuilder) Name() string {
+	return Name
+}
+
+// ParseConfig parses the JSON load balancer config provided into an
+// internal form or returns an error if the config is invalid.
+func (b *elsaBalancerBuilder) ParseConfig(content json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+
+	config := new(Config)
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+type elsaBalancer struct {
+	balancer.Balancer
+	detector      *outlierDetector
+	pickerBuilder *elsaPickerBuilder
+	once          sync.Once
+}
+
+// UpdateClientConnState is called by gRPC when the state of the ClientConn
+// changes.
+func (b *elsaBalancer) UpdateClientConnState(state balancer.ClientConnState) error {
+
+	if config, ok := state.BalancerConfig.(*Config); ok {
+		if config.OutlierDetection != nil {
+			b.detector.updateConfig(*config.OutlierDetection)
+		}
+		if config.WarmUp != nil {
+			b.pickerBuilder.setWarmUp(*config.WarmUp)
+		}
+	}
+	return b.Balancer.UpdateClientConnState(state)
+}
+
+// Close closes the balancer.
+func (b *elsaBalancer) Close() {
+	b.once.Do(b.detector.close)
+	b.Balancer.Close()
+}
+
+type elsaPickerBuilder struct {
+	detector *outlierDetector
+	warmUp   WarmUpConfig
+	sync.RWMutex
+}
+
+func (pb *elsaPickerBuilder) setWarmUp(config WarmUpConfig) {
+	pb.Lock()
+	defer pb.Unlock()
+	pb.warmUp = config
+}
+
+// Build returns a picker that will be used by gRPC to pick a SubConn.
+func (pb *elsaPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.RLock()
+	warmUp := pb.warmUp
+	pb.RUnlock()
+
+	subConns := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	addresses := make([]string, 0, len(info.ReadySCs))
+	startTimes := make([]time.Time, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		subConns = append(subConns, sc)
+		addresses = append(addresses, sci.Address.Addr)
+		start, _ := startTime(sci.Address)
+		startTimes = append(startTimes, start)
+	}
+	pb.detector.retain(addresses)
+	log.Debugf("the elsa balancer build picker with addresses:%v", addresses)
+	return &elsaPicker{
+		subConns:   subConns,
+		addresses:  addresses,
+		startTimes: startTimes,
+		warmUp:     warmUp,
+		detector:   pb.detector,
+	}
+}
+
+type elsaPicker struct {
+	subConns   []balancer.SubConn
+	addresses  []string
+	startTimes []time.Time
+	warmUp     WarmUpConfig
+	detector   *outlierDetector
+	next       uint32
+}
+
+// the warming up instance is picked with the probability of its weight
+func (p *elsaPicker) accept(index int, now time.Time) bool {
+	weight := p.warmUp.weight(p.startTimes[index], now)
+	return weight >= 1 || rand.Float64() < weight
+}
+
+// Pick returns the connection to use for this RPC and related information.
+func (p *elsaPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+
+	tracker := PickTrackerFromContext(info.Ctx)
+	size := uint32(len(p.subConns))
+	start := atomic.AddUint32(&p.next, 1)
+	now := time.Now()
+	// prefer the healthy instances which have not been picked by the other attempts and
+	// accepted by the warm up weight, fall back to plain round robin when every instance has been ejected
+	index, best := start%size, 0
+	for i := uint32(0); i < size && best < 4; i++ {
+		idx := (start + i) % size
+		if p.detector.isEjected(p.addresses[idx]) {
+			continue
+		}
+		score := 1
+		if tracker == nil || !tracker.Picked(p.addresses[idx]) {
+			score += 2
+		}
+		if p.accept(int(idx), now) {
+			score++
+		}
+		if score > best {
+			index, best = idx, score
+		}
+	}
+
+	address := p.addresses[index]
+	if tracker != nil {
+		tracker.add(address)
+	}
+	return balancer.PickResult{
+		SubConn: p.subConns[index],
+		Done:    p.detector.track(address),
+	}, nil
+}
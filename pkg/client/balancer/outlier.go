@@ -0,0 +1,389 @@
+package balancer
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the outlier detection config, the zero value of a field means use the default value,
+// the durations are the duration strings like 30s in the json
+type OutlierDetectionConfig struct {
+	// the evaluate interval
+	Interval time.Duration `json:"interval,omitempty"`
+	// the base ejection time,the real ejection time is multiplied by the ejection times
+	BaseEjectionTime time.Duration `json:"baseEjectionTime,omitempty"`
+	// the max ejection time
+	MaxEjectionTime time.Duration `json:"maxEjectionTime,omitempty"`
+	// the max percent of instances can be ejected
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty"`
+	// eject the instance immediately after the consecutive errors, negative means disable
+	ConsecutiveErrors int `json:"consecutiveErrors,omitempty"`
+	// the min instances number to evaluate the success rate and latency
+	MinimumHosts int `json:"minimumHosts,omitempty"`
+	// the min request volume of a instance in a interval to take part in the evaluation
+	RequestVolume int `json:"requestVolume,omitempty"`
+	// eject the instance whose success rate less than mean - stdev * factor, negative means disable
+	SuccessRateStdevFactor float64 `json:"successRateStdevFactor,omitempty"`
+	// eject the instance whose latency greater than mean + stdev * factor, negative means disable
+	LatencyStdevFactor float64 `json:"latencyStdevFactor,omitempty"`
+}
+
+const (
+	DefaultOutlierInterval               = time.Second * 10
+	DefaultOutlierBaseEjectionTime       = time.Second * 30
+	DefaultOutlierMaxEjectionTime        = time.Second * 300
+	DefaultOutlierMaxEjectionPercent     = 10
+	DefaultOutlierConsecutiveErrors      = 5
+	DefaultOutlierMinimumHosts           = 5
+	DefaultOutlierRequestVolume          = 100
+	DefaultOutlierSuccessRateStdevFactor = 1.9
+	DefaultOutlierLatencyStdevFactor     = 1.9
+)
+
+// the default outlier detection config
+func DefaultOutlierDetectionConfig() OutlierDetectionConfig {
+	return OutlierDetectionConfig{
+		Interval:               DefaultOutlierInterval,
+		BaseEjectionTime:       DefaultOutlierBaseEjectionTime,
+		MaxEjectionTime:        DefaultOutlierMaxEjectionTime,
+		MaxEjectionPercent:     DefaultOutlierMaxEjectionPercent,
+		ConsecutiveErrors:      DefaultOutlierConsecutiveErrors,
+		MinimumHosts:           DefaultOutlierMinimumHosts,
+		RequestVolume:          DefaultOutlierRequestVolume,
+		SuccessRateStdevFactor: DefaultOutlierSuccessRateStdevFactor,
+		LatencyStdevFactor:     DefaultOutlierLatencyStdevFactor,
+	}
+}
+
+type outlierDetectionJSON OutlierDetectionConfig
+
+func (c OutlierDetectionConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		outlierDetectionJSON
+		Interval         duration `json:"interval,omitempty"`
+		BaseEjectionTime duration `json:"baseEjectionTime,omitempty"`
+		MaxEjectionTime  duration `json:"maxEjectionTime,omitempty"`
+	}{outlierDetectionJSON(c), duration(c.Interval), duration(c.BaseEjectionTime), duration(c.MaxEjectionTime)})
+}
+
+func (c *OutlierDetectionConfig) UnmarshalJSON(content []byte) error {
+
+	value := struct {
+		*outlierDetectionJSON
+		Interval         duration `json:"interval,omitempty"`
+		BaseEjectionTime duration `json:"baseEjectionTime,omitempty"`
+		MaxEjectionTime  duration `json:"maxEjectionTime,omitempty"`
+	}{outlierDetectionJSON: (*outlierDetectionJSON)(c)}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	c.Interval, c.BaseEjectionTime, c.MaxEjectionTime = time.Duration(value.Interval), time.Duration(value.BaseEjectionTime), time.Duration(value.MaxEjectionTime)
+	return nil
+}
+
+// fill the zero value fields with the default value
+func (c OutlierDetectionConfig) withDefaults() OutlierDetectionConfig {
+
+	d := DefaultOutlierDetectionConfig()
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = d.BaseEjectionTime
+	}
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = d.MaxEjectionTime
+	}
+	// the max ejection time is never less than the base ejection time
+	if c.MaxEjectionTime < c.BaseEjectionTime {
+		c.MaxEjectionTime = c.BaseEjectionTime
+	}
+	if c.MaxEjectionPercent <= 0 || c.MaxEjectionPercent > 100 {
+		c.MaxEjectionPercent = d.MaxEjectionPercent
+	}
+	if c.ConsecutiveErrors == 0 {
+		c.ConsecutiveErrors = d.ConsecutiveErrors
+	}
+	if c.MinimumHosts <= 0 {
+		c.MinimumHosts = d.MinimumHosts
+	}
+	if c.RequestVolume <= 0 {
+		c.RequestVolume = d.RequestVolume
+	}
+	if c.SuccessRateStdevFactor == 0 {
+		c.SuccessRateStdevFactor = d.SuccessRateStdevFactor
+	}
+	if c.LatencyStdevFactor == 0 {
+		c.LatencyStdevFactor = d.LatencyStdevFactor
+	}
+	return c
+}
+
+// the instance statistics
+type hostStat struct {
+	success      int64
+	failure      int64
+	latency      time.Duration
+	consecutive  int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+type outlierDetector struct {
+	config     OutlierDetectionConfig
+	hosts      map[string]*hostStat
+	now        func() time.Time
+	enabled    bool
+	resetChan  chan time.Duration
+	closedChan chan bool
+	sync.RWMutex
+}
+
+// new a outlier detector,it is disabled until a config has been set
+func newOutlierDetector() *outlierDetector {
+	return &outlierDetector{
+		config:     DefaultOutlierDetectionConfig(),
+		hosts:      make(map[string]*hostStat),
+		now:        time.Now,
+		resetChan:  make(chan time.Duration, 1),
+		closedChan: make(chan bool),
+		RWMutex:    sync.RWMutex{},
+	}
+}
+
+// update the config and enable the outlier detector
+func (d *outlierDetector) updateConfig(config OutlierDetectionConfig) {
+
+	config = config.withDefaults()
+	d.Lock()
+	changed := !d.enabled || d.config.Interval != config.Interval
+	first := !d.enabled
+	d.config = config
+	d.enabled = true
+	d.Unlock()
+
+	if first {
+		go d.lookup(config.Interval)
+		return
+	}
+	if changed {
+		select {
+		case d.resetChan <- config.Interval:
+		default:
+		}
+	}
+}
+
+func (d *outlierDetector) lookup(interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.evaluate()
+		case interval = <-d.resetChan:
+			ticker.Stop()
+			ticker = time.NewTicker(interval)
+		case <-d.closedChan:
+			log.Debugf("the outlier detector has closed...")
+			return
+		}
+	}
+}
+
+// close the outlier detector
+func (d *outlierDetector) close() {
+	close(d.closedChan)
+}
+
+// retain the statistics of the addresses only
+func (d *outlierDetector) retain(addresses []string) {
+
+	d.Lock()
+	defer d.Unlock()
+	hosts := make(map[string]*hostStat)
+	for _, address := range addresses {
+		stat, ok := d.hosts[address]
+		if !ok {
+			stat = new(hostStat)
+		}
+		hosts[address] = stat
+	}
+	d.hosts = hosts
+}
+
+// check the address has been ejected
+func (d *outlierDetector) isEjected(address string) bool {
+
+	d.RLock()
+	defer d.RUnlock()
+	if !d.enabled {
+		return false
+	}
+	stat, ok := d.hosts[address]
+	return ok && d.now().Before(stat.ejectedUntil)
+}
+
+// track a rpc call with the address
+func (d *outlierDetector) track(address string) func(info balancer.DoneInfo) {
+
+	d.RLock()
+	enabled := d.enabled
+	d.RUnlock()
+	if !enabled {
+		return nil
+	}
+	start := d.now()
+	return func(info balancer.DoneInfo) {
+		d.record(address, !isFailure(info.Err), d.now().Sub(start))
+	}
+}
+
+// record the result of a rpc call
+func (d *outlierDetector) record(address string, success bool, latency time.Duration) {
+
+	d.Lock()
+	defer d.Unlock()
+	stat, ok := d.hosts[address]
+	if !ok {
+		return
+	}
+	stat.latency += latency
+	if success {
+		stat.success++
+		stat.consecutive = 0
+		return
+	}
+	stat.failure++
+	stat.consecutive++
+	if d.config.ConsecutiveErrors > 0 && stat.consecutive >= d.config.ConsecutiveErrors {
+		stat.consecutive = 0
+		if d.eject(address, stat) {
+			log.Warnf("the instance:%s has been ejected after %d consecutive errors", address, d.config.ConsecutiveErrors)
+		}
+	}
+}
+
+// evaluate the success rate and latency of all instances and eject the outliers
+func (d *outlierDetector) evaluate() {
+
+	d.Lock()
+	defer d.Unlock()
+
+	now := d.now()
+	candidates := make(map[string]*hostStat)
+	for address, stat := range d.hosts {
+		if !now.Before(stat.ejectedUntil) && stat.ejections > 0 && stat.ejectedUntil.Add(d.config.Interval).Before(now) {
+			stat.ejections--
+		}
+		if stat.success+stat.failure >= int64(d.config.RequestVolume) {
+			candidates[address] = stat
+		}
+	}
+
+	if len(candidates) >= d.config.MinimumHosts {
+		if d.config.SuccessRateStdevFactor > 0 {
+			rates := make(map[string]float64)
+			for address, stat := range candidates {
+				rates[address] = float64(stat.success) / float64(stat.success+stat.failure)
+			}
+			mean, stdev := meanAndStdev(rates)
+			for address, rate := range rates {
+				if rate < mean-stdev*d.config.SuccessRateStdevFactor && d.eject(address, candidates[address]) {
+					log.Warnf("the instance:%s has been ejected with success rate:%.4f,mean:%.4f,stdev:%.4f", address, rate, mean, stdev)
+				}
+			}
+		}
+		if d.config.LatencyStdevFactor > 0 {
+			latencies := make(map[string]float64)
+			for address, stat := range candidates {
+				latencies[address] = float64(stat.latency) / float64(stat.success+stat.failure)
+			}
+			mean, stdev := meanAndStdev(latencies)
+			for address, latency := range latencies {
+				if latency > mean+stdev*d.config.LatencyStdevFactor && d.eject(address, candidates[address]) {
+					log.Warnf("the instance:%s has been ejected with latency:%s,mean:%s", address, time.Duration(latency), time.Duration(mean))
+				}
+			}
+		}
+	}
+
+	// reset the interval statistics
+	for _, stat := range d.hosts {
+		stat.success = 0
+		stat.failure = 0
+		stat.latency = 0
+	}
+}
+
+// eject the instance if the max ejection percent allowed
+func (d *outlierDetector) eject(address string, stat *hostStat) bool {
+
+	now := d.now()
+	if now.Before(stat.ejectedUntil) {
+		return false
+	}
+
+	ejected := 0
+	for _, s := range d.hosts {
+		if now.Before(s.ejectedUntil) {
+			ejected++
+		}
+	}
+	// at least one instance can be ejected,but never all of them
+	allowed := len(d.hosts) * d.config.MaxEjectionPercent / 100
+	if allowed == 0 {
+		allowed = 1
+	}
+	if ejected >= allowed || ejected+1 >= len(d.hosts) {
+		log.Warnf("the instance:%s is an outlier but the max ejection percent:%d has been reached", address, d.config.MaxEjectionPercent)
+		return false
+	}
+
+	stat.ejections++
+	duration := d.config.BaseEjectionTime * time.Duration(stat.ejections)
+	if duration > d.config.MaxEjectionTime {
+		duration = d.config.MaxEjectionTime
+	}
+	stat.ejectedUntil = now.Add(duration)
+	return true
+}
+
+// the mean and standard deviation of the values
+func meanAndStdev(values map[string]float64) (float64, float64) {
+
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// check the rpc error is a failure of the instance rather than the business
+func isFailure(err error) bool {
+
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
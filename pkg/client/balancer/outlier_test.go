@@ -0,0 +1,155 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func newTestDetector(size int, config OutlierDetectionConfig) (*outlierDetector, []string) {
+
+	d := newOutlierDetector()
+	d.config = config.withDefaults()
+	d.enabled = true
+	addresses := make([]string, 0)
+	for i := 0; i < size; i++ {
+		addresses = append(addresses, fmt.Sprintf("192.168.1.%d:8001", i+1))
+	}
+	d.retain(addresses)
+	return d, addresses
+}
+
+// test eject the instance with low success rate
+func TestOutlierDetector_SuccessRate(t *testing.T) {
+
+	d, addresses := newTestDetector(5, OutlierDetectionConfig{MaxEjectionPercent: 20, ConsecutiveErrors: -1, LatencyStdevFactor: -1})
+	for i, address := range addresses {
+		for j := 0; j < 100; j++ {
+			d.record(address, i != 0 || j%2 == 0, time.Millisecond)
+		}
+	}
+	d.evaluate()
+
+	if !d.isEjected(addresses[0]) {
+		t.Fatalf("the instance:%s must be ejected", addresses[0])
+	}
+	for _, address := range addresses[1:] {
+		if d.isEjected(address) {
+			t.Fatalf("the instance:%s must not be ejected", address)
+		}
+	}
+}
+
+// test eject the instance with high latency
+func TestOutlierDetector_Latency(t *testing.T) {
+
+	d, addresses := newTestDetector(5, OutlierDetectionConfig{MaxEjectionPercent: 20, ConsecutiveErrors: -1, SuccessRateStdevFactor: -1})
+	for i, address := range addresses {
+		latency := time.Millisecond * 10
+		if i == 4 {
+			latency = time.Second
+		}
+		for j := 0; j < 100; j++ {
+			d.record(address, true, latency)
+		}
+	}
+	d.evaluate()
+
+	if !d.isEjected(addresses[4]) {
+		t.Fatalf("the instance:%s must be ejected", addresses[4])
+	}
+}
+
+// test the max ejection percent and the ejection time
+func TestOutlierDetector_ConsecutiveErrors(t *testing.T) {
+
+	now := time.Now()
+	d, addresses := newTestDetector(4, OutlierDetectionConfig{MaxEjectionPercent: 50, ConsecutiveErrors: 3, BaseEjectionTime: time.Second * 10})
+	d.now = func() time.Time { return now }
+	for _, address := range addresses {
+		for j := 0; j < 3; j++ {
+			d.record(address, false, time.Millisecond)
+		}
+	}
+
+	ejected := 0
+	for _, address := range addresses {
+		if d.isEjected(address) {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Fatalf("the ejected instance size must be 2 but %d", ejected)
+	}
+
+	now = now.Add(time.Second * 11)
+	for _, address := range addresses {
+		if d.isEjected(address) {
+			t.Fatalf("the instance:%s must be returned after the ejection time", address)
+		}
+	}
+}
+
+// test the service config is accepted by grpc
+func TestBuildServiceConfig(t *testing.T) {
+
+	config := DefaultOutlierDetectionConfig()
+	serviceConfig, err := BuildServiceConfig(Config{OutlierDetection: &config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := grpc.Dial("passthrough:///127.0.0.1:8001", grpc.WithInsecure(), grpc.WithDefaultServiceConfig(serviceConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	t.Logf("the service config is %s", serviceConfig)
+}
+
+// test the max ejection time is never less than the base ejection time
+func TestOutlierDetectionConfig_WithDefaults(t *testing.T) {
+
+	cases := []struct {
+		config OutlierDetectionConfig
+		max    time.Duration
+	}{
+		{OutlierDetectionConfig{}, DefaultOutlierMaxEjectionTime},
+		{OutlierDetectionConfig{BaseEjectionTime: time.Minute, MaxEjectionTime: time.Second * 10}, time.Minute},
+		{OutlierDetectionConfig{BaseEjectionTime: time.Hour}, time.Hour},
+		{OutlierDetectionConfig{MaxEjectionTime: time.Minute * 2}, time.Minute * 2},
+	}
+	for i, c := range cases {
+		if max := c.config.withDefaults().MaxEjectionTime; max != c.max {
+			t.Fatalf("the max ejection time of the case:%d must be %s but %s", i, c.max, max)
+		}
+	}
+}
+
+// test the durations of the config are the duration strings
+func TestOutlierDetectionConfig_JSON(t *testing.T) {
+
+	config := new(Config)
+	content := `{"outlierDetection":{"interval":"5s","baseEjectionTime":30000000000,"maxEjectionPercent":20},"warmUp":{"window":"3m"}}`
+	if err := json.Unmarshal([]byte(content), config); err != nil {
+		t.Fatal(err)
+	}
+	od := config.OutlierDetection
+	if od.Interval != time.Second*5 || od.BaseEjectionTime != time.Second*30 || od.MaxEjectionPercent != 20 || config.WarmUp.Window != time.Minute*3 {
+		t.Fatalf("the config is unexpected:%+v %+v", od, config.WarmUp)
+	}
+	if err := json.Unmarshal([]byte(`{"outlierDetection":{"interval":"5 seconds"}}`), new(Config)); err == nil {
+		t.Fatalf("the invalid duration must be rejected")
+	}
+
+	marshaled, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(marshaled), `"interval":"5s"`) || !strings.Contains(string(marshaled), `"window":"3m0s"`) || strings.Contains(string(marshaled), "maxEjectionTime") {
+		t.Fatalf("the durations must be marshaled as the strings but %s", marshaled)
+	}
+}
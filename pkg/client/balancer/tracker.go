@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+)
+
+type trackerKey struct{}
+
+// the tracker records the picked instances of a rpc call among its attempts
+// so that the retry and hedging attempts avoid picking the same instance again
+type PickTracker struct {
+	addresses map[string]bool
+	sync.RWMutex
+}
+
+// new a pick tracker
+func NewPickTracker() *PickTracker {
+	return &PickTracker{addresses: make(map[string]bool), RWMutex: sync.RWMutex{}}
+}
+
+// attach the pick tracker to the context of the rpc call
+func WithPickTracker(ctx context.Context, tracker *PickTracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, tracker)
+}
+
+// get the pick tracker from the context of the rpc call
+func PickTrackerFromContext(ctx context.Context) *PickTracker {
+	if ctx == nil {
+		return nil
+	}
+	tracker, _ := ctx.Value(trackerKey{}).(*PickTracker)
+	return tracker
+}
+
+// check the address has been picked
+func (t *PickTracker) Picked(address string) bool {
+	t.RLock()
+	defer t.RUnlock()
+	return t.addresses[address]
+}
+
+// record the picked address
+func (t *PickTracker) add(address string) {
+	t.Lock()
+	defer t.Unlock()
+	t.addresses[address] = true
+}
@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// the metadata key of the instance start time in unix nanoseconds
+const StartTimeKey = "start_timestamp"
+
+const DefaultWarmUpMinWeight = 0.1
+
+// the warm up config, the weight of a new instance ramps linearly from the min weight to 1 in the window,
+// the window is the duration string like 3m in the json
+type WarmUpConfig struct {
+	// the warm up window since the instance start time
+	Window time.Duration `json:"window,omitempty"`
+	// the initial weight in (0,1], default is 0.1
+	MinWeight float64 `json:"minWeight,omitempty"`
+}
+
+type warmUpJSON WarmUpConfig
+
+func (c WarmUpConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		warmUpJSON
+		Window duration `json:"window,omitempty"`
+	}{warmUpJSON(c), duration(c.Window)})
+}
+
+func (c *WarmUpConfig) UnmarshalJSON(content []byte) error {
+
+	value := struct {
+		*warmUpJSON
+		Window duration `json:"window,omitempty"`
+	}{warmUpJSON: (*warmUpJSON)(c)}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return err
+	}
+	c.Window = time.Duration(value.Window)
+	return nil
+}
+
+type startTimeKey struct{}
+
+// set the start time attribute of the address from the instance metadata
+func WithStartTime(address resolver.Address, metadata map[string]string) resolver.Address {
+
+	value, ok := metadata[StartTimeKey]
+	if !ok {
+		return address
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return address
+	}
+	if address.Attributes == nil {
+		address.Attributes = attributes.New(startTimeKey{}, time.Unix(0, timestamp))
+	} else {
+		address.Attributes = address.Attributes.WithValues(startTimeKey{}, time.Unix(0, timestamp))
+	}
+	return address
+}
+
+// the start time attribute of the address
+func startTime(address resolver.Address) (time.Time, bool) {
+	if address.Attributes == nil {
+		return time.Time{}, false
+	}
+	t, ok := address.Attributes.Value(startTimeKey{}).(time.Time)
+	return t, ok
+}
+
+// the weight of the instance started at the start time
+func (c WarmUpConfig) weight(start, now time.Time) float64 {
+
+	if c.Window <= 0 || start.IsZero() {
+		return 1
+	}
+	elapsed := now.Sub(start)
+	if elapsed >= c.Window {
+		return 1
+	}
+	minWeight := c.MinWeight
+	if minWeight <= 0 || minWeight > 1 {
+		minWeight = DefaultWarmUpMinWeight
+	}
+	return math.Max(minWeight, float64(elapsed)/float64(c.Window))
+}
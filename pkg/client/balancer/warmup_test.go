@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	address string
+}
+
+func TestWarmUpConfig_Weight(t *testing.T) {
+
+	config := WarmUpConfig{Window: time.Minute, MinWeight: 0.2}
+	now := time.Now()
+	cases := []struct {
+		start  time.Time
+		weight float64
+	}{
+		{time.Time{}, 1},
+		{now, 0.2},
+		{now.Add(-time.Second * 30), 0.5},
+		{now.Add(-time.Minute * 2), 1},
+	}
+	for _, c := range cases {
+		if weight := config.weight(c.start, now); weight != c.weight {
+			t.Fatalf("the weight of start:%s must be %.2f but %.2f", c.start, c.weight, weight)
+		}
+	}
+}
+
+func TestWithStartTime(t *testing.T) {
+
+	start := time.Now().Add(-time.Second)
+	address := WithStartTime(resolver.Address{Addr: "127.0.0.1:8001"}, map[string]string{
+		StartTimeKey: strconv.FormatInt(start.UnixNano(), 10),
+	})
+	if got, ok := startTime(address); !ok || !got.Equal(start) {
+		t.Fatalf("the start time must be %s but %s", start, got)
+	}
+	if _, ok := startTime(WithStartTime(resolver.Address{Addr: "127.0.0.1:8001"}, nil)); ok {
+		t.Fatal("the address without the metadata must have no start time")
+	}
+}
+
+// test the new instance gets a small share in the warm up window
+func TestElsaPicker_WarmUp(t *testing.T) {
+
+	d, addresses := newTestDetector(2, OutlierDetectionConfig{})
+	defer d.close()
+	p := &elsaPicker{
+		subConns:   []balancer.SubConn{&testSubConn{address: addresses[0]}, &testSubConn{address: addresses[1]}},
+		addresses:  addresses,
+		startTimes: []time.Time{time.Now().Add(-time.Hour), time.Now()},
+		warmUp:     WarmUpConfig{Window: time.Hour, MinWeight: 0.1},
+		detector:   d,
+	}
+	picks := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		result, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks[result.SubConn.(*testSubConn).address]++
+	}
+	share := float64(picks[addresses[1]]) / 10000
+	if share <= 0 || share > 0.15 {
+		t.Fatalf("the share of the warming up instance must be small but %.3f", share)
+	}
+}
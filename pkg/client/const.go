@@ -1,8 +1,20 @@
 package client
 
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
 const (
-	DefaultServerName       = "elsa"
-	DefaultSegment          = "elsa"
-	DefaultServerPort       = 8001
-	DefaultRegistryEndpoint = "127.0.0.1:8005"
+	DefaultServerName        = "elsa"
+	DefaultSegment           = "elsa"
+	DefaultServerPort        = 8001
+	DefaultRegistryEndpoint  = "127.0.0.1:8005"
+	DefaultShutdownDelay     = time.Second * 5  // wait the consumers to stop picking the instance
+	DefaultShutdownTimeout   = time.Second * 30 // wait the in flight requests to complete
+	DefaultReadinessInterval = time.Second      // the interval to check the readiness
 )
+
+// the default signals to shutdown the server gracefully
+var DefaultShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/busgo/elsa/pkg/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the time reserved for the handler to reply after the downstream calls return
+const DefaultDeadlineMargin = time.Millisecond * 5
+
+type inboundDeadlineKey struct{}
+
+// check the propagated deadline and remember it for the downstream calls
+func acceptDeadline(ctx context.Context, fullMethod string) (context.Context, error) {
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx, nil
+	}
+	if !time.Now().Before(deadline) {
+		return ctx, status.Errorf(codes.DeadlineExceeded, "the deadline of method:%s has expired before handling", fullMethod)
+	}
+	return context.WithValue(ctx, inboundDeadlineKey{}, deadline), nil
+}
+
+// reject the unary requests whose propagated deadline has already expired
+func deadlineUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, err := acceptDeadline(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// reject the streams whose propagated deadline has already expired
+func deadlineStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, err := acceptDeadline(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, middleware.WrapServerStream(ss, ctx))
+}
+
+type deadlineInterceptor struct {
+	timeouts map[string]time.Duration
+	margin   time.Duration
+}
+
+// new a deadline interceptor with the default timeouts keyed by method name, the empty key is the service default
+func newDeadlineInterceptor(timeouts map[string]time.Duration, margin time.Duration) *deadlineInterceptor {
+	return &deadlineInterceptor{timeouts: timeouts, margin: margin}
+}
+
+// the deadline of the outgoing call
+func (d *deadlineInterceptor) deadline(ctx context.Context, method string) (time.Time, bool) {
+
+	deadline, ok := ctx.Deadline()
+	timeout, has := d.timeouts[methodName(method)]
+	if !has {
+		timeout = d.timeouts[""]
+	}
+	if timeout > 0 {
+		if candidate := time.Now().Add(timeout); !ok || candidate.Before(deadline) {
+			deadline, ok = candidate, true
+		}
+	}
+
+	// called by a handler, leave the margin for the handler to reply
+	if inbound, has := ctx.Value(inboundDeadlineKey{}).(time.Time); has && d.margin > 0 {
+		if candidate := inbound.Add(-d.margin); !ok || candidate.Before(deadline) {
+			deadline, ok = candidate, true
+		}
+	}
+	return deadline, ok
+}
+
+// the unary client interceptor
+func (d *deadlineInterceptor) Unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	deadline, ok := d.deadline(ctx, method)
+	if !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	if !time.Now().Before(deadline) {
+		return status.Errorf(codes.DeadlineExceeded, "the deadline of method:%s has expired before calling", method)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// the client stream canceling the context of the deadline when the stream ends
+type deadlineClientStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+func (s *deadlineClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
+
+// the stream client interceptor, the deadline bounds the whole stream
+func (d *deadlineInterceptor) Stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+	deadline, ok := d.deadline(ctx, method)
+	if !ok {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	if !time.Now().Before(deadline) {
+		return nil, status.Errorf(codes.DeadlineExceeded, "the deadline of method:%s has expired before calling", method)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &deadlineClientStream{ClientStream: stream, cancel: cancel}, nil
+}
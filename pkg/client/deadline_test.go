@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// test reject the expired deadline
+func TestAcceptDeadline(t *testing.T) {
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := acceptDeadline(ctx, testMethod); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("the expired deadline must be rejected but %v", err)
+	}
+
+	if _, err := acceptDeadline(context.Background(), testMethod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// test the default timeout and the propagated deadline
+func TestDeadlineInterceptor_Deadline(t *testing.T) {
+
+	d := newDeadlineInterceptor(map[string]time.Duration{"": time.Second, "fetch": time.Millisecond * 100}, time.Millisecond*10)
+
+	deadline, ok := d.deadline(context.Background(), testMethod)
+	if !ok || time.Until(deadline) > time.Millisecond*100 {
+		t.Fatalf("the method timeout must be applied but %v", time.Until(deadline))
+	}
+
+	inbound := time.Now().Add(time.Millisecond * 50)
+	ctx, cancel := context.WithDeadline(context.Background(), inbound)
+	defer cancel()
+	ctx, err := acceptDeadline(ctx, testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline, ok = d.deadline(ctx, "/com.busgo.registry.proto.RegistryService/register")
+	if !ok || !deadline.Equal(inbound.Add(-time.Millisecond*10)) {
+		t.Fatalf("the propagated deadline must be shortened by the margin but %v", deadline)
+	}
+}
+
+// test the default timeout of the streams
+func TestDeadlineInterceptor_Stream(t *testing.T) {
+
+	d := newDeadlineInterceptor(map[string]time.Duration{"": time.Millisecond * 100}, time.Millisecond*10)
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return &testClientStream{}, nil
+	}
+	stream, err := d.Stream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, testMethod, streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deadline, ok := streamCtx.Deadline(); !ok || time.Until(deadline) > time.Millisecond*100 {
+		t.Fatalf("the default timeout must be applied to the stream but %v", deadline)
+	}
+	// the deadline is released when the stream ends
+	if err = stream.RecvMsg(nil); err != io.EOF || streamCtx.Err() != context.Canceled {
+		t.Fatalf("the context must be canceled at the end of the stream but %v %v", err, streamCtx.Err())
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Millisecond*5))
+	defer cancel()
+	ctx, err = acceptDeadline(ctx, testMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Stream(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, testMethod, streamer); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("the stream must be rejected when the propagated deadline is used up but %v", err)
+	}
+}
+
+type testClientStream struct {
+	grpc.ClientStream
+}
+
+func (s *testClientStream) RecvMsg(m interface{}) error {
+	return io.EOF
+}
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/busgo/elsa/pkg/limiter"
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// the caller identity used when the caller metadata is missing
+	AnonymousCaller = "anonymous"
+
+	// the max callers limited separately, the new callers beyond it share the limit of the overflow caller
+	DefaultMaxCallers = 10000
+	// the limiters of the callers idle longer than the timeout are evicted when the callers are full
+	DefaultCallerIdleTimeout = time.Minute * 10
+
+	overflowCaller = "~overflow"
+)
+
+// the limit of the requests, the zero value of a field means unlimited
+type Limit struct {
+	Rate           float64 // the requests per second
+	Burst          int     // the max burst requests, default is the rate
+	MaxConcurrency int     // the max in flight requests
+}
+
+// the limiters of a caller of a service
+type callerLimiter struct {
+	bucket      *limiter.TokenBucket
+	concurrency *limiter.ConcurrencyLimiter
+	lastSeen    int64 // the unix nanoseconds of the latest request
+}
+
+func (c *callerLimiter) touch(now time.Time) {
+	atomic.StoreInt64(&c.lastSeen, now.UnixNano())
+}
+
+// the caller has no request in the idle timeout and no in flight request
+func (c *callerLimiter) idle(now time.Time, timeout time.Duration) bool {
+	if c.concurrency != nil && c.concurrency.Inflight() > 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastSeen))) > timeout
+}
+
+// apply the limit to the limiters of the caller, the lock of the limiter must be held
+func (c *callerLimiter) apply(limit Limit) {
+
+	switch {
+	case limit.Rate <= 0:
+		c.bucket = nil
+	case c.bucket != nil:
+		c.bucket.SetLimit(limit.Rate, limit.Burst)
+	default:
+		c.bucket = limiter.NewTokenBucket(limit.Rate, limit.Burst)
+	}
+	switch {
+	case limit.MaxConcurrency <= 0:
+		c.concurrency = nil
+	case c.concurrency != nil:
+		c.concurrency.SetMax(limit.MaxConcurrency)
+	default:
+		c.concurrency = limiter.NewConcurrencyLimiter(limit.MaxConcurrency)
+	}
+}
+
+// the limiter of the elsa server requests
+type Limiter struct {
+	limits      map[string]Limit
+	buckets     map[string]*limiter.TokenBucket
+	concurrency map[string]*limiter.ConcurrencyLimiter
+	callerKey   string
+	callerLimit Limit
+	callers     map[string]*callerLimiter
+	maxCallers  int
+	idleTimeout time.Duration
+	evictedAt   time.Time
+	sync.RWMutex
+}
+
+// new a limiter without any limit
+func NewLimiter() *Limiter {
+	return &Limiter{
+		limits:      make(map[string]Limit),
+		buckets:     make(map[string]*limiter.TokenBucket),
+		concurrency: make(map[string]*limiter.ConcurrencyLimiter),
+		callers:     make(map[string]*callerLimiter),
+		maxCallers:  DefaultMaxCallers,
+		idleTimeout: DefaultCallerIdleTimeout,
+		RWMutex:     sync.RWMutex{},
+	}
+}
+
+// the service name of the full method like /com.busgo.trade.proto.TradeService/Ping
+func serviceName(fullMethod string) string {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if index := strings.LastIndex(fullMethod, "/"); index >= 0 {
+		return fullMethod[:index]
+	}
+	return fullMethod
+}
+
+// set the limit of a service name or a full method like /com.busgo.trade.proto.TradeService/Ping,
+// it can be changed at runtime
+func (l *Limiter) SetLimit(name string, limit Limit) {
+
+	l.Lock()
+	defer l.Unlock()
+	l.limits[name] = limit
+	l.apply(name, limit)
+	log.Infof("set the limit of %s rate:%.2f,burst:%d,maxConcurrency:%d", name, limit.Rate, limit.Burst, limit.MaxConcurrency)
+}
+
+// set the limit of each caller identified by the metadata key for every service,
+// it can be changed at runtime
+func (l *Limiter) SetCallerLimit(metadataKey string, limit Limit) {
+
+	l.Lock()
+	defer l.Unlock()
+	l.callerKey = strings.ToLower(metadataKey)
+	l.callerLimit = limit
+	for _, c := range l.callers {
+		c.apply(limit)
+	}
+	log.Infof("set the caller:%s limit rate:%.2f,burst:%d,maxConcurrency:%d", metadataKey, limit.Rate, limit.Burst, limit.MaxConcurrency)
+}
+
+// remove the limit of a service name or a full method
+func (l *Limiter) RemoveLimit(name string) {
+
+	l.Lock()
+	defer l.Unlock()
+	delete(l.limits, name)
+	delete(l.buckets, name)
+	delete(l.concurrency, name)
+}
+
+// apply the limit to the existing bucket and concurrency limiter of the key
+func (l *Limiter) apply(key string, limit Limit) {
+
+	if limit.Rate > 0 {
+		if bucket, ok := l.buckets[key]; ok {
+			bucket.SetLimit(limit.Rate, limit.Burst)
+		} else {
+			l.buckets[key] = limiter.NewTokenBucket(limit.Rate, limit.Burst)
+		}
+	} else {
+		delete(l.buckets, key)
+	}
+
+	if limit.MaxConcurrency > 0 {
+		if c, ok := l.concurrency[key]; ok {
+			c.SetMax(limit.MaxConcurrency)
+		} else {
+			l.concurrency[key] = limiter.NewConcurrencyLimiter(limit.MaxConcurrency)
+		}
+	} else {
+		delete(l.concurrency, key)
+	}
+}
+
+// the limiters of the caller of the request, the lock is only held to add a new caller
+func (l *Limiter) caller(ctx context.Context, service string) (string, *callerLimiter) {
+
+	l.RLock()
+	callerKey := l.callerKey
+	if callerKey == "" {
+		l.RUnlock()
+		return "", nil
+	}
+	caller := AnonymousCaller
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(callerKey); len(values) > 0 && values[0] != "" {
+			caller = values[0]
+		}
+	}
+	key := fmt.Sprintf("%s/%s", service, caller)
+	now := time.Now()
+	c, ok := l.callers[key]
+	l.RUnlock()
+	if ok {
+		c.touch(now)
+		return key, c
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if len(l.callers) >= l.maxCallers {
+		l.evict(now)
+	}
+	if _, ok = l.callers[key]; !ok && len(l.callers) >= l.maxCallers {
+		key = fmt.Sprintf("%s/%s", service, overflowCaller)
+	}
+	if c, ok = l.callers[key]; !ok {
+		c = new(callerLimiter)
+		c.apply(l.callerLimit)
+		l.callers[key] = c
+	}
+	c.touch(now)
+	return key, c
+}
+
+// evict the idle callers at most once per second, the lock must be held
+func (l *Limiter) evict(now time.Time) {
+
+	if now.Sub(l.evictedAt) < time.Second {
+		return
+	}
+	l.evictedAt = now
+	for key, c := range l.callers {
+		if c.idle(now, l.idleTimeout) {
+			delete(l.callers, key)
+		}
+	}
+}
+
+// acquire the permit of the request,the release func must be called after the request done
+func (l *Limiter) acquire(ctx context.Context, fullMethod string) (func(), error) {
+
+	service := serviceName(fullMethod)
+	callerName, caller := l.caller(ctx, service)
+
+	taken := make([]*limiter.TokenBucket, 0)
+	acquired := make([]*limiter.ConcurrencyLimiter, 0)
+	// return the tokens and the permits taken before a rejection
+	reject := func() {
+		for _, bucket := range taken {
+			bucket.Refund(1)
+		}
+		for _, c := range acquired {
+			c.Release()
+		}
+	}
+	check := func(name string, bucket *limiter.TokenBucket, concurrency *limiter.ConcurrencyLimiter) error {
+		if bucket != nil {
+			if !bucket.Allow() {
+				reject()
+				log.Warnf("the request of method:%s has been limited by the rate of %s", fullMethod, name)
+				return status.Errorf(codes.ResourceExhausted, "the rate limit of %s has been exceeded", name)
+			}
+			taken = append(taken, bucket)
+		}
+		if concurrency != nil {
+			if !concurrency.TryAcquire() {
+				reject()
+				log.Warnf("the request of method:%s has been limited by the concurrency of %s", fullMethod, name)
+				return status.Errorf(codes.ResourceExhausted, "the concurrency limit of %s has been exceeded", name)
+			}
+			acquired = append(acquired, concurrency)
+		}
+		return nil
+	}
+
+	l.RLock()
+	defer l.RUnlock()
+	for _, name := range []string{service, fullMethod} {
+		if err := check(name, l.buckets[name], l.concurrency[name]); err != nil {
+			return nil, err
+		}
+	}
+	if caller != nil {
+		if err := check("caller:"+callerName, caller.bucket, caller.concurrency); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		for _, c := range acquired {
+			c.Release()
+		}
+	}, nil
+}
+
+// the unary server interceptor
+func (l *Limiter) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	release, err := l.acquire(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return handler(ctx, req)
+}
+
+// the stream server interceptor
+func (l *Limiter) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	release, err := l.acquire(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return handler(srv, ss)
+}
+
+// the adaptive limit interceptors of the elsa server
+type adaptiveLimitInterceptor struct {
+	limiter *limiter.AdaptiveLimiter
+}
+
+// the canceled and deadline exceeded requests are not taken as the latency samples
+func isLatencySample(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	code := status.Code(err)
+	return code != codes.Canceled && code != codes.DeadlineExceeded
+}
+
+// the unary server interceptor
+func (a *adaptiveLimitInterceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	release, ok := a.limiter.TryAcquire()
+	if !ok {
+		log.Warnf("the request of method:%s has been shed by the adaptive limit:%d", info.FullMethod, a.limiter.Limit())
+		return nil, status.Errorf(codes.ResourceExhausted, "the adaptive concurrency limit has been exceeded")
+	}
+	response, err := handler(ctx, req)
+	release(isLatencySample(ctx, err))
+	return response, err
+}
+
+// the stream server interceptor,the streams are limited but not taken as the latency samples
+func (a *adaptiveLimitInterceptor) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	release, ok := a.limiter.TryAcquire()
+	if !ok {
+		log.Warnf("the stream of method:%s has been shed by the adaptive limit:%d", info.FullMethod, a.limiter.Limit())
+		return status.Errorf(codes.ResourceExhausted, "the adaptive concurrency limit has been exceeded")
+	}
+	defer release(false)
+	return handler(srv, ss)
+}
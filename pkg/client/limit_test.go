@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testService      = "com.busgo.trade.proto.TradeService"
+	testPingMethod   = "/com.busgo.trade.proto.TradeService/Ping"
+	testVerifyMethod = "/com.busgo.trade.proto.TradeService/Verify"
+)
+
+// call the method through the unary interceptor of the limiter
+func callLimited(l *Limiter, ctx context.Context, method string) error {
+	_, err := l.UnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	return err
+}
+
+func callerContext(caller string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-caller", caller))
+}
+
+// test the service and the method limits
+func TestLimiter_SetLimit(t *testing.T) {
+
+	l := NewLimiter()
+	l.SetLimit(testService, Limit{Rate: 0.001, Burst: 2})
+	l.SetLimit(testPingMethod, Limit{Rate: 0.001, Burst: 1})
+	ctx := context.Background()
+
+	if err := callLimited(l, ctx, testPingMethod); err != nil {
+		t.Fatal(err)
+	}
+	if err := callLimited(l, ctx, testPingMethod); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the method rate must be limited but %v", err)
+	}
+	// the service token of the rejected request has been returned
+	if err := callLimited(l, ctx, testVerifyMethod); err != nil {
+		t.Fatalf("the service token must be returned after the method rejection but %v", err)
+	}
+	if err := callLimited(l, ctx, testVerifyMethod); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the service rate must be limited but %v", err)
+	}
+
+	// the concurrency limit and the change at runtime
+	l.RemoveLimit(testService)
+	l.SetLimit(testVerifyMethod, Limit{MaxConcurrency: 1})
+	release, err := l.acquire(ctx, testVerifyMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = callLimited(l, ctx, testVerifyMethod); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the concurrency must be limited but %v", err)
+	}
+	release()
+	if err = callLimited(l, ctx, testVerifyMethod); err != nil {
+		t.Fatalf("the request must be allowed after the release but %v", err)
+	}
+}
+
+// test the caller limits, the callers beyond the max share the overflow limit
+func TestLimiter_SetCallerLimit(t *testing.T) {
+
+	l := NewLimiter()
+	l.SetCallerLimit("X-Caller", Limit{Rate: 0.001, Burst: 1})
+	l.maxCallers = 3
+
+	for _, caller := range []string{"a", "b", "c"} {
+		if err := callLimited(l, callerContext(caller), testPingMethod); err != nil {
+			t.Fatalf("the first request of the caller:%s must be allowed but %v", caller, err)
+		}
+	}
+	if err := callLimited(l, callerContext("a"), testPingMethod); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the caller rate must be limited but %v", err)
+	}
+	// the anonymous caller is beyond the max and takes the token of the overflow caller
+	if err := callLimited(l, context.Background(), testPingMethod); err != nil {
+		t.Fatalf("the first caller beyond the max must be allowed but %v", err)
+	}
+	if err := callLimited(l, callerContext("e"), testPingMethod); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("the new callers beyond the max must share the overflow limit but %v", err)
+	}
+	if size := len(l.callers); size != 4 {
+		t.Fatalf("the callers must be bounded by the max plus the overflow but %d", size)
+	}
+
+	// the idle callers are evicted for the new callers
+	l.idleTimeout, l.evictedAt = 0, time.Time{}
+	if err := callLimited(l, callerContext("f"), testPingMethod); err != nil {
+		t.Fatalf("the new caller must be limited separately after the eviction but %v", err)
+	}
+	if _, ok := l.callers[testService+"/f"]; !ok || len(l.callers) != 1 {
+		t.Fatalf("the idle callers must be evicted but %d", len(l.callers))
+	}
+
+	// change the caller limit at runtime
+	l.SetCallerLimit("X-Caller", Limit{})
+	if err := callLimited(l, callerContext("f"), testPingMethod); err != nil {
+		t.Fatalf("the caller must not be limited after the limit removed but %v", err)
+	}
+}
@@ -3,7 +3,10 @@ package client
 import (
 	"context"
 	"fmt"
+	"github.com/busgo/elsa/pkg/client/balancer"
 	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/metrics"
+	"github.com/busgo/elsa/pkg/utils"
 	"google.golang.org/grpc/resolver"
 	"sync"
 	"time"
@@ -14,8 +17,17 @@ const (
 	ElsaScheme   = "elsa"
 )
 
-func BuildTarget(segment, serviceName string) string {
-	return fmt.Sprintf("%s:///%s", segment, serviceName)
+func BuildTarget(scheme, serviceName string) string {
+	return fmt.Sprintf("%s:///%s", scheme, serviceName)
+}
+
+// build the target of the service in the segment like elsa://dev/com.busgo.trade.proto.TradeService,
+// the empty segment means the segment of the registry stub
+func BuildSegmentTarget(scheme, segment, serviceName string) string {
+	if segment == "" {
+		return BuildTarget(scheme, serviceName)
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, segment, serviceName)
 }
 
 type DirectResolver struct {
@@ -65,7 +77,7 @@ func (r *DirectResolver) Close() {
 }
 
 type ElsaResolverBuilder struct {
-	resolvers    map[string]*ElsaResolver
+	resolvers    map[string]map[*ElsaResolver]bool // the resolvers of the client conns by segment/service
 	registryStub *RegistryStub
 	sync.RWMutex
 }
@@ -78,12 +90,13 @@ type ElsaResolver struct {
 	closedChan      chan bool
 	retryChan       chan bool
 	latestTimestamp int64
+	onClose         func()
 	sync.RWMutex
 }
 
 // new a elsa resolver
 func NewElsaResolverBuilder(stub *RegistryStub) *ElsaResolverBuilder {
-	return &ElsaResolverBuilder{resolvers: make(map[string]*ElsaResolver), RWMutex: sync.RWMutex{}, registryStub: stub}
+	return &ElsaResolverBuilder{resolvers: make(map[string]map[*ElsaResolver]bool), RWMutex: sync.RWMutex{}, registryStub: stub}
 }
 
 // Build creates a new resolver for the given target.
@@ -94,17 +107,40 @@ func (r *ElsaResolverBuilder) Build(target resolver.Target, cc resolver.ClientCo
 
 	r.Lock()
 	defer r.Unlock()
-	elsaResolver := r.resolvers[target.Endpoint]
-	if elsaResolver == nil {
-		elsaResolver = NewElsaResolver(target.Endpoint, cc, r.registryStub)
-	}
-	r.resolvers[target.Endpoint] = elsaResolver
+	// the authority of the target is the segment, every client conn has its own resolver
+	stub := r.registryStub.ForSegment(target.Authority)
+	elsaResolver := NewElsaResolver(target.Endpoint, cc, stub)
+	r.add(fmt.Sprintf("%s/%s", stub.GetSegment(), target.Endpoint), elsaResolver)
 	go elsaResolver.lookup()
 	// refresh
 	elsaResolver.refresh()
 	return elsaResolver, nil
 }
 
+// add the resolver of the key, the resolver is removed when it closed
+func (r *ElsaResolverBuilder) add(key string, elsaResolver *ElsaResolver) {
+
+	resolvers := r.resolvers[key]
+	if resolvers == nil {
+		resolvers = make(map[*ElsaResolver]bool)
+		r.resolvers[key] = resolvers
+	}
+	resolvers[elsaResolver] = true
+	elsaResolver.onClose = func() { r.remove(key, elsaResolver) }
+}
+
+// remove the closed resolver of the key
+func (r *ElsaResolverBuilder) remove(key string, elsaResolver *ElsaResolver) {
+
+	r.Lock()
+	defer r.Unlock()
+	resolvers := r.resolvers[key]
+	delete(resolvers, elsaResolver)
+	if len(resolvers) == 0 {
+		delete(r.resolvers, key)
+	}
+}
+
 // Scheme returns the scheme supported by this resolver.
 // Scheme is defined at https://github.com/grpc/grpc/blob/master/doc/naming.md.
 func (r *ElsaResolverBuilder) Scheme() string {
@@ -115,6 +151,7 @@ func (r *ElsaResolverBuilder) Scheme() string {
 func NewElsaResolver(serviceName string, cli resolver.ClientConn, registryStub *RegistryStub) *ElsaResolver {
 
 	return &ElsaResolver{
+		segment:      registryStub.GetSegment(),
 		serviceName:  serviceName,
 		cc:           cli,
 		registryStub: registryStub,
@@ -150,6 +187,9 @@ func (r *ElsaResolver) checkState() bool {
 func (r *ElsaResolver) Close() {
 
 	r.closedChan <- true
+	if r.onClose != nil {
+		r.onClose()
+	}
 	log.Infof("the elsa resolver has closed...")
 }
 
@@ -178,6 +218,7 @@ func (r *ElsaResolver) refresh() {
 	instances, err := r.registryStub.Fetch(ctx, r.serviceName)
 	if err != nil {
 		log.Warnf("fetch the service name:%s fail:%s", r.serviceName, err.Error())
+		metrics.ResolverRefreshFailures.WithLabelValues(r.segment, r.serviceName).Inc()
 		r.retryChan <- true
 		return
 	}
@@ -185,9 +226,9 @@ func (r *ElsaResolver) refresh() {
 	addresses := make([]resolver.Address, 0)
 
 	for _, instance := range instances {
-		addresses = append(addresses, resolver.Address{
-			Addr: fmt.Sprintf("%s:%d", instance.Ip, instance.Port),
-		})
+		addresses = append(addresses, balancer.WithStartTime(resolver.Address{
+			Addr: utils.JoinHostPort(instance.Ip, instance.Port),
+		}, instance.Metadata))
 	}
 
 	err = r.cc.UpdateState(resolver.State{
@@ -195,9 +236,12 @@ func (r *ElsaResolver) refresh() {
 	})
 	if err != nil {
 		log.Warnf("the elsa resolver segment:%s,serviceName:%s refresh addresses fail:%s", r.segment, r.serviceName, err.Error())
+		metrics.ResolverRefreshFailures.WithLabelValues(r.segment, r.serviceName).Inc()
 	} else {
 		log.Infof("the elsa resolver segment:%s,serviceName:%s refresh addresses success", r.segment, r.serviceName)
+		metrics.ResolverLastRefresh.WithLabelValues(r.segment, r.serviceName).SetToCurrentTime()
 	}
+	metrics.ResolverAddresses.WithLabelValues(r.segment, r.serviceName).Set(float64(len(addresses)))
 
 	// not found a service instance  must try again
 	if len(addresses) == 0 {
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestBuildSegmentTarget(t *testing.T) {
+
+	cases := map[string]string{
+		"":    "elsa:///com.busgo.trade.proto.TradeService",
+		"dev": "elsa://dev/com.busgo.trade.proto.TradeService",
+	}
+	for segment, target := range cases {
+		if got := BuildSegmentTarget(ElsaScheme, segment, "com.busgo.trade.proto.TradeService"); got != target {
+			t.Fatalf("the target of segment:%s must be %s but %s", segment, target, got)
+		}
+	}
+}
+
+func TestRegistryStub_ForSegment(t *testing.T) {
+
+	stub := &RegistryStub{segment: "prod", endpoints: []string{DefaultRegistryEndpoint}}
+	if stub.ForSegment("") != stub || stub.ForSegment("prod") != stub {
+		t.Fatal("the stub of the same segment must be itself")
+	}
+	dev := stub.ForSegment("dev")
+	if dev.GetSegment() != "dev" || stub.GetSegment() != "prod" {
+		t.Fatalf("the segment must be dev but %s", dev.GetSegment())
+	}
+}
+
+// the closed resolvers are removed from the builder
+func TestElsaResolverBuilder_Remove(t *testing.T) {
+
+	stub := &RegistryStub{segment: "dev", endpoints: []string{DefaultRegistryEndpoint}}
+	builder := NewElsaResolverBuilder(stub)
+	key := "dev/com.busgo.trade.proto.TradeService"
+	first := NewElsaResolver("com.busgo.trade.proto.TradeService", nil, stub)
+	second := NewElsaResolver("com.busgo.trade.proto.TradeService", nil, stub)
+	builder.add(key, first)
+	builder.add(key, second)
+	go first.lookup()
+	go second.lookup()
+
+	first.Close()
+	if resolvers := builder.resolvers[key]; len(resolvers) != 1 || !resolvers[second] {
+		t.Fatalf("only the closed resolver must be removed but %d", len(resolvers))
+	}
+	second.Close()
+	if _, ok := builder.resolvers[key]; ok {
+		t.Fatal("the key must be removed after all the resolvers closed")
+	}
+}
@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/client/balancer"
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DefaultRetryMaxAttempts       = 3
+	DefaultRetryInitialBackoff    = time.Millisecond * 100
+	DefaultRetryMaxBackoff        = time.Second
+	DefaultRetryBackoffMultiplier = 2.0
+	DefaultHedgingMaxAttempts     = 2
+)
+
+// the retry policy of the unary rpc calls
+type RetryPolicy struct {
+	MaxAttempts       int          // the max attempts including the first call
+	RetryableCodes    []codes.Code // the status codes can be retried, default is Unavailable
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	Budget            *RetryBudget // limit the retries when the most of the calls fail
+}
+
+// the hedging policy of the unary rpc calls,a hedged call must be idempotent
+type HedgingPolicy struct {
+	MaxAttempts   int           // the max attempts including the first call
+	Delay         time.Duration // send the next attempt when no response after the delay
+	NonFatalCodes []codes.Code  // send the next attempt immediately with these status codes
+	Budget        *RetryBudget
+}
+
+// the retry budget is a token bucket shared by all calls of a stub,each failed call
+// takes a token and each successful call returns TokenRatio token,the retries are
+// allowed only when more than half of the MaxTokens left
+type RetryBudget struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+type retryThrottler struct {
+	max    float64
+	ratio  float64
+	tokens float64
+	sync.Mutex
+}
+
+func newRetryThrottler(budget *RetryBudget) *retryThrottler {
+	if budget == nil || budget.MaxTokens <= 0 {
+		return nil
+	}
+	return &retryThrottler{
+		max:    budget.MaxTokens,
+		ratio:  budget.TokenRatio,
+		tokens: budget.MaxTokens,
+		Mutex:  sync.Mutex{},
+	}
+}
+
+// record the result of a attempt
+func (t *retryThrottler) record(success bool) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if success {
+		t.tokens = math.Min(t.tokens+t.ratio, t.max)
+		return
+	}
+	t.tokens = math.Max(t.tokens-1, 0)
+}
+
+// check the retry is allowed
+func (t *retryThrottler) allow() bool {
+	if t == nil {
+		return true
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.tokens > t.max/2
+}
+
+// the method name of the full method like /com.busgo.trade.proto.TradeService/Ping
+func methodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+func containsCode(codeList []codes.Code, code codes.Code) bool {
+	for _, c := range codeList {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+type retryInterceptor struct {
+	serviceName string
+	retries     map[string]*RetryPolicy
+	hedges      map[string]*HedgingPolicy
+	throttlers  map[string]*retryThrottler
+}
+
+// new a retry interceptor with the policies keyed by method name, the empty key is the service default
+func newRetryInterceptor(serviceName string, retries map[string]*RetryPolicy, hedges map[string]*HedgingPolicy) *retryInterceptor {
+
+	throttlers := make(map[string]*retryThrottler)
+	for method, policy := range retries {
+		throttlers[method] = newRetryThrottler(policy.Budget)
+	}
+	for method, policy := range hedges {
+		throttlers[method] = newRetryThrottler(policy.Budget)
+	}
+	return &retryInterceptor{
+		serviceName: serviceName,
+		retries:     retries,
+		hedges:      hedges,
+		throttlers:  throttlers,
+	}
+}
+
+// the policies of the method,a method hedging policy takes precedence over the retry policy
+func (r *retryInterceptor) policy(method string) (*RetryPolicy, *HedgingPolicy, *retryThrottler) {
+
+	if policy, ok := r.hedges[method]; ok {
+		return nil, policy, r.throttlers[method]
+	}
+	if policy, ok := r.retries[method]; ok {
+		return policy, nil, r.throttlers[method]
+	}
+	if policy, ok := r.hedges[""]; ok {
+		return nil, policy, r.throttlers[""]
+	}
+	if policy, ok := r.retries[""]; ok {
+		return policy, nil, r.throttlers[""]
+	}
+	return nil, nil, nil
+}
+
+// the unary client interceptor
+func (r *retryInterceptor) Unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	retry, hedge, throttler := r.policy(methodName(method))
+	if retry == nil && hedge == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx = balancer.WithPickTracker(ctx, balancer.NewPickTracker())
+	if hedge != nil {
+		return r.hedge(ctx, hedge, throttler, method, req, reply, cc, invoker, opts...)
+	}
+	return r.retry(ctx, retry, throttler, method, req, reply, cc, invoker, opts...)
+}
+
+// retry the call with backoff
+func (r *retryInterceptor) retry(ctx context.Context, policy *RetryPolicy, throttler *retryThrottler, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+	retryableCodes := policy.RetryableCodes
+	if len(retryableCodes) == 0 {
+		retryableCodes = []codes.Code{codes.Unavailable}
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		code := status.Code(err)
+		throttler.record(err == nil || !containsCode(retryableCodes, code))
+		if err == nil || !containsCode(retryableCodes, code) || attempt >= maxAttempts {
+			return err
+		}
+		if !throttler.allow() {
+			log.Warnf("the retry budget of serviceName:%s method:%s has exhausted", r.serviceName, method)
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		log.Debugf("retry serviceName:%s method:%s attempt:%d after %s,code:%s", r.serviceName, method, attempt+1, backoff, code)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// the backoff duration before the next attempt with full jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+
+	initial, max, multiplier := p.InitialBackoff, p.MaxBackoff, p.BackoffMultiplier
+	if initial <= 0 {
+		initial = DefaultRetryInitialBackoff
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = DefaultRetryBackoffMultiplier
+	}
+	backoff := math.Min(float64(initial)*math.Pow(multiplier, float64(attempt-1)), float64(max))
+	return time.Duration(rand.Float64() * backoff)
+}
+
+// the result of a hedged attempt
+type hedgeResult struct {
+	reply   interface{}
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+	err     error
+}
+
+// send the hedged attempts and take the first successful response
+func (r *retryInterceptor) hedge(ctx context.Context, policy *HedgingPolicy, throttler *retryThrottler, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	message, ok := reply.(proto.Message)
+	if !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultHedgingMaxAttempts
+	}
+
+	// the header,trailer and peer call options are applied to the winner attempt only
+	callOpts := make([]grpc.CallOption, 0, len(opts))
+	var headerAddr, trailerAddr *metadata.MD
+	var peerAddr *peer.Peer
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			headerAddr = o.HeaderAddr
+		case grpc.TrailerCallOption:
+			trailerAddr = o.TrailerAddr
+		case grpc.PeerCallOption:
+			peerAddr = o.PeerAddr
+		default:
+			callOpts = append(callOpts, opt)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *hedgeResult, maxAttempts)
+	send := func() {
+		result := &hedgeResult{reply: message.ProtoReflect().New().Interface()}
+		attemptOpts := make([]grpc.CallOption, 0, len(callOpts)+3)
+		attemptOpts = append(attemptOpts, callOpts...)
+		attemptOpts = append(attemptOpts, grpc.Header(&result.header), grpc.Trailer(&result.trailer), grpc.Peer(&result.peer))
+		go func() {
+			result.err = invoker(ctx, method, req, result.reply, cc, attemptOpts...)
+			results <- result
+		}()
+	}
+
+	send()
+	sent, received := 1, 0
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+	var result *hedgeResult
+	for received < sent {
+		select {
+		case <-timer.C:
+			if sent < maxAttempts && throttler.allow() {
+				log.Debugf("hedge serviceName:%s method:%s attempt:%d after %s", r.serviceName, method, sent+1, policy.Delay)
+				send()
+				sent++
+				timer.Reset(policy.Delay)
+			}
+			continue
+		case result = <-results:
+			received++
+		}
+
+		code := status.Code(result.err)
+		throttler.record(result.err == nil || !containsCode(policy.NonFatalCodes, code))
+		if result.err == nil || !containsCode(policy.NonFatalCodes, code) {
+			break
+		}
+		// a non fatal error, send the next attempt immediately
+		if sent < maxAttempts && throttler.allow() {
+			send()
+			sent++
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(policy.Delay)
+		}
+	}
+
+	if headerAddr != nil {
+		*headerAddr = result.header
+	}
+	if trailerAddr != nil {
+		*trailerAddr = result.trailer
+	}
+	if peerAddr != nil {
+		*peerAddr = result.peer
+	}
+	if result.err == nil {
+		proto.Reset(message)
+		proto.Merge(message, result.reply.(proto.Message))
+	}
+	return result.err
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/busgo/elsa/pkg/client/balancer"
+	"github.com/busgo/elsa/pkg/metrics"
+	"github.com/busgo/elsa/pkg/proto/pb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "/com.busgo.registry.proto.RegistryService/fetch"
+
+// test retry the unavailable calls
+func TestRetryInterceptor_Retry(t *testing.T) {
+
+	r := newRetryInterceptor("registry", map[string]*RetryPolicy{
+		"": {MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}, map[string]*HedgingPolicy{})
+
+	attempts := 0
+	err := r.Unary(context.Background(), testMethod, &pb.FetchRequest{}, &pb.FetchResponse{}, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			if balancer.PickTrackerFromContext(ctx) == nil {
+				t.Fatal("the pick tracker must be attached to the context")
+			}
+			return status.Error(codes.Unavailable, "unavailable")
+		})
+	if status.Code(err) != codes.Unavailable || attempts != 3 {
+		t.Fatalf("the attempts must be 3 but %d,error:%v", attempts, err)
+	}
+
+	attempts = 0
+	_ = r.Unary(context.Background(), testMethod, &pb.FetchRequest{}, &pb.FetchResponse{}, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			return status.Error(codes.InvalidArgument, "invalid argument")
+		})
+	if attempts != 1 {
+		t.Fatalf("the not retryable code must not retry but attempts %d", attempts)
+	}
+}
+
+// test the retry budget
+func TestRetryInterceptor_Budget(t *testing.T) {
+
+	r := newRetryInterceptor("registry", map[string]*RetryPolicy{
+		"fetch": {MaxAttempts: 5, InitialBackoff: time.Millisecond, Budget: &RetryBudget{MaxTokens: 4, TokenRatio: 0.1}},
+	}, map[string]*HedgingPolicy{})
+
+	attempts := 0
+	_ = r.Unary(context.Background(), testMethod, &pb.FetchRequest{}, &pb.FetchResponse{}, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts++
+			return status.Error(codes.Unavailable, "unavailable")
+		})
+	if attempts != 2 {
+		t.Fatalf("the budget must stop the retries after 2 attempts but %d", attempts)
+	}
+}
+
+// test the hedged call takes the fastest response
+func TestRetryInterceptor_Hedge(t *testing.T) {
+
+	r := newRetryInterceptor("registry", map[string]*RetryPolicy{}, map[string]*HedgingPolicy{
+		"fetch": {MaxAttempts: 2, Delay: time.Millisecond * 10},
+	})
+
+	attempts := make(chan int, 2)
+	reply := &pb.FetchResponse{}
+	err := r.Unary(context.Background(), testMethod, &pb.FetchRequest{}, reply, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			attempts <- 1
+			if len(attempts) == 1 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
+				reply.(*pb.FetchResponse).Message = "slow"
+				return nil
+			}
+			reply.(*pb.FetchResponse).Message = "fast"
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Message != "fast" {
+		t.Fatalf("the hedged call must take the fast response but %s", reply.Message)
+	}
+}
+
+// chain the client interceptors like grpc, the first is the outermost
+func chainUnaryClient(interceptors []grpc.UnaryClientInterceptor, invoker grpc.UnaryInvoker) grpc.UnaryInvoker {
+
+	if len(interceptors) == 0 {
+		return invoker
+	}
+	next := chainUnaryClient(interceptors[1:], invoker)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return interceptors[0](ctx, method, req, reply, cc, next, opts...)
+	}
+}
+
+// test each attempt of the retried call has its own timeout and metrics
+func TestElsaServer_StubInterceptors(t *testing.T) {
+
+	s := &ElsaServer{opts: ServerOptions{deadlineMargin: DefaultDeadlineMargin}, metricsServer: &http.Server{}}
+	unaryInts, _ := s.stubInterceptors("registry", StubOptions{
+		retries:  map[string]*RetryPolicy{"": {MaxAttempts: 3, InitialBackoff: time.Millisecond * 20}},
+		timeouts: map[string]time.Duration{"": time.Millisecond * 50},
+	})
+	counter := metrics.ClientRequests.WithLabelValues("com.busgo.registry.proto.RegistryService", "fetch", codes.Unavailable.String(), "")
+	before := testutil.ToFloat64(counter)
+
+	deadlines := make([]time.Time, 0)
+	invoker := chainUnaryClient(unaryInts, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("the attempt must have the default timeout")
+		}
+		deadlines = append(deadlines, deadline)
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	if err := invoker(context.Background(), testMethod, &pb.FetchRequest{}, &pb.FetchResponse{}, nil); status.Code(err) != codes.Unavailable {
+		t.Fatalf("the call must fail with unavailable but %v", err)
+	}
+	if len(deadlines) != 3 || !deadlines[2].After(deadlines[0]) {
+		t.Fatalf("the attempts must have their own deadlines but %v", deadlines)
+	}
+	if count := testutil.ToFloat64(counter) - before; count != 3 {
+		t.Fatalf("the metrics must count each attempt but %v", count)
+	}
+}
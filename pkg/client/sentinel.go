@@ -3,7 +3,6 @@ package client
 import (
 	"context"
 	"github.com/busgo/elsa/pkg/log"
-	"github.com/busgo/elsa/pkg/utils"
 	"sync"
 	"time"
 )
@@ -19,6 +18,7 @@ type ManagedSentinel struct {
 	sentinels    map[string]*Sentinel
 	ip           string
 	port         int32
+	closed       bool
 	sync.RWMutex
 }
 
@@ -26,33 +26,41 @@ type Sentinel struct {
 	serviceName    string
 	ip             string
 	port           int32
+	metadata       map[string]string
 	registryStub   *RegistryStub
 	registerChan   chan bool
 	retryRenewChan chan bool
 	closedChan     chan bool
+	closed         bool
 	sync.RWMutex
 }
 
-func NewManagedSentinel(serverPort int32, registryStub *RegistryStub) *ManagedSentinel {
+// new a managed sentinel registering the instances of the advertised ip and port
+func NewManagedSentinel(ip string, port int32, registryStub *RegistryStub) *ManagedSentinel {
 
 	return &ManagedSentinel{
 		registryStub: registryStub,
 		sentinels:    make(map[string]*Sentinel),
-		ip:           utils.GetLocalIp(),
-		port:         serverPort,
+		ip:           ip,
+		port:         port,
 		RWMutex:      sync.RWMutex{},
 	}
 }
 
-func (m *ManagedSentinel) PushService(serviceName string) {
+// register the service with the metadata and renew it
+func (m *ManagedSentinel) PushService(serviceName string, metadata map[string]string) {
 	m.Lock()
 	defer m.Unlock()
+	if m.closed {
+		log.Warnf("the managed sentinel has closed,ignore the serviceName:%s", serviceName)
+		return
+	}
 	sentinel := m.sentinels[serviceName]
 	if sentinel != nil {
 		return
 	}
 
-	sentinel = newSentinel(serviceName, m.ip, m.port, m.registryStub)
+	sentinel = newSentinel(serviceName, m.ip, m.port, metadata, m.registryStub)
 	m.sentinels[serviceName] = sentinel
 	sentinel.register()
 
@@ -60,21 +68,35 @@ func (m *ManagedSentinel) PushService(serviceName string) {
 	return
 }
 
+// cancel the instances of all the services in the registry and stop renewing them
 func (m *ManagedSentinel) Close() {
-	if len(m.sentinels) == 0 {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
 		return
 	}
+	m.closed = true
+	var wg sync.WaitGroup
 	for _, sentinel := range m.sentinels {
-		sentinel.cancel()
+		wg.Add(1)
+		go func(sentinel *Sentinel) {
+			defer wg.Done()
+			sentinel.cancel()
+		}(sentinel)
 	}
+	wg.Wait()
 }
 
 //  new sentinel
-func newSentinel(serviceName, ip string, port int32, registryStub *RegistryStub) *Sentinel {
+func newSentinel(serviceName, ip string, port int32, metadata map[string]string, registryStub *RegistryStub) *Sentinel {
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
 	return &Sentinel{
 		serviceName:    serviceName,
 		ip:             ip,
 		port:           port,
+		metadata:       metadata,
 		registryStub:   registryStub,
 		registerChan:   make(chan bool, 10),
 		retryRenewChan: make(chan bool, 10),
@@ -110,8 +132,11 @@ func (s *Sentinel) lookup() {
 func (s *Sentinel) register() {
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return
+	}
 	ctx, _ := context.WithTimeout(context.Background(), TimeoutDuration)
-	state, err := s.registryStub.Register(ctx, s.serviceName, s.ip, s.port)
+	state, err := s.registryStub.Register(ctx, s.serviceName, s.ip, s.port, s.metadata)
 	if err != nil || !state {
 		log.Warnf("register serviceName:%s,ip:%s,port:%d fail,after try again...", s.serviceName, s.ip, s.port)
 		s.registerChan <- true
@@ -124,6 +149,9 @@ func (s *Sentinel) register() {
 func (s *Sentinel) renew() {
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return
+	}
 	ctx, _ := context.WithTimeout(context.Background(), TimeoutDuration)
 	state, err := s.registryStub.Renew(ctx, s.serviceName, s.ip, s.port)
 	if err != nil {
@@ -140,14 +168,24 @@ func (s *Sentinel) renew() {
 	log.Infof("renew serviceName:%s,ip:%s,port:%d success", s.serviceName, s.ip, s.port)
 }
 
-// cancel
+// cancel the instance and stop the lookup, the retried register and renew are ignored after the cancel,
+// the lock is released before calling the registry
 func (s *Sentinel) cancel() {
-	ctx, _ := context.WithTimeout(context.Background(), TimeoutDuration)
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.closedChan)
+	s.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), TimeoutDuration)
+	defer cancel()
 	state, err := s.registryStub.Cancel(ctx, s.serviceName, s.ip, s.port)
 	if err != nil || !state {
 		log.Warnf("cancel serviceName:%s,ip:%s,port:%d fail", s.serviceName, s.ip, s.port)
 	} else {
 		log.Infof("cancel serviceName:%s,ip:%s,port:%d success", s.serviceName, s.ip, s.port)
 	}
-	s.closedChan <- true
 }
@@ -1,18 +1,35 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"expvar"
 	"fmt"
+	"github.com/busgo/elsa/pkg/client/balancer"
+	"github.com/busgo/elsa/pkg/limiter"
 	"github.com/busgo/elsa/pkg/log"
+	"github.com/busgo/elsa/pkg/metrics"
+	"github.com/busgo/elsa/pkg/security"
+	"github.com/busgo/elsa/pkg/tracing"
+	"github.com/busgo/elsa/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/resolver"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"syscall"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// the current adaptive concurrency limit of the elsa servers keyed by the server name,
+// exported at /debug/vars of the default http serve mux
+var adaptiveLimitVars = expvar.NewMap("elsa_adaptive_concurrency_limit")
+
 type ElsaServer struct {
 	managedSentinel *ManagedSentinel
 	resolverBuilder *ElsaResolverBuilder
@@ -20,18 +37,61 @@ type ElsaServer struct {
 	server          *grpc.Server
 	state           bool
 	signChan        chan os.Signal
+	tracingShutdown func(ctx context.Context) error
+	metricsServer   *http.Server
+	reloader        *security.Reloader
+	callerToken     *callerTokenInterceptor
+	serviceNames    []string
+	ctx             context.Context
+	cancel          context.CancelFunc
+	shutdownOnce    sync.Once
+	shutdownErr     error
+	doneChan        chan bool
 }
 
 type InitAction func(server *grpc.Server) (serverNames []string)
 type BuilderAction func(server *grpc.Server) (serverNames []string)
 
 type ServerOptions struct {
-	name         string
-	segment      string
-	serverPort   int32
-	registryStub *RegistryStub
+	name           string
+	segment        string
+	serverPort     int32
+	registryStub   *RegistryStub
+	balancer       balancer.Config
+	deadlineMargin time.Duration
+	limiter        *Limiter
+	adaptive       *limiter.AdaptiveLimiter
+	unaryInts      []grpc.UnaryServerInterceptor
+	streamInts     []grpc.StreamServerInterceptor
+	serverOpts     []grpc.ServerOption
+	dialOpts       []grpc.DialOption
+	tracing        *tracing.Config
+	metricsAddress string
+	tls            *security.TLSConfig
+	policy         *Policy
+	callerSecret   string
+	shutdown       ShutdownConfig
+	readiness      ReadinessCheck
+	readyInterval  time.Duration
+	bindAddress    string
+	advertiseHost  string
+	advertisePort  int32
+	advertiseIface string
+	advertiseCIDR  string
+	unixSockets    []string
+}
+
+// the graceful shutdown config
+type ShutdownConfig struct {
+	Delay          time.Duration // the delay after the instances canceled for the consumers to stop picking them
+	Timeout        time.Duration // the timeout to drain the in flight requests before the server stops forcibly
+	Signals        []os.Signal   // the signals to shutdown the server, default is the interrupt, SIGTERM, SIGUSR1 and SIGUSR2
+	DisableSignals bool          // disable the signal handler, the server is only shutdown by the Shutdown
 }
 
+// the readiness check of the server, the services are registered after it returns nil
+type ReadinessCheck func(ctx context.Context) error
+
 type ServerOption func(options *ServerOptions)
 
 func WithServerPort(serverPort int32) ServerOption {
@@ -46,25 +106,296 @@ func WithRegistryStub(stub *RegistryStub) ServerOption {
 	}
 }
 
+// register the services in the segment, the stubs call the services of the segment by default
+func WithSegment(segment string) ServerOption {
+	return func(options *ServerOptions) {
+		options.segment = segment
+	}
+}
+
 func WithName(name string) ServerOption {
 	return func(options *ServerOptions) {
 		options.name = name
 	}
 }
 
+// add the unary interceptors executed in order after the built-in tracing, metrics and authorization
+// and before the deadline check and the limiters
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(options *ServerOptions) {
+		options.unaryInts = append(options.unaryInts, interceptors...)
+	}
+}
+
+// add the stream interceptors executed in order after the built-in tracing, metrics and authorization
+// and before the deadline check and the limiters
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(options *ServerOptions) {
+		options.streamInts = append(options.streamInts, interceptors...)
+	}
+}
+
+// add the grpc server options
+func WithGrpcServerOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(options *ServerOptions) {
+		options.serverOpts = append(options.serverOpts, opts...)
+	}
+}
+
+// add the dial options of all the stubs built by the server
+func WithDialOptions(opts ...grpc.DialOption) ServerOption {
+	return func(options *ServerOptions) {
+		options.dialOpts = append(options.dialOpts, opts...)
+	}
+}
+
+// listen on the bind address like 127.0.0.1 or :: instead of all the addresses
+func WithBindAddress(address string) ServerOption {
+	return func(options *ServerOptions) {
+		options.bindAddress = address
+	}
+}
+
+// register the instances with the advertised host and port instead of the local ip and the server port,
+// the zero port means the server port
+func WithAdvertiseAddress(host string, port int32) ServerOption {
+	return func(options *ServerOptions) {
+		options.advertiseHost = host
+		options.advertisePort = port
+	}
+}
+
+// register the instances with the ip of the network interface like eth0
+func WithAdvertiseInterface(name string) ServerOption {
+	return func(options *ServerOptions) {
+		options.advertiseIface = name
+	}
+}
+
+// register the instances with the local ip in the cidr like 10.0.0.0/8
+func WithAdvertiseCIDR(cidr string) ServerOption {
+	return func(options *ServerOptions) {
+		options.advertiseCIDR = cidr
+	}
+}
+
+// listen on the unix socket as well for the sidecars, the stale socket file is removed
+func WithUnixSocket(path string) ServerOption {
+	return func(options *ServerOptions) {
+		options.unixSockets = append(options.unixSockets, path)
+	}
+}
+
+// trace the requests and the stub calls with the w3c trace context propagation
+func WithTracing(config tracing.Config) ServerOption {
+	return func(options *ServerOptions) {
+		options.tracing = &config
+	}
+}
+
+// serve the requests and call the stubs with the tls, the CA file enables the mutual tls,
+// the provider certificates are verified with the server name of the config or the service names
+// of the stubs, see WithServerName
+func WithTLS(config security.TLSConfig) ServerOption {
+	return func(options *ServerOptions) {
+		options.tls = &config
+	}
+}
+
+// authorize the callers of the requests with the policy like the one loaded by the LoadPolicy,
+// the caller is identified by the mutual tls certificate or the caller token
+func WithAuthorization(policy Policy) ServerOption {
+	return func(options *ServerOptions) {
+		options.policy = &policy
+	}
+}
+
+// the HMAC secret to sign the caller token of the stubs with the server name
+// and to verify the caller token of the requests
+func WithCallerSecret(secret string) ServerOption {
+	return func(options *ServerOptions) {
+		options.callerSecret = secret
+	}
+}
+
+// collect the request metrics and expose them on the /metrics http listener of the address like :9090
+func WithMetrics(address string) ServerOption {
+	return func(options *ServerOptions) {
+		options.metricsAddress = address
+	}
+}
+
+// the time reserved for the handlers to reply,the deadline of the downstream calls
+// made by a handler is shortened by the margin
+func WithDeadlineMargin(margin time.Duration) ServerOption {
+	return func(options *ServerOptions) {
+		options.deadlineMargin = margin
+	}
+}
+
+// limit the requests of a service name or a full method like /com.busgo.trade.proto.TradeService/Ping
+func WithLimit(name string, limit Limit) ServerOption {
+	return func(options *ServerOptions) {
+		options.limiter.SetLimit(name, limit)
+	}
+}
+
+// limit the requests of each caller identified by the metadata key, at most DefaultMaxCallers
+// callers per server are limited separately and the others share one overflow limit
+func WithCallerLimit(metadataKey string, limit Limit) ServerOption {
+	return func(options *ServerOptions) {
+		options.limiter.SetCallerLimit(metadataKey, limit)
+	}
+}
+
+// shed the requests over the concurrency limit adjusted by the latency gradient
+func WithAdaptiveLimit(config limiter.AdaptiveConfig) ServerOption {
+	return func(options *ServerOptions) {
+		options.adaptive = limiter.NewAdaptiveLimiter(config)
+	}
+}
+
+// the graceful shutdown of the server, the zero delay, timeout or signals means use the default value
+func WithShutdown(config ShutdownConfig) ServerOption {
+	return func(options *ServerOptions) {
+		if config.Delay > 0 {
+			options.shutdown.Delay = config.Delay
+		}
+		if config.Timeout > 0 {
+			options.shutdown.Timeout = config.Timeout
+		}
+		if len(config.Signals) > 0 {
+			options.shutdown.Signals = config.Signals
+		}
+		options.shutdown.DisableSignals = config.DisableSignals
+	}
+}
+
+// ramp the weight of the new instances linearly in the warm up window of the stubs
+func WithWarmUp(config balancer.WarmUpConfig) ServerOption {
+	return func(options *ServerOptions) {
+		options.balancer.WarmUp = &config
+	}
+}
+
+// delay the registration until the readiness check passes, the check is retried with the interval
+func WithReadiness(check ReadinessCheck, interval time.Duration) ServerOption {
+	return func(options *ServerOptions) {
+		options.readiness = check
+		if interval > 0 {
+			options.readyInterval = interval
+		}
+	}
+}
+
+// eject the misbehaving instances from the balancer of the stubs
+func WithOutlierDetection(config balancer.OutlierDetectionConfig) ServerOption {
+	return func(options *ServerOptions) {
+		options.balancer.OutlierDetection = &config
+	}
+}
+
+type StubOptions struct {
+	segment    string
+	retries    map[string]*RetryPolicy
+	hedges     map[string]*HedgingPolicy
+	timeouts   map[string]time.Duration
+	serverName string
+	dialOpts   []grpc.DialOption
+}
+
+type StubOption func(options *StubOptions)
+
+// verify the provider certificates of the stub with the server name instead of the service name
+func WithServerName(serverName string) StubOption {
+	return func(options *StubOptions) {
+		options.serverName = serverName
+	}
+}
+
+// call the service of another segment than the server's
+func WithTargetSegment(segment string) StubOption {
+	return func(options *StubOptions) {
+		options.segment = segment
+	}
+}
+
+// add the dial options of the stub
+func WithStubDialOptions(opts ...grpc.DialOption) StubOption {
+	return func(options *StubOptions) {
+		options.dialOpts = append(options.dialOpts, opts...)
+	}
+}
+
+// the default timeout of each attempt of the methods of the stub, without methods means all the methods of the service
+func WithDefaultTimeout(timeout time.Duration, methods ...string) StubOption {
+	return func(options *StubOptions) {
+		if len(methods) == 0 {
+			methods = []string{""}
+		}
+		for _, method := range methods {
+			options.timeouts[method] = timeout
+		}
+	}
+}
+
+// retry the methods of the stub with the policy, without methods means all the methods of the service
+func WithRetryPolicy(policy RetryPolicy, methods ...string) StubOption {
+	return func(options *StubOptions) {
+		if len(methods) == 0 {
+			methods = []string{""}
+		}
+		for _, method := range methods {
+			options.retries[method] = &policy
+		}
+	}
+}
+
+// hedge the methods of the stub with the policy, without methods means all the methods of the service
+func WithHedgingPolicy(policy HedgingPolicy, methods ...string) StubOption {
+	return func(options *StubOptions) {
+		if len(methods) == 0 {
+			methods = []string{""}
+		}
+		for _, method := range methods {
+			options.hedges[method] = &policy
+		}
+	}
+}
+
 // new elsa server
 func NewElsaServer(options ...ServerOption) (*ElsaServer, error) {
 
 	opts := ServerOptions{
-		name:         DefaultServerName,
-		segment:      DefaultSegment,
-		serverPort:   DefaultServerPort,
-		registryStub: nil,
+		name:           DefaultServerName,
+		serverPort:     DefaultServerPort,
+		registryStub:   nil,
+		deadlineMargin: DefaultDeadlineMargin,
+		limiter:        NewLimiter(),
+		readyInterval:  DefaultReadinessInterval,
+		shutdown: ShutdownConfig{
+			Delay:   DefaultShutdownDelay,
+			Timeout: DefaultShutdownTimeout,
+			Signals: DefaultShutdownSignals,
+		},
 	}
 	for _, opt := range options {
 		opt(&opts)
 	}
 
+	if err := opts.resolveAdvertiseAddress(); err != nil {
+		return nil, err
+	}
+	// the segment follows the registry stub without the WithSegment
+	if opts.segment == "" {
+		opts.segment = DefaultSegment
+		if opts.registryStub != nil {
+			opts.segment = opts.registryStub.GetSegment()
+		}
+	}
+	if opts.registryStub != nil {
+		opts.registryStub = opts.registryStub.ForSegment(opts.segment)
+	}
 	if opts.registryStub == nil {
 		stub, err := NewRegistryStub(opts.segment, []string{DefaultRegistryEndpoint})
 		if err != nil {
@@ -76,28 +407,216 @@ func NewElsaServer(options ...ServerOption) (*ElsaServer, error) {
 	resolverBuilder := NewElsaResolverBuilder(opts.registryStub)
 	resolver.Register(resolverBuilder)
 
+	// the interceptors in order: the tracing, the metrics, the authorization, the user interceptors,
+	// the deadline check and the limiters
+	unaryInterceptors := make([]grpc.UnaryServerInterceptor, 0)
+	streamInterceptors := make([]grpc.StreamServerInterceptor, 0)
+	tracingShutdown := func(ctx context.Context) error { return nil }
+	if opts.tracing != nil {
+		if opts.tracing.ServiceName == "" {
+			opts.tracing.ServiceName = opts.name
+		}
+		shutdown, err := tracing.Init(*opts.tracing)
+		if err != nil {
+			return nil, err
+		}
+		tracingShutdown = shutdown
+		attrs := opts.tracingAttributes()
+		unaryInterceptors = append(unaryInterceptors, tracing.UnaryServerInterceptor(attrs...))
+		streamInterceptors = append(streamInterceptors, tracing.StreamServerInterceptor(attrs...))
+	}
+	var metricsServer *http.Server
+	if opts.metricsAddress != "" {
+		metricsServer = metrics.NewServer(opts.metricsAddress)
+		unaryInterceptors = append(unaryInterceptors, metrics.UnaryServerInterceptor)
+		streamInterceptors = append(streamInterceptors, metrics.StreamServerInterceptor)
+	}
+	if opts.policy != nil {
+		authz := &authorizer{name: opts.name, policy: *opts.policy, secret: opts.callerSecret}
+		unaryInterceptors = append(unaryInterceptors, authz.Unary)
+		streamInterceptors = append(streamInterceptors, authz.Stream)
+	}
+	unaryInterceptors = append(unaryInterceptors, opts.unaryInts...)
+	unaryInterceptors = append(unaryInterceptors, deadlineUnaryServerInterceptor)
+	streamInterceptors = append(streamInterceptors, opts.streamInts...)
+	streamInterceptors = append(streamInterceptors, deadlineStreamServerInterceptor)
+	if opts.adaptive != nil {
+		interceptor := &adaptiveLimitInterceptor{limiter: opts.adaptive}
+		unaryInterceptors = append(unaryInterceptors, interceptor.Unary)
+		streamInterceptors = append(streamInterceptors, interceptor.Stream)
+		adaptiveLimitVars.Set(opts.name, expvar.Func(func() interface{} {
+			return opts.adaptive.Limit()
+		}))
+		err := metrics.RegisterGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   "elsa",
+			Subsystem:   "server",
+			Name:        "adaptive_concurrency_limit",
+			Help:        "The current adaptive concurrency limit of the elsa server.",
+			ConstLabels: prometheus.Labels{"server": opts.name},
+		}, func() float64 {
+			return float64(opts.adaptive.Limit())
+		})
+		if err != nil {
+			log.Warnf("register the adaptive limit gauge of the %s server fail:%s", opts.name, err.Error())
+		}
+	}
+	unaryInterceptors = append(unaryInterceptors, opts.limiter.UnaryServerInterceptor)
+	streamInterceptors = append(streamInterceptors, opts.limiter.StreamServerInterceptor)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	var reloader *security.Reloader
+	if opts.tls != nil {
+		if opts.tls.CertFile == "" {
+			return nil, fmt.Errorf("the %s server tls cert file is empty", opts.name)
+		}
+		r, err := security.NewReloader(*opts.tls)
+		if err != nil {
+			return nil, err
+		}
+		reloader = r
+		serverOpts = append(serverOpts, grpc.Creds(reloader.ServerCredentials()))
+	}
+	var callerToken *callerTokenInterceptor
+	if opts.callerSecret != "" {
+		signer, err := security.NewTokenSigner(opts.callerSecret, opts.name, "", DefaultCallerTokenTTL)
+		if err != nil {
+			return nil, err
+		}
+		callerToken = &callerTokenInterceptor{signer: signer}
+	}
+	serverOpts = append(serverOpts, opts.serverOpts...)
+	server := grpc.NewServer(serverOpts...)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ElsaServer{
-		managedSentinel: NewManagedSentinel(opts.serverPort, opts.registryStub),
+		managedSentinel: NewManagedSentinel(opts.advertiseHost, opts.advertisePort, opts.registryStub),
 		resolverBuilder: resolverBuilder,
-		server:          grpc.NewServer(),
+		server:          server,
 		opts:            opts,
 		state:           false,
-		signChan:        make(chan os.Signal),
+		signChan:        make(chan os.Signal, 1),
+		tracingShutdown: tracingShutdown,
+		metricsServer:   metricsServer,
+		reloader:        reloader,
+		callerToken:     callerToken,
+		ctx:             ctx,
+		cancel:          cancel,
+		doneChan:        make(chan bool),
 	}, nil
 }
 
-func (s *ElsaServer) BuildStub(serviceName string, callback func(cc *grpc.ClientConn) interface{}) interface{} {
-	cc, _ := grpc.Dial(BuildTarget(s.resolverBuilder.Scheme(), serviceName), grpc.WithInsecure(), grpc.WithBalancerName("round_robin"))
+// resolve the advertised host and port of the instances
+func (opts *ServerOptions) resolveAdvertiseAddress() error {
+
+	if opts.advertisePort == 0 {
+		opts.advertisePort = opts.serverPort
+	}
+	if opts.advertiseHost != "" {
+		return nil
+	}
+	var err error
+	switch {
+	case opts.advertiseIface != "":
+		opts.advertiseHost, err = utils.GetIpByInterface(opts.advertiseIface)
+	case opts.advertiseCIDR != "":
+		opts.advertiseHost, err = utils.GetIpByCIDR(opts.advertiseCIDR)
+	default:
+		opts.advertiseHost = utils.GetLocalIp()
+	}
+	if err != nil {
+		return fmt.Errorf("resolve the %s server advertise address fail:%w", opts.name, err)
+	}
+	return nil
+}
+
+// the span attributes of the server
+func (opts *ServerOptions) tracingAttributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		tracing.SegmentKey.String(opts.segment),
+		tracing.ServiceKey.String(opts.name),
+		tracing.InstanceKey.String(utils.JoinHostPort(opts.advertiseHost, opts.advertisePort)),
+	}
+}
+
+// the interceptors of the stub from the outermost to the innermost: the retry and the hedging,
+// the default timeout, the tracing, the caller token and the metrics, so each attempt of a call
+// has its own timeout, span and metrics and a deadline of the caller bounds all the attempts
+func (s *ElsaServer) stubInterceptors(serviceName string, opts StubOptions) ([]grpc.UnaryClientInterceptor, []grpc.StreamClientInterceptor) {
+
+	unaryInts := make([]grpc.UnaryClientInterceptor, 0)
+	streamInts := make([]grpc.StreamClientInterceptor, 0)
+	if len(opts.retries) > 0 || len(opts.hedges) > 0 {
+		unaryInts = append(unaryInts, newRetryInterceptor(serviceName, opts.retries, opts.hedges).Unary)
+	}
+	deadline := newDeadlineInterceptor(opts.timeouts, s.opts.deadlineMargin)
+	unaryInts = append(unaryInts, deadline.Unary)
+	streamInts = append(streamInts, deadline.Stream)
+	if s.opts.tracing != nil {
+		attrs := []attribute.KeyValue{tracing.SegmentKey.String(opts.segment), tracing.ServiceKey.String(serviceName)}
+		unaryInts = append(unaryInts, tracing.UnaryClientInterceptor(attrs...))
+		streamInts = append(streamInts, tracing.StreamClientInterceptor(attrs...))
+	}
+	if s.callerToken != nil {
+		unaryInts = append(unaryInts, s.callerToken.Unary)
+		streamInts = append(streamInts, s.callerToken.Stream)
+	}
+	if s.metricsServer != nil {
+		unaryInts = append(unaryInts, metrics.UnaryClientInterceptor)
+		streamInts = append(streamInts, metrics.StreamClientInterceptor)
+	}
+	return unaryInts, streamInts
+}
+
+func (s *ElsaServer) BuildStub(serviceName string, callback func(cc *grpc.ClientConn) interface{}, options ...StubOption) interface{} {
+	opts := StubOptions{
+		segment:  s.opts.segment,
+		retries:  make(map[string]*RetryPolicy),
+		hedges:   make(map[string]*HedgingPolicy),
+		timeouts: make(map[string]time.Duration),
+	}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	serviceConfig, err := balancer.BuildServiceConfig(s.opts.balancer)
+	if err != nil {
+		log.Errorf("build the service config of serviceName:%s fail:%s", serviceName, err.Error())
+	}
+	transport := grpc.WithInsecure()
+	if s.reloader != nil && opts.serverName != "" {
+		transport = grpc.WithTransportCredentials(s.reloader.ClientCredentialsFor(opts.serverName))
+	} else if s.reloader != nil {
+		transport = grpc.WithTransportCredentials(s.reloader.ClientCredentials())
+	}
+	unaryInts, streamInts := s.stubInterceptors(serviceName, opts)
+	dialOpts := []grpc.DialOption{
+		transport,
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithChainUnaryInterceptor(unaryInts...),
+		grpc.WithChainStreamInterceptor(streamInts...),
+	}
+	dialOpts = append(dialOpts, s.opts.dialOpts...)
+	dialOpts = append(dialOpts, opts.dialOpts...)
+	cc, _ := grpc.Dial(BuildSegmentTarget(s.resolverBuilder.Scheme(), opts.segment, serviceName), dialOpts...)
 	return callback(cc)
 }
 
+// the limiter of the server requests, the limits can be changed at runtime
+func (s *ElsaServer) Limiter() *Limiter {
+	return s.opts.limiter
+}
+
+// the adaptive limiter of the server requests, nil if the adaptive limit is disabled
+func (s *ElsaServer) AdaptiveLimiter() *limiter.AdaptiveLimiter {
+	return s.opts.adaptive
+}
+
 // init elsa server
 func (s *ElsaServer) Init(action InitAction) {
-	serviceNames := action(s.server)
+	s.serviceNames = action(s.server)
 	s.state = true
-	for _, serviceName := range serviceNames {
-		s.managedSentinel.PushService(serviceName)
-	}
 	reflection.Register(s.server)
 	log.Infof("the %s server initialize success", s.opts.name)
 }
@@ -109,29 +628,160 @@ func (s *ElsaServer) Start() error {
 		return errors.New(fmt.Sprintf("the %s server  has not initialize", s.opts.name))
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.opts.serverPort))
+	l, err := net.Listen("tcp", utils.JoinHostPort(s.opts.bindAddress, s.opts.serverPort))
 	if err != nil {
 		return err
 	}
+	listeners := make([]net.Listener, 0, len(s.opts.unixSockets))
+	for _, path := range s.opts.unixSockets {
+		unixListener, err := listenUnixSocket(path)
+		if err != nil {
+			for _, listener := range append(listeners, l) {
+				_ = listener.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, unixListener)
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			log.Infof("the %s server listen on the unix socket:%s", s.opts.name, listener.Addr().String())
+			if err := s.server.Serve(listener); err != nil {
+				log.Errorf("the %s server unix socket:%s serve fail:%s", s.opts.name, listener.Addr().String(), err.Error())
+			}
+		}(listener)
+	}
+
+	if s.metricsServer != nil {
+		go func() {
+			log.Infof("the %s server metrics listen on %s%s", s.opts.name, s.metricsServer.Addr, metrics.Path)
+			if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("the %s server metrics listener fail:%s", s.opts.name, err.Error())
+			}
+		}()
+	}
 
+	// register the services after the listener is ready
+	go s.register()
 	// lookup
 	go s.lookup()
 	log.Infof("the %s server has start...", s.opts.name)
 	if err = s.server.Serve(l); err != nil {
 		return err
 	}
+	// wait the shutdown to flush the traces and close the listeners
+	<-s.doneChan
 	return nil
 }
 
-func (s *ElsaServer) lookup() {
-	signal.Notify(s.signChan, os.Interrupt, os.Kill, syscall.SIGUSR1, syscall.SIGUSR2)
-	go func() {
+// listen on the unix socket, the stale socket file is removed
+func listenUnixSocket(path string) (net.Listener, error) {
 
-		select {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// wait the readiness and register the services with the start time for the warm up of the consumers
+func (s *ElsaServer) register() {
+
+	if s.opts.readiness != nil {
+		ticker := time.NewTicker(s.opts.readyInterval)
+		defer ticker.Stop()
+		for {
+			err := s.opts.readiness(s.ctx)
+			if err == nil {
+				break
+			}
+			log.Warnf("the %s server is not ready:%s", s.opts.name, err.Error())
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+		log.Infof("the %s server is ready", s.opts.name)
+	}
+
+	metadata := map[string]string{balancer.StartTimeKey: strconv.FormatInt(time.Now().UnixNano(), 10)}
+	for _, serviceName := range s.serviceNames {
+		s.managedSentinel.PushService(serviceName, metadata)
+	}
+}
 
-		case <-s.signChan:
-			s.managedSentinel.Close()
-			os.Exit(0)
+// wait the shutdown signals
+func (s *ElsaServer) lookup() {
+	if s.opts.shutdown.DisableSignals || len(s.opts.shutdown.Signals) == 0 {
+		return
+	}
+	signal.Notify(s.signChan, s.opts.shutdown.Signals...)
+	select {
+	case sig := <-s.signChan:
+		log.Infof("the %s server receive the signal:%s,shutdown...", s.opts.name, sig.String())
+		signal.Stop(s.signChan)
+		ctx, cancel := context.WithTimeout(context.Background(), s.opts.shutdown.Delay+s.opts.shutdown.Timeout+time.Second*5)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Warnf("shutdown the %s server fail:%s", s.opts.name, err.Error())
 		}
+	case <-s.doneChan:
+		signal.Stop(s.signChan)
+	}
+}
+
+// shutdown the server gracefully, cancel the instances in the registry, wait the delay for the consumers
+// to stop picking them, then drain the in flight requests until the timeout or the ctx done
+func (s *ElsaServer) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		defer close(s.doneChan)
+		s.shutdownErr = s.shutdown(ctx)
+	})
+	return s.shutdownErr
+}
+
+func (s *ElsaServer) shutdown(ctx context.Context) error {
+
+	s.cancel()
+	s.managedSentinel.Close()
+	log.Infof("the %s server has canceled the instances,wait %s for the consumers", s.opts.name, s.opts.shutdown.Delay)
+	select {
+	case <-time.After(s.opts.shutdown.Delay):
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
 	}()
+	timer := time.NewTimer(s.opts.shutdown.Timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-stopped:
+		log.Infof("the %s server has drained the requests", s.opts.name)
+	case <-timer.C:
+		err = fmt.Errorf("drain the requests of the %s server timeout", s.opts.name)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		log.Warnf("the %s server stop forcibly:%s", s.opts.name, err.Error())
+		s.server.Stop()
+	}
+
+	if s.metricsServer != nil {
+		if e := s.metricsServer.Shutdown(ctx); e != nil {
+			log.Warnf("shutdown the %s server metrics listener fail:%s", s.opts.name, e.Error())
+		}
+	}
+	if e := s.tracingShutdown(ctx); e != nil {
+		log.Warnf("shutdown the tracer provider fail:%s", e.Error())
+	}
+	if s.reloader != nil {
+		s.reloader.Close()
+	}
+	log.Infof("the %s server has shutdown", s.opts.name)
+	return err
 }
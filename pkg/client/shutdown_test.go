@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestElsaServer_Shutdown(t *testing.T) {
+
+	socket := filepath.Join(os.TempDir(), "elsa-shutdown.sock")
+	s, err := NewElsaServer(
+		WithName("shutdown"),
+		WithServerPort(18731),
+		WithBindAddress("127.0.0.1"),
+		WithUnixSocket(socket),
+		WithShutdown(ShutdownConfig{Delay: time.Millisecond * 10, Timeout: time.Second}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Init(func(server *grpc.Server) []string {
+		return nil
+	})
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- s.Start()
+	}()
+	time.Sleep(time.Millisecond * 100)
+	for _, target := range []string{"127.0.0.1:18731", "unix://" + socket} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		cc, err := grpc.DialContext(ctx, target, grpc.WithInsecure(), grpc.WithBlock())
+		cancel()
+		if err != nil {
+			t.Fatalf("dial the target:%s fail:%s", target, err.Error())
+		}
+		_ = cc.Close()
+	}
+
+	start := time.Now()
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*10 {
+		t.Fatalf("the shutdown must wait the delay but %s", elapsed)
+	}
+	select {
+	case err = <-errChan:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the start must return after the shutdown")
+	}
+	// shutdown again is a no-op
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the zero fields of the shutdown config keep the defaults, the signal handler is only disabled explicitly
+func TestWithShutdown(t *testing.T) {
+
+	defaults := ShutdownConfig{Delay: DefaultShutdownDelay, Timeout: DefaultShutdownTimeout, Signals: DefaultShutdownSignals}
+	cases := []struct {
+		config ShutdownConfig
+		want   ShutdownConfig
+	}{
+		{ShutdownConfig{Timeout: time.Second * 10}, ShutdownConfig{Delay: DefaultShutdownDelay, Timeout: time.Second * 10, Signals: DefaultShutdownSignals}},
+		{ShutdownConfig{Signals: []os.Signal{os.Interrupt}}, ShutdownConfig{Delay: DefaultShutdownDelay, Timeout: DefaultShutdownTimeout, Signals: []os.Signal{os.Interrupt}}},
+		{ShutdownConfig{DisableSignals: true}, ShutdownConfig{Delay: DefaultShutdownDelay, Timeout: DefaultShutdownTimeout, Signals: DefaultShutdownSignals, DisableSignals: true}},
+	}
+	for _, c := range cases {
+		opts := ServerOptions{shutdown: defaults}
+		WithShutdown(c.config)(&opts)
+		got := opts.shutdown
+		if got.Delay != c.want.Delay || got.Timeout != c.want.Timeout || len(got.Signals) != len(c.want.Signals) || got.DisableSignals != c.want.DisableSignals {
+			t.Fatalf("the shutdown config of %+v must be %+v but %+v", c.config, c.want, got)
+		}
+	}
+}
@@ -2,9 +2,16 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"github.com/busgo/elsa/pkg/log"
 	"github.com/busgo/elsa/pkg/proto/pb"
+	"github.com/busgo/elsa/pkg/security"
+	"github.com/busgo/elsa/pkg/tracing"
+	"github.com/busgo/elsa/pkg/utils"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/resolver"
 	"time"
 )
@@ -15,13 +22,54 @@ type RegistryStub struct {
 	cli       pb.RegistryServiceClient
 }
 
+type RegistryStubOptions struct {
+	tls   *security.TLSConfig
+	creds credentials.PerRPCCredentials
+}
+
+type RegistryStubOption func(options *RegistryStubOptions)
+
+// call the registry with the tls, the cert file enables the mutual tls
+func WithRegistryTLS(config security.TLSConfig) RegistryStubOption {
+	return func(options *RegistryStubOptions) {
+		options.tls = &config
+	}
+}
+
+// attach the credentials like the security.NewTokenCredentials to the registry requests
+func WithRegistryCredentials(creds credentials.PerRPCCredentials) RegistryStubOption {
+	return func(options *RegistryStubOptions) {
+		options.creds = creds
+	}
+}
+
 // new a registry stub
-func NewRegistryStub(segment string, endpoints []string) (*RegistryStub, error) {
+func NewRegistryStub(segment string, endpoints []string, options ...RegistryStubOption) (*RegistryStub, error) {
+
+	opts := RegistryStubOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	transport := grpc.WithInsecure()
+	if opts.tls != nil {
+		reloader, err := security.NewReloader(*opts.tls)
+		if err != nil {
+			return nil, err
+		}
+		transport = grpc.WithTransportCredentials(reloader.ClientCredentials())
+	}
+
+	dialOpts := []grpc.DialOption{transport, grpc.WithBalancerName("round_robin"),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor(tracing.SegmentKey.String(segment)))}
+	if opts.creds != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(opts.creds))
+	}
 
 	r := NewDirectResolverWithEndpoints(endpoints)
 	resolver.Register(r)
 	endpoint := BuildTarget(r.Scheme(), pb.RegistryService_ServiceDesc.ServiceName)
-	cc, err := grpc.Dial(endpoint, grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName("round_robin"))
+	cc, err := grpc.Dial(endpoint, append(dialOpts, grpc.WithResolvers(r))...)
 	if err != nil {
 		return nil, err
 	}
@@ -38,12 +86,49 @@ func (r *RegistryStub) GetSegment() string {
 	return r.segment
 }
 
+// the registry stub of another segment sharing the connection
+func (r *RegistryStub) ForSegment(segment string) *RegistryStub {
+	if segment == "" || segment == r.segment {
+		return r
+	}
+	return &RegistryStub{
+		segment:   segment,
+		endpoints: r.endpoints,
+		cli:       r.cli,
+	}
+}
+
+// start the span of a registry operation
+func (r *RegistryStub) startSpan(ctx context.Context, operation, serviceName string, ip string, port int32) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "registry."+operation, trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(
+		tracing.SegmentKey.String(r.segment),
+		tracing.ServiceKey.String(serviceName),
+		tracing.InstanceKey.String(utils.JoinHostPort(ip, port)),
+	))
+}
+
+// end the span of a registry operation
+func endSpan(span trace.Span, err error, code int32) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if code != 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("the registry response code:%d", code))
+	}
+	span.End()
+}
+
 // fetch service instance list
 func (r *RegistryStub) Fetch(cxt context.Context, serviceName string) ([]*pb.ServiceInstance, error) {
+	cxt, span := tracing.Tracer().Start(cxt, "registry.fetch", trace.WithAttributes(
+		tracing.SegmentKey.String(r.segment),
+		tracing.ServiceKey.String(serviceName),
+	))
 	response, err := r.cli.Fetch(cxt, &pb.FetchRequest{
 		Segment:     r.segment,
 		ServiceName: serviceName,
 	})
+	endSpan(span, err, response.GetCode())
 	if err != nil {
 		log.Errorf("fetch segment:%s,serviceName:%s fail:%s", r.segment, serviceName, err.Error())
 		return make([]*pb.ServiceInstance, 0), err
@@ -56,15 +141,16 @@ func (r *RegistryStub) Fetch(cxt context.Context, serviceName string) ([]*pb.Ser
 	return response.Instances, nil
 }
 
-// register a service instance
-func (r *RegistryStub) Register(ctx context.Context, serviceName, ip string, port int32) (bool, error) {
+// register a service instance with the metadata
+func (r *RegistryStub) Register(ctx context.Context, serviceName, ip string, port int32, metadata map[string]string) (bool, error) {
 
+	ctx, span := r.startSpan(ctx, "register", serviceName, ip, port)
 	response, err := r.cli.Register(ctx, &pb.RegisterRequest{
 		Segment:         r.segment,
 		ServiceName:     serviceName,
 		Ip:              ip,
 		Port:            port,
-		Metadata:        make(map[string]string),
+		Metadata:        metadata,
 		RegTimestamp:    time.Now().UnixNano(),
 		UpTimestamp:     time.Now().UnixNano(),
 		RenewTimestamp:  time.Now().UnixNano(),
@@ -72,6 +158,7 @@ func (r *RegistryStub) Register(ctx context.Context, serviceName, ip string, por
 		LatestTimestamp: time.Now().UnixNano(),
 		SyncType:        pb.SyncTypeEnum_Yes,
 	})
+	endSpan(span, err, response.GetCode())
 
 	if err != nil {
 		log.Errorf("register segment:%s,serviceName:%s,ip:%s,port:%32 fail:%s", r.segment, serviceName, ip, port, err.Error())
@@ -89,6 +176,7 @@ func (r *RegistryStub) Register(ctx context.Context, serviceName, ip string, por
 // renew a service instance
 func (r *RegistryStub) Renew(ctx context.Context, serviceName, ip string, port int32) (bool, error) {
 
+	ctx, span := r.startSpan(ctx, "renew", serviceName, ip, port)
 	response, err := r.cli.Renew(ctx, &pb.RenewRequest{
 		Segment:     r.segment,
 		ServiceName: serviceName,
@@ -96,6 +184,7 @@ func (r *RegistryStub) Renew(ctx context.Context, serviceName, ip string, port i
 		Port:        port,
 		SyncType:    pb.SyncTypeEnum_Yes,
 	})
+	endSpan(span, err, response.GetCode())
 
 	if err != nil {
 		log.Errorf("renew segment:%s,serviceName:%s,ip:%s,port:%d fail:%s", r.segment, serviceName, ip, port, err.Error())
@@ -111,6 +200,7 @@ func (r *RegistryStub) Renew(ctx context.Context, serviceName, ip string, port i
 
 // cancel a service instance
 func (r *RegistryStub) Cancel(ctx context.Context, serviceName, ip string, port int32) (bool, error) {
+	ctx, span := r.startSpan(ctx, "cancel", serviceName, ip, port)
 	response, err := r.cli.Cancel(ctx, &pb.CancelRequest{
 		Segment:     r.segment,
 		ServiceName: serviceName,
@@ -118,6 +208,7 @@ func (r *RegistryStub) Cancel(ctx context.Context, serviceName, ip string, port
 		Port:        port,
 		SyncType:    pb.SyncTypeEnum_Yes,
 	})
+	endSpan(span, err, response.GetCode())
 
 	if err != nil {
 		log.Errorf("cancel segment:%s,serviceName:%s,ip:%s,port:%s fail:%s", r.segment, serviceName, ip, port, err.Error())
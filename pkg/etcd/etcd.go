@@ -2,6 +2,8 @@ package etcd
 
 import (
 	"context"
+	"strconv"
+
 	"github.com/busgo/elsa/pkg/log"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	"go.etcd.io/etcd/client/v3"
@@ -76,6 +78,51 @@ func (cli *Cli) PutWithNotExist(ctx context.Context, key, value string) (bool, e
 	return txnResponse.Succeeded, err
 }
 
+// increase the counter of the key by delta, the new key expires after the ttl seconds
+func (cli *Cli) Incr(ctx context.Context, key string, delta int64, ttl int64) (int64, error) {
+
+	for {
+		getResponse, err := cli.kv.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if len(getResponse.Kvs) == 0 {
+			leaseResponse, err := cli.lease.Grant(ctx, ttl)
+			if err != nil {
+				return 0, err
+			}
+			txnResponse, err := cli.c.Txn(ctx).If(clientv3.Compare(clientv3.Version(key), "=", 0)).
+				Then(clientv3.OpPut(key, strconv.FormatInt(delta, 10), clientv3.WithLease(leaseResponse.ID))).
+				Commit()
+			if err != nil {
+				return 0, err
+			}
+			if txnResponse.Succeeded {
+				return delta, nil
+			}
+			// the key is created by others, revoke the unused lease and retry
+			_, _ = cli.lease.Revoke(ctx, leaseResponse.ID)
+			continue
+		}
+
+		kv := getResponse.Kvs[0]
+		current, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		next := current + delta
+		txnResponse, err := cli.c.Txn(ctx).If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(key, strconv.FormatInt(next, 10), clientv3.WithIgnoreLease())).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txnResponse.Succeeded {
+			return next, nil
+		}
+	}
+}
+
 // delete a key
 func (cli *Cli) Delete(ctx context.Context, key string) error {
 	_, err := cli.kv.Delete(ctx, key)
@@ -0,0 +1,190 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// the adaptive limiter config, the zero value of a field means use the default value
+type AdaptiveConfig struct {
+	InitialLimit          int           // the initial concurrency limit
+	MinLimit              int           // the min concurrency limit
+	MaxLimit              int           // the max concurrency limit
+	Smoothing             float64       // the smoothing factor of the limit changes in (0,1]
+	Tolerance             float64       // the tolerated latency ratio to the baseline before decreasing the limit
+	Window                time.Duration // the sample window
+	MinWindowSamples      int           // the min samples of a window to update the limit
+	BaselineResetInterval time.Duration // reset the no-load baseline latency periodically to follow the changes
+}
+
+const (
+	DefaultAdaptiveInitialLimit          = 20
+	DefaultAdaptiveMinLimit              = 1
+	DefaultAdaptiveMaxLimit              = 1000
+	DefaultAdaptiveSmoothing             = 0.2
+	DefaultAdaptiveTolerance             = 1.5
+	DefaultAdaptiveWindow                = time.Second
+	DefaultAdaptiveMinWindowSamples      = 10
+	DefaultAdaptiveBaselineResetInterval = time.Minute
+)
+
+// fill the zero value fields with the default value
+func (c AdaptiveConfig) withDefaults() AdaptiveConfig {
+
+	if c.MinLimit <= 0 {
+		c.MinLimit = DefaultAdaptiveMinLimit
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = DefaultAdaptiveMaxLimit
+	}
+	if c.MaxLimit < c.MinLimit {
+		c.MaxLimit = c.MinLimit
+	}
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = DefaultAdaptiveInitialLimit
+	}
+	c.InitialLimit = int(math.Max(float64(c.MinLimit), math.Min(float64(c.MaxLimit), float64(c.InitialLimit))))
+	if c.Smoothing <= 0 || c.Smoothing > 1 {
+		c.Smoothing = DefaultAdaptiveSmoothing
+	}
+	if c.Tolerance < 1 {
+		c.Tolerance = DefaultAdaptiveTolerance
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultAdaptiveWindow
+	}
+	if c.MinWindowSamples <= 0 {
+		c.MinWindowSamples = DefaultAdaptiveMinWindowSamples
+	}
+	if c.BaselineResetInterval <= 0 {
+		c.BaselineResetInterval = DefaultAdaptiveBaselineResetInterval
+	}
+	return c
+}
+
+// the adaptive limiter adjusts the concurrency limit with the latency gradient,
+// the limit decreases when the latency grows over the no-load baseline and
+// increases by the square root of the limit when the latency is stable
+type AdaptiveLimiter struct {
+	config        AdaptiveConfig
+	limit         float64
+	inflight      int
+	maxInflight   int
+	baseline      time.Duration
+	baselineReset time.Time
+	windowStart   time.Time
+	windowMin     time.Duration
+	windowSum     time.Duration
+	windowCount   int
+	now           func() time.Time
+	sync.Mutex
+}
+
+// new a adaptive limiter
+func NewAdaptiveLimiter(config AdaptiveConfig) *AdaptiveLimiter {
+
+	config = config.withDefaults()
+	now := time.Now()
+	return &AdaptiveLimiter{
+		config:        config,
+		limit:         float64(config.InitialLimit),
+		baselineReset: now,
+		windowStart:   now,
+		now:           time.Now,
+		Mutex:         sync.Mutex{},
+	}
+}
+
+// acquire a slot,the release func must be called with the result after the request done,
+// the dropped requests such as canceled ones should not be taken as samples
+func (a *AdaptiveLimiter) TryAcquire() (func(sample bool), bool) {
+
+	a.Lock()
+	defer a.Unlock()
+	if a.inflight >= int(a.limit) {
+		return nil, false
+	}
+	a.inflight++
+	if a.inflight > a.maxInflight {
+		a.maxInflight = a.inflight
+	}
+	start := a.now()
+	return func(sample bool) {
+		a.release(a.now().Sub(start), sample)
+	}, true
+}
+
+// the current concurrency limit
+func (a *AdaptiveLimiter) Limit() int {
+	a.Lock()
+	defer a.Unlock()
+	return int(a.limit)
+}
+
+// the in flight requests
+func (a *AdaptiveLimiter) Inflight() int {
+	a.Lock()
+	defer a.Unlock()
+	return a.inflight
+}
+
+// release the slot and take the latency as a sample
+func (a *AdaptiveLimiter) release(latency time.Duration, sample bool) {
+
+	a.Lock()
+	defer a.Unlock()
+	a.inflight--
+	if !sample {
+		return
+	}
+	if a.windowCount == 0 || latency < a.windowMin {
+		a.windowMin = latency
+	}
+	a.windowSum += latency
+	a.windowCount++
+
+	now := a.now()
+	if now.Sub(a.windowStart) < a.config.Window || a.windowCount < a.config.MinWindowSamples {
+		return
+	}
+	a.update(now)
+}
+
+// update the limit at the end of a sample window
+func (a *AdaptiveLimiter) update(now time.Time) {
+
+	if a.baseline == 0 || a.windowMin < a.baseline || now.Sub(a.baselineReset) >= a.config.BaselineResetInterval {
+		a.baseline = a.windowMin
+		a.baselineReset = now
+	}
+	average := a.windowSum / time.Duration(a.windowCount)
+
+	gradient := 1.0
+	if average > 0 {
+		gradient = math.Max(0.5, math.Min(1.0, a.config.Tolerance*float64(a.baseline)/float64(average)))
+	}
+	// the latency is stable but the limit is far from reached, there is no evidence to increase the limit
+	if gradient >= 1.0 && float64(a.maxInflight) < a.limit/2 {
+		a.resetWindow(now)
+		return
+	}
+
+	limit := a.limit*gradient + math.Sqrt(a.limit)
+	limit = a.limit*(1-a.config.Smoothing) + limit*a.config.Smoothing
+	// the latency is over the tolerance, never increase the limit
+	if gradient < 1.0 {
+		limit = math.Min(limit, a.limit)
+	}
+	a.limit = math.Max(float64(a.config.MinLimit), math.Min(float64(a.config.MaxLimit), limit))
+	a.resetWindow(now)
+}
+
+// start a new sample window
+func (a *AdaptiveLimiter) resetWindow(now time.Time) {
+
+	a.windowStart = now
+	a.windowSum = 0
+	a.windowCount = 0
+	a.maxInflight = a.inflight
+}
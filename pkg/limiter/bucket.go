@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// the token bucket refills rate tokens per second up to burst tokens
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// new a token bucket which is full
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		Mutex:  sync.Mutex{},
+	}
+}
+
+// refill the tokens until now
+func (b *TokenBucket) refill(now time.Time) {
+
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take a token
+func (b *TokenBucket) Allow() bool {
+	return b.AllowN(time.Now(), 1)
+}
+
+// take n tokens at now
+func (b *TokenBucket) AllowN(now time.Time, n int) bool {
+
+	b.Lock()
+	defer b.Unlock()
+	b.refill(now)
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// give back n tokens taken by a request which is rejected afterwards
+func (b *TokenBucket) Refund(n int) {
+
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+float64(n))
+}
+
+// the left tokens
+func (b *TokenBucket) Tokens() float64 {
+
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// change the rate and burst, the left tokens are kept
+func (b *TokenBucket) SetLimit(rate float64, burst int) {
+
+	b.Lock()
+	defer b.Unlock()
+	b.refill(time.Now())
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	b.rate = rate
+	b.burst = float64(burst)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
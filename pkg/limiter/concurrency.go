@@ -0,0 +1,48 @@
+package limiter
+
+import "sync/atomic"
+
+// the concurrency limiter limits the in flight requests
+type ConcurrencyLimiter struct {
+	max      int64
+	inflight int64
+}
+
+// new a concurrency limiter
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{max: int64(max)}
+}
+
+// acquire a slot, must release it after the request done
+func (c *ConcurrencyLimiter) TryAcquire() bool {
+
+	for {
+		inflight := atomic.LoadInt64(&c.inflight)
+		if inflight >= atomic.LoadInt64(&c.max) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&c.inflight, inflight, inflight+1) {
+			return true
+		}
+	}
+}
+
+// release the slot
+func (c *ConcurrencyLimiter) Release() {
+	atomic.AddInt64(&c.inflight, -1)
+}
+
+// the in flight requests
+func (c *ConcurrencyLimiter) Inflight() int {
+	return int(atomic.LoadInt64(&c.inflight))
+}
+
+// the max concurrency
+func (c *ConcurrencyLimiter) Max() int {
+	return int(atomic.LoadInt64(&c.max))
+}
+
+// change the max concurrency, the in flight requests are not affected
+func (c *ConcurrencyLimiter) SetMax(max int) {
+	atomic.StoreInt64(&c.max, int64(max))
+}
@@ -0,0 +1,97 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+// test the token bucket refill
+func TestTokenBucket_AllowN(t *testing.T) {
+
+	b := NewTokenBucket(10, 2)
+	now := time.Now()
+	if !b.AllowN(now, 1) || !b.AllowN(now, 1) {
+		t.Fatal("the burst tokens must be allowed")
+	}
+	if b.AllowN(now, 1) {
+		t.Fatal("the empty bucket must not be allowed")
+	}
+	if !b.AllowN(now.Add(time.Millisecond*100), 1) {
+		t.Fatal("the bucket must be refilled after 100ms")
+	}
+
+	b.SetLimit(1, 1)
+	if b.AllowN(now.Add(time.Millisecond*200), 1) {
+		t.Fatal("the bucket must be limited by the new rate")
+	}
+	b.Refund(2)
+	if !b.AllowN(now.Add(time.Millisecond*200), 1) || b.AllowN(now.Add(time.Millisecond*200), 1) {
+		t.Fatal("the refunded tokens must be limited by the burst")
+	}
+}
+
+// test the concurrency limiter
+func TestConcurrencyLimiter_TryAcquire(t *testing.T) {
+
+	c := NewConcurrencyLimiter(1)
+	if !c.TryAcquire() {
+		t.Fatal("the first request must be allowed")
+	}
+	if c.TryAcquire() {
+		t.Fatal("the second request must be limited")
+	}
+	c.SetMax(2)
+	if !c.TryAcquire() {
+		t.Fatal("the second request must be allowed after the max changed")
+	}
+	c.Release()
+	c.Release()
+	if c.Inflight() != 0 {
+		t.Fatalf("the in flight must be 0 but %d", c.Inflight())
+	}
+}
+
+// test the adaptive limit decreases with the latency growth
+func TestAdaptiveLimiter_Update(t *testing.T) {
+
+	now := time.Now()
+	a := NewAdaptiveLimiter(AdaptiveConfig{InitialLimit: 20, Window: time.Second, MinWindowSamples: 1})
+	a.now = func() time.Time { return now }
+	a.windowStart = now
+
+	releases := make([]func(sample bool), 0)
+	for i := 0; i < 20; i++ {
+		release, ok := a.TryAcquire()
+		if !ok {
+			t.Fatalf("the request:%d must be allowed", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := a.TryAcquire(); ok {
+		t.Fatal("the request over the limit must be shed")
+	}
+
+	// the no-load baseline with the limit reached
+	now = now.Add(time.Second)
+	a.release(time.Millisecond*10, true)
+	increased := a.limit
+	if increased <= 20 {
+		t.Fatalf("the limit must increase with the stable latency but %.2f", increased)
+	}
+
+	// the latency grows to 4 times of the baseline
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		a.release(time.Millisecond*40, true)
+	}
+	if a.limit >= increased {
+		t.Fatalf("the limit must decrease with the latency growth but %.2f", a.limit)
+	}
+
+	for _, release := range releases[6:] {
+		release(false)
+	}
+	if a.Inflight() != 0 {
+		t.Fatalf("the in flight must be 0 but %d", a.Inflight())
+	}
+}
@@ -129,3 +129,18 @@ func Panicf(template string, args ...interface{}) {
 func Fatalf(template string, args ...interface{}) {
 	s.Fatalf(template, args...)
 }
+
+// Infow logs a message with some additional context as the key value pairs.
+func Infow(msg string, keysAndValues ...interface{}) {
+	s.Infow(msg, keysAndValues...)
+}
+
+// Warnw logs a message with some additional context as the key value pairs.
+func Warnw(msg string, keysAndValues ...interface{}) {
+	s.Warnw(msg, keysAndValues...)
+}
+
+// Errorw logs a message with some additional context as the key value pairs.
+func Errorw(msg string, keysAndValues ...interface{}) {
+	s.Errorw(msg, keysAndValues...)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// split the full method like /com.busgo.trade.proto.TradeService/Ping to service and method
+func splitMethod(fullMethod string) (string, string) {
+
+	name := strings.TrimPrefix(fullMethod, "/")
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		return name[:index], name[index+1:]
+	}
+	return "unknown", name
+}
+
+// the host of the peer without the port, the address of the peer without a port like a unix socket
+func peerHost(ctx context.Context) string {
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+// observe the request handled by the server
+func observeServer(ctx context.Context, fullMethod string, start time.Time, err error) {
+
+	service, method := splitMethod(fullMethod)
+	host := peerHost(ctx)
+	ServerRequests.WithLabelValues(service, method, status.Code(err).String(), host).Inc()
+	ServerLatency.WithLabelValues(service, method, host).Observe(time.Since(start).Seconds())
+}
+
+// observe the call made by the client
+func observeClient(fullMethod, instance string, start time.Time, err error) {
+
+	service, method := splitMethod(fullMethod)
+	ClientRequests.WithLabelValues(service, method, status.Code(err).String(), instance).Inc()
+	ClientLatency.WithLabelValues(service, method, instance).Observe(time.Since(start).Seconds())
+}
+
+// count the unary requests and observe the latency
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	response, err := handler(ctx, req)
+	observeServer(ctx, info.FullMethod, start, err)
+	return response, err
+}
+
+// count the streams and observe the duration
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	start := time.Now()
+	err := handler(srv, ss)
+	observeServer(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// count the unary calls and observe the latency by the peer instance
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	start := time.Now()
+	p := new(peer.Peer)
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(p))...)
+	instance := ""
+	if p.Addr != nil {
+		instance = p.Addr.String()
+	}
+	observeClient(method, instance, start, err)
+	return err
+}
+
+// count the stream creations and observe the creation latency by the peer instance
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	instance := ""
+	if err == nil {
+		if p, ok := peer.FromContext(stream.Context()); ok && p.Addr != nil {
+			instance = p.Addr.String()
+		}
+	}
+	observeClient(method, instance, start, err)
+	return stream, err
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the metrics http path
+const Path = "/metrics"
+
+// the peer label of the server metrics is the host of the caller without the ephemeral port,
+// the instance label of the client metrics is the address of the called provider instance
+var (
+	ServerRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "elsa",
+		Subsystem: "server",
+		Name:      "requests_total",
+		Help:      "The total number of the requests handled by the elsa server.",
+	}, []string{"service", "method", "code", "peer"})
+
+	ServerLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "elsa",
+		Subsystem: "server",
+		Name:      "request_duration_seconds",
+		Help:      "The latency of the requests handled by the elsa server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "peer"})
+
+	ClientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "elsa",
+		Subsystem: "client",
+		Name:      "requests_total",
+		Help:      "The total number of the calls made by the elsa stubs.",
+	}, []string{"service", "method", "code", "instance"})
+
+	ClientLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "elsa",
+		Subsystem: "client",
+		Name:      "request_duration_seconds",
+		Help:      "The latency of the calls made by the elsa stubs.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "instance"})
+
+	ResolverAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "elsa",
+		Subsystem: "resolver",
+		Name:      "addresses",
+		Help:      "The number of the instance addresses resolved.",
+	}, []string{"segment", "service"})
+
+	ResolverRefreshFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "elsa",
+		Subsystem: "resolver",
+		Name:      "refresh_failures_total",
+		Help:      "The total number of the failed refreshes of the resolver.",
+	}, []string{"segment", "service"})
+
+	ResolverLastRefresh = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "elsa",
+		Subsystem: "resolver",
+		Name:      "last_refresh_timestamp_seconds",
+		Help:      "The unix timestamp of the latest successful refresh of the resolver.",
+	}, []string{"segment", "service"})
+)
+
+func init() {
+	prometheus.MustRegister(ServerRequests, ServerLatency, ClientRequests, ClientLatency,
+		ResolverAddresses, ResolverRefreshFailures, ResolverLastRefresh)
+}
+
+// register a gauge whose value is got from the func, the already registered gauge with the same
+// name and labels is replaced
+func RegisterGaugeFunc(opts prometheus.GaugeOpts, function func() float64) error {
+
+	gauge := prometheus.NewGaugeFunc(opts, function)
+	err := prometheus.Register(gauge)
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		prometheus.Unregister(are.ExistingCollector)
+		err = prometheus.Register(gauge)
+	}
+	return err
+}
+
+// new a http server exposing the metrics at /metrics
+func NewServer(address string) *http.Server {
+
+	mux := http.NewServeMux()
+	mux.Handle(Path, promhttp.Handler())
+	return &http.Server{Addr: address, Handler: mux}
+}
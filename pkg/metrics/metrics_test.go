@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// test count the requests by the status code
+func TestUnaryServerInterceptor(t *testing.T) {
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/com.busgo.trade.proto.TradeService/Ping"}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 52100}})
+	_, _ = UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	_, _ = UnaryServerInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+
+	if count := testutil.ToFloat64(ServerRequests.WithLabelValues("com.busgo.trade.proto.TradeService", "Ping", "OK", "10.0.0.1")); count != 1 {
+		t.Fatalf("the OK requests of the peer must be 1 but %v", count)
+	}
+	if count := testutil.ToFloat64(ServerRequests.WithLabelValues("com.busgo.trade.proto.TradeService", "Ping", "NotFound", "10.0.0.1")); count != 1 {
+		t.Fatalf("the NotFound requests of the peer must be 1 but %v", count)
+	}
+}
+
+// test the gauge registered again is replaced
+func TestRegisterGaugeFunc(t *testing.T) {
+
+	opts := prometheus.GaugeOpts{Namespace: "elsa", Name: "test_gauge", Help: "The test gauge.", ConstLabels: prometheus.Labels{"server": "trade"}}
+	for _, value := range []float64{1, 2} {
+		value := value
+		if err := RegisterGaugeFunc(opts, func() float64 { return value }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "elsa_test_gauge"); err != nil || count != 1 {
+		t.Fatalf("the gauge must be registered once but %d %v", count, err)
+	}
+	expected := "# HELP elsa_test_gauge The test gauge.\n# TYPE elsa_test_gauge gauge\nelsa_test_gauge{server=\"trade\"} 2\n"
+	if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "elsa_test_gauge"); err != nil {
+		t.Fatalf("the gauge must be replaced:%v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// the peer address of the context
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// log the request
+func logRequest(kind, method, address, requestId string, start time.Time, err error) {
+
+	code := status.Code(err)
+	latency := time.Since(start)
+	switch code {
+	case codes.OK:
+		log.Infof("%s method:%s,peer:%s,request id:%s,code:%s,latency:%s", kind, method, address, requestId, code, latency)
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		log.Errorf("%s method:%s,peer:%s,request id:%s,code:%s,latency:%s,error:%s", kind, method, address, requestId, code, latency, err.Error())
+	default:
+		log.Warnf("%s method:%s,peer:%s,request id:%s,code:%s,latency:%s,error:%s", kind, method, address, requestId, code, latency, err.Error())
+	}
+}
+
+// log the unary request
+func LoggingUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	response, err := handler(ctx, req)
+	logRequest("handle unary", info.FullMethod, peerAddress(ctx), RequestIdFromContext(ctx), start, err)
+	return response, err
+}
+
+// log the stream
+func LoggingStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	start := time.Now()
+	err := handler(srv, ss)
+	logRequest("handle stream", info.FullMethod, peerAddress(ss.Context()), RequestIdFromContext(ss.Context()), start, err)
+	return err
+}
+
+// log the unary call
+func LoggingUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	start := time.Now()
+	p := new(peer.Peer)
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(p))...)
+	address := ""
+	if p.Addr != nil {
+		address = p.Addr.String()
+	}
+	logRequest("call unary", method, address, RequestIdFromContext(ctx), start, err)
+	return err
+}
+
+// log the stream call creation
+func LoggingStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	logRequest("call stream", method, cc.Target(), RequestIdFromContext(ctx), start, err)
+	return stream, err
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/com.busgo.trade.proto.TradeService/Ping"}
+
+// test the panic recovered as a Internal error
+func TestRecoveryUnaryServerInterceptor(t *testing.T) {
+
+	_, err := RecoveryUnaryServerInterceptor(context.Background(), nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("the panic must be recovered as Internal but %v", err)
+	}
+}
+
+// test the request id propagated from the incoming to the outgoing metadata
+func TestRequestIdPropagation(t *testing.T) {
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIdKey, "req-1"))
+	_, err := RequestIdUnaryServerInterceptor(ctx, nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if RequestIdFromContext(ctx) != "req-1" {
+			t.Fatalf("the request id must be req-1 but %s", RequestIdFromContext(ctx))
+		}
+		return nil, RequestIdUnaryClientInterceptor(ctx, testInfo.FullMethod, nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				if values := md.Get(RequestIdKey); len(values) != 1 || values[0] != "req-1" {
+					t.Fatalf("the outgoing request id must be req-1 but %v", values)
+				}
+				return nil
+			})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
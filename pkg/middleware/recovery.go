@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recover the panic of the unary handler as a Internal error
+func RecoveryUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response interface{}, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("the method:%s request id:%s panic:%v,stack:%s", info.FullMethod, RequestIdFromContext(ctx), r, debug.Stack())
+			err = status.Errorf(codes.Internal, "the method:%s panic:%v", info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// recover the panic of the stream handler as a Internal error
+func RecoveryStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("the method:%s request id:%s panic:%v,stack:%s", info.FullMethod, RequestIdFromContext(ss.Context()), r, debug.Stack())
+			err = status.Errorf(codes.Internal, "the method:%s panic:%v", info.FullMethod, r)
+		}
+	}()
+	return handler(srv, ss)
+}
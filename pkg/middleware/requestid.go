@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// the metadata key of the request id
+const RequestIdKey = "x-request-id"
+
+type requestIdKey struct{}
+
+// new a random request id
+func NewRequestId() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// attach the request id to the context
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// get the request id from the context
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// accept the request id from the incoming metadata or create a new one
+func acceptRequestId(ctx context.Context) context.Context {
+
+	requestId := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(RequestIdKey); len(values) > 0 {
+			requestId = values[0]
+		}
+	}
+	if requestId == "" {
+		requestId = NewRequestId()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIdKey, requestId))
+	return WithRequestId(ctx, requestId)
+}
+
+// propagate the request id of the context to the outgoing metadata
+func propagateRequestId(ctx context.Context) context.Context {
+
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIdKey)) > 0 {
+		return ctx
+	}
+	requestId := RequestIdFromContext(ctx)
+	if requestId == "" {
+		requestId = NewRequestId()
+		ctx = WithRequestId(ctx, requestId)
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIdKey, requestId)
+}
+
+// accept the request id of the unary request and reply it in the header
+func RequestIdUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(acceptRequestId(ctx), req)
+}
+
+// accept the request id of the stream and reply it in the header
+func RequestIdStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, WrapServerStream(ss, acceptRequestId(ss.Context())))
+}
+
+// propagate the request id to the unary call
+func RequestIdUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(propagateRequestId(ctx), method, req, reply, cc, opts...)
+}
+
+// propagate the request id to the stream call
+func RequestIdStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(propagateRequestId(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,248 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/busgo/elsa/pkg/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// the default interval to check the certificate files changed
+const DefaultReloadInterval = time.Second * 30
+
+// the tls config, the CA file enables the mutual tls
+type TLSConfig struct {
+	CertFile       string        // the certificate file, required by the server and the mutual tls client
+	KeyFile        string        // the private key file of the certificate
+	CAFile         string        // the CA file to verify the peer certificate
+	ServerName     string        // the server name to verify the server certificate, see ClientCredentials
+	ReloadInterval time.Duration // the interval to check the files changed
+}
+
+// the tls credentials reloaded from disk when the files changed
+type Reloader struct {
+	config      TLSConfig
+	certificate *tls.Certificate
+	pool        *x509.CertPool
+	modTimes    map[string]time.Time
+	closedChan  chan bool
+	sync.RWMutex
+}
+
+// new a reloader and load the files
+func NewReloader(config TLSConfig) (*Reloader, error) {
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("the tls cert file and key file must be set together")
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = DefaultReloadInterval
+	}
+	r := &Reloader{
+		config:     config,
+		modTimes:   make(map[string]time.Time),
+		closedChan: make(chan bool),
+		RWMutex:    sync.RWMutex{},
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	go r.lookup()
+	return r, nil
+}
+
+// the files of the config
+func (r *Reloader) files() []string {
+
+	files := make([]string, 0)
+	for _, file := range []string{r.config.CertFile, r.config.KeyFile, r.config.CAFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// load the certificate and the CA pool
+func (r *Reloader) load() error {
+
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	var certificate *tls.Certificate
+	if r.config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return err
+		}
+		certificate = &cert
+	}
+
+	var pool *x509.CertPool
+	if r.config.CAFile != "" {
+		content, err := ioutil.ReadFile(r.config.CAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return fmt.Errorf("the CA file:%s has no certificate", r.config.CAFile)
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.certificate = certificate
+	r.pool = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// check the files changed
+func (r *Reloader) changed() bool {
+
+	r.RLock()
+	defer r.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) lookup() {
+
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			// keep the old certificate when the new one is broken, e.g. the files are being written
+			if err := r.load(); err != nil {
+				log.Warnf("reload the tls files:%v fail:%s", r.files(), err.Error())
+				continue
+			}
+			log.Infof("reload the tls files:%v success", r.files())
+		case <-r.closedChan:
+			return
+		}
+	}
+}
+
+// stop watching the files
+func (r *Reloader) Close() {
+	close(r.closedChan)
+}
+
+// the current certificate
+func (r *Reloader) getCertificate() (*tls.Certificate, error) {
+
+	r.RLock()
+	defer r.RUnlock()
+	if r.certificate == nil {
+		return nil, errors.New("the tls certificate has not been configured")
+	}
+	return r.certificate, nil
+}
+
+// the server tls config with the current certificate, the client certificate is required with the CA
+func (r *Reloader) serverConfig() *tls.Config {
+
+	r.RLock()
+	defer r.RUnlock()
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.certificate != nil {
+		config.Certificates = []tls.Certificate{*r.certificate}
+	}
+	if r.pool != nil {
+		config.ClientCAs = r.pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// the server transport credentials
+func (r *Reloader) ServerCredentials() credentials.TransportCredentials {
+
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.serverConfig(), nil
+		},
+	})
+}
+
+// verify the server certificate with the current CA pool and the server name,
+// the server name of the handshake is used if it is empty
+func (r *Reloader) verifyConnection(state tls.ConnectionState, serverName string) error {
+
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the server has no certificate")
+	}
+	r.RLock()
+	pool := r.pool
+	r.RUnlock()
+
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	// the system roots are used without the CA file
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// the client transport credentials verifying the server name of the config, the client certificate is sent if configured.
+// without the server name the certificate is verified with the dial authority, which is the service name like
+// com.busgo.trade.proto.TradeService for the elsa://segment/service targets of the stubs, so the provider
+// certificates must carry the service names as the DNS SANs
+func (r *Reloader) ClientCredentials() credentials.TransportCredentials {
+	return r.ClientCredentialsFor(r.config.ServerName)
+}
+
+// the client transport credentials verifying the server certificate with the server name
+func (r *Reloader) ClientCredentialsFor(serverName string) credentials.TransportCredentials {
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: true, // verified by the VerifyConnection with the reloaded CA pool
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return r.verifyConnection(state, serverName)
+		},
+	}
+	if r.config.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.getCertificate()
+		}
+	}
+	return credentials.NewTLS(config)
+}
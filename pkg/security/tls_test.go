@@ -0,0 +1,194 @@
+package security
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// create a certificate signed by the parent, a self signed CA without the parent
+func createCertificate(t *testing.T, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+	content, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyContent, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: content}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyContent})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake with the server and the client credentials of the reloaders
+func handshake(server, client *Reloader) (*x509.Certificate, error) {
+	return handshakeWith(server, client.ClientCredentials(), "registry:8005")
+}
+
+func handshakeWith(server *Reloader, client credentials.TransportCredentials, authority string) (*x509.Certificate, error) {
+
+	// the buffered loopback connection lets the failed client send the alert to the writing server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+		_, _, _ = server.ServerCredentials().ServerHandshake(serverConn)
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer clientConn.Close()
+	_, authInfo, err := client.ClientHandshake(context.Background(), authority, clientConn)
+	if err != nil {
+		return nil, err
+	}
+	return authInfo.(credentials.TLSInfo).State.PeerCertificates[0], nil
+}
+
+// test the mutual tls handshake and the certificate rotation
+func TestReloader_Rotation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "elsa-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey, caPem, _ := createCertificate(t, "elsa-ca", 1, nil, nil)
+	_, _, serverPem, serverKeyPem := createCertificate(t, "registry", 2, ca, caKey)
+	_, _, clientPem, clientKeyPem := createCertificate(t, "consumer", 3, ca, caKey)
+	for name, content := range map[string][]byte{
+		"ca.pem": caPem, "server.pem": serverPem, "server.key": serverKeyPem, "client.pem": clientPem, "client.key": clientKeyPem,
+	} {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+
+	server, err := NewReloader(TLSConfig{
+		CertFile:       filepath.Join(dir, "server.pem"),
+		KeyFile:        filepath.Join(dir, "server.key"),
+		CAFile:         filepath.Join(dir, "ca.pem"),
+		ReloadInterval: time.Millisecond * 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := NewReloader(TLSConfig{
+		CertFile:   filepath.Join(dir, "client.pem"),
+		KeyFile:    filepath.Join(dir, "client.key"),
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		ServerName: "registry",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cert, err := handshake(server, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 2 {
+		t.Fatalf("the server certificate serial must be 2 but %d", cert.SerialNumber.Int64())
+	}
+
+	// rotate the server certificate
+	_, _, rotatedPem, rotatedKeyPem := createCertificate(t, "registry", 4, ca, caKey)
+	writeFile(t, filepath.Join(dir, "server.pem"), rotatedPem)
+	writeFile(t, filepath.Join(dir, "server.key"), rotatedKeyPem)
+	future := time.Now().Add(time.Second)
+	for _, name := range []string{"server.pem", "server.key"} {
+		_ = os.Chtimes(filepath.Join(dir, name), future, future)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	cert, err = handshake(server, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.SerialNumber.Int64() != 4 {
+		t.Fatalf("the rotated server certificate serial must be 4 but %d", cert.SerialNumber.Int64())
+	}
+}
+
+// test the server certificate is verified with the authority or the explicit server name
+func TestReloader_ServerName(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "elsa-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey, caPem, _ := createCertificate(t, "elsa-ca", 1, nil, nil)
+	_, _, serverPem, serverKeyPem := createCertificate(t, "trade-provider", 2, ca, caKey)
+	for name, content := range map[string][]byte{"ca.pem": caPem, "server.pem": serverPem, "server.key": serverKeyPem} {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+	server, err := NewReloader(TLSConfig{CertFile: filepath.Join(dir, "server.pem"), KeyFile: filepath.Join(dir, "server.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := NewReloader(TLSConfig{CAFile: filepath.Join(dir, "ca.pem")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the authority of the stubs is the service name
+	if _, err = handshakeWith(server, client.ClientCredentials(), "com.busgo.trade.proto.TradeService"); err == nil {
+		t.Fatal("the certificate without the service name must be rejected")
+	}
+	if _, err = handshakeWith(server, client.ClientCredentialsFor("trade-provider"), "com.busgo.trade.proto.TradeService"); err != nil {
+		t.Fatalf("the certificate must be verified with the server name but %v", err)
+	}
+}
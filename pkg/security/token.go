@@ -0,0 +1,260 @@
+package security
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	AuthorizationKey = "authorization" // the metadata key of the token
+	BearerPrefix     = "Bearer "
+	AnySegment       = "*" // the token config of every segment
+)
+
+var (
+	ErrTokenMissing = errors.New("the token is missing")
+	ErrTokenInvalid = errors.New("the token is invalid")
+	ErrTokenExpired = errors.New("the token has expired")
+)
+
+// the token config of a segment, the static tokens and the HMAC signed JWTs are both accepted
+type TokenConfig struct {
+	Tokens map[string]string `json:"tokens,omitempty"` // the static token to the identity
+	Secret string            `json:"secret,omitempty"` // the HMAC secret of the JWTs
+}
+
+// the claims of the JWT
+type Claims struct {
+	Subject   string `json:"sub"`
+	Segment   string `json:"seg,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// sign a HS256 JWT of the subject,the token never expires with the zero ttl
+func SignToken(secret, subject, segment string, ttl time.Duration) (string, error) {
+
+	if secret == "" {
+		return "", errors.New("the token secret is empty")
+	}
+	now := time.Now()
+	claims := Claims{Subject: subject, Segment: segment, IssuedAt: now.Unix()}
+	if ttl > 0 {
+		claims.ExpiresAt = now.Add(ttl).Unix()
+	}
+	header, err := json.Marshal(jwtHeader{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	content := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return content + "." + signature(secret, content), nil
+}
+
+// the HS256 signature of the content
+func signature(secret, content string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(content))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parse and verify a HS256 JWT
+func ParseToken(secret, token string) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	content, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	header := jwtHeader{}
+	if err = json.Unmarshal(content, &header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrTokenInvalid
+	}
+	if !hmac.Equal([]byte(signature(secret, parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return nil, ErrTokenInvalid
+	}
+	if content, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(content, claims); err != nil || claims.Subject == "" {
+		return nil, ErrTokenInvalid
+	}
+	if claims.ExpiresAt > 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// the token verifier of the segments
+type TokenVerifier struct {
+	configs map[string]TokenConfig
+}
+
+// new a token verifier with the token config of each segment, the AnySegment config applies to every segment
+func NewTokenVerifier(configs map[string]TokenConfig) *TokenVerifier {
+	return &TokenVerifier{configs: configs}
+}
+
+// verify the token of the segment and return the identity
+func (v *TokenVerifier) Verify(segment, token string) (string, error) {
+
+	if token == "" {
+		return "", ErrTokenMissing
+	}
+	err := ErrTokenInvalid
+	for _, name := range []string{segment, AnySegment} {
+		config, ok := v.configs[name]
+		if !ok {
+			continue
+		}
+		for t, identity := range config.Tokens {
+			if hmac.Equal([]byte(t), []byte(token)) {
+				return identity, nil
+			}
+		}
+		if config.Secret == "" {
+			continue
+		}
+		claims, e := ParseToken(config.Secret, token)
+		if e != nil {
+			err = e
+			continue
+		}
+		// the token signed for another segment
+		if claims.Segment != "" && claims.Segment != segment {
+			continue
+		}
+		return claims.Subject, nil
+	}
+	return "", err
+}
+
+// the bearer token of the incoming context
+func TokenFromContext(ctx context.Context) string {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get(AuthorizationKey) {
+		if strings.HasPrefix(value, BearerPrefix) {
+			return strings.TrimPrefix(value, BearerPrefix)
+		}
+	}
+	return ""
+}
+
+// the per rpc credentials of a static token
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+// new the per rpc credentials of a static token,the secure requires the transport security
+func NewTokenCredentials(token string, secure bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{token: token, secure: secure}
+}
+
+func (c *tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{AuthorizationKey: BearerPrefix + c.token}, nil
+}
+
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// the signer of the JWTs of a subject, the token is signed again before it expires
+type TokenSigner struct {
+	secret   string
+	subject  string
+	segment  string
+	ttl      time.Duration
+	token    string
+	expireAt time.Time
+	sync.Mutex
+}
+
+// new a token signer of the subject with the secret
+func NewTokenSigner(secret, subject, segment string, ttl time.Duration) (*TokenSigner, error) {
+
+	if secret == "" || subject == "" {
+		return nil, errors.New("the token secret and subject must be set")
+	}
+	return &TokenSigner{
+		secret:  secret,
+		subject: subject,
+		segment: segment,
+		ttl:     ttl,
+		Mutex:   sync.Mutex{},
+	}, nil
+}
+
+// the current token
+func (s *TokenSigner) Token() (string, error) {
+
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	// sign again when the half of the ttl passed
+	if s.token == "" || (s.ttl > 0 && now.After(s.expireAt)) {
+		token, err := SignToken(s.secret, s.subject, s.segment, s.ttl)
+		if err != nil {
+			return "", fmt.Errorf("sign the token of %s fail:%w", s.subject, err)
+		}
+		s.token = token
+		s.expireAt = now.Add(s.ttl / 2)
+	}
+	return s.token, nil
+}
+
+// the per rpc credentials of the JWTs
+type jwtCredentials struct {
+	signer *TokenSigner
+	secure bool
+}
+
+// new the per rpc credentials signing the JWTs of the subject with the secret
+func NewJWTCredentials(secret, subject, segment string, ttl time.Duration, secure bool) (credentials.PerRPCCredentials, error) {
+
+	signer, err := NewTokenSigner(secret, subject, segment, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &jwtCredentials{signer: signer, secure: secure}, nil
+}
+
+func (c *jwtCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+
+	token, err := c.signer.Token()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{AuthorizationKey: BearerPrefix + token}, nil
+}
+
+func (c *jwtCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
@@ -0,0 +1,79 @@
+package security
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSignToken(t *testing.T) {
+
+	token, err := SignToken("secret", "trade", "dev", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken("secret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "trade" || claims.Segment != "dev" {
+		t.Fatalf("the claims must be trade of dev but %#v", claims)
+	}
+	if _, err = ParseToken("other", token); err != ErrTokenInvalid {
+		t.Fatalf("the token signed with other secret must be invalid but %v", err)
+	}
+
+	expired, _ := SignToken("secret", "trade", "dev", -time.Minute)
+	if _, err = ParseToken("secret", expired); err != nil {
+		t.Fatalf("the token without ttl must never expire but %v", err)
+	}
+	parts := strings.Split(token, ".")
+	if _, err = ParseToken("secret", parts[0]+"."+parts[1]); err != ErrTokenInvalid {
+		t.Fatalf("the token without signature must be invalid but %v", err)
+	}
+}
+
+func TestTokenVerifier_Verify(t *testing.T) {
+
+	v := NewTokenVerifier(map[string]TokenConfig{
+		"dev":      {Tokens: map[string]string{"static-token": "ops"}},
+		AnySegment: {Secret: "secret"},
+	})
+
+	if identity, err := v.Verify("dev", "static-token"); err != nil || identity != "ops" {
+		t.Fatalf("the static token must be ops but %s,%v", identity, err)
+	}
+	if _, err := v.Verify("prod", "static-token"); err == nil {
+		t.Fatal("the static token of dev must be rejected by prod")
+	}
+	token, _ := SignToken("secret", "trade", "prod", time.Minute)
+	if identity, err := v.Verify("prod", token); err != nil || identity != "trade" {
+		t.Fatalf("the jwt must be trade but %s,%v", identity, err)
+	}
+	if _, err := v.Verify("dev", token); err == nil {
+		t.Fatal("the jwt of prod must be rejected by dev")
+	}
+	if _, err := v.Verify("dev", ""); err != ErrTokenMissing {
+		t.Fatalf("the empty token must be missing but %v", err)
+	}
+}
+
+func TestJWTCredentials(t *testing.T) {
+
+	creds, err := NewJWTCredentials("secret", "trade", "dev", time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(md))
+	identity, err := NewTokenVerifier(map[string]TokenConfig{"dev": {Secret: "secret"}}).Verify("dev", TokenFromContext(ctx))
+	if err != nil || identity != "trade" {
+		t.Fatalf("the jwt credentials must be trade but %s,%v", identity, err)
+	}
+}
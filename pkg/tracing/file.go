@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// the otlp json file format of the collector file exporter, each line is a traces request of a batch,
+// the ids are hex strings and the 64 bit integers are decimal strings
+type otlpTraces struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpan `json:"scopeSpans"`
+	SchemaUrl  string           `json:"schemaUrl,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpan struct {
+	Scope     otlpScope  `json:"scope"`
+	Spans     []otlpSpan `json:"spans"`
+	SchemaUrl string     `json:"schemaUrl,omitempty"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceId                string         `json:"traceId"`
+	SpanId                 string         `json:"spanId"`
+	TraceState             string         `json:"traceState,omitempty"`
+	ParentSpanId           string         `json:"parentSpanId,omitempty"`
+	Name                   string         `json:"name"`
+	Kind                   int            `json:"kind"`
+	StartTimeUnixNano      string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano        string         `json:"endTimeUnixNano"`
+	Attributes             []otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int            `json:"droppedAttributesCount,omitempty"`
+	Events                 []otlpEvent    `json:"events,omitempty"`
+	DroppedEventsCount     int            `json:"droppedEventsCount,omitempty"`
+	Links                  []otlpLink     `json:"links,omitempty"`
+	DroppedLinksCount      int            `json:"droppedLinksCount,omitempty"`
+	Status                 otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceId    string         `json:"traceId"`
+	SpanId     string         `json:"spanId"`
+	TraceState string         `json:"traceState,omitempty"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+// the status codes of otlp, unset is 0, ok is 1 and error is 2
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpValues `json:"arrayValue,omitempty"`
+}
+
+type otlpValues struct {
+	Values []otlpValue `json:"values"`
+}
+
+// the exporter writing the spans to the file in the otlp json format
+type fileExporter struct {
+	encoder *json.Encoder
+	sync.Mutex
+}
+
+func newFileExporter(writer io.Writer) *fileExporter {
+	return &fileExporter{encoder: json.NewEncoder(writer), Mutex: sync.Mutex{}}
+}
+
+// ExportSpans writes the spans as a line of the otlp json
+func (e *fileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+
+	if len(spans) == 0 {
+		return nil
+	}
+	type scopeKey struct {
+		resource attribute.Distinct
+		library  instrumentation.Library
+	}
+	traces := otlpTraces{ResourceSpans: make([]*otlpResourceSpans, 0)}
+	resources := make(map[attribute.Distinct]*otlpResourceSpans)
+	scopes := make(map[scopeKey]*otlpScopeSpan)
+	for _, span := range spans {
+		resourceKey := span.Resource().Equivalent()
+		rs, ok := resources[resourceKey]
+		if !ok {
+			rs = &otlpResourceSpans{Resource: otlpResource{Attributes: otlpAttributes(span.Resource().Attributes())}, SchemaUrl: span.Resource().SchemaURL()}
+			resources[resourceKey] = rs
+			traces.ResourceSpans = append(traces.ResourceSpans, rs)
+		}
+		library := span.InstrumentationLibrary()
+		ss, ok := scopes[scopeKey{resourceKey, library}]
+		if !ok {
+			ss = &otlpScopeSpan{Scope: otlpScope{Name: library.Name, Version: library.Version}, SchemaUrl: library.SchemaURL}
+			scopes[scopeKey{resourceKey, library}] = ss
+			rs.ScopeSpans = append(rs.ScopeSpans, ss)
+		}
+		ss.Spans = append(ss.Spans, newOtlpSpan(span))
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	return e.encoder.Encode(traces)
+}
+
+// Shutdown does nothing, the file is closed by the shutdown func of the tracing
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func newOtlpSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+
+	sc := span.SpanContext()
+	traceId, spanId := sc.TraceID(), sc.SpanID()
+	s := otlpSpan{
+		TraceId:                hex.EncodeToString(traceId[:]),
+		SpanId:                 hex.EncodeToString(spanId[:]),
+		TraceState:             sc.TraceState().String(),
+		Name:                   span.Name(),
+		Kind:                   int(span.SpanKind()),
+		StartTimeUnixNano:      strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:        strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:             otlpAttributes(span.Attributes()),
+		DroppedAttributesCount: span.DroppedAttributes(),
+		DroppedEventsCount:     span.DroppedEvents(),
+		DroppedLinksCount:      span.DroppedLinks(),
+		Status:                 otlpStatus{Message: span.Status().Description},
+	}
+	if parent := span.Parent().SpanID(); parent.IsValid() {
+		s.ParentSpanId = hex.EncodeToString(parent[:])
+	}
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = 1
+	case codes.Error:
+		s.Status.Code = 2
+	}
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+	for _, link := range span.Links() {
+		traceId, spanId := link.SpanContext.TraceID(), link.SpanContext.SpanID()
+		s.Links = append(s.Links, otlpLink{
+			TraceId:    hex.EncodeToString(traceId[:]),
+			SpanId:     hex.EncodeToString(spanId[:]),
+			TraceState: link.SpanContext.TraceState().String(),
+			Attributes: otlpAttributes(link.Attributes),
+		})
+	}
+	return s
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []otlpKeyValue {
+
+	if len(attrs) == 0 {
+		return nil
+	}
+	values := make([]otlpKeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		values = append(values, otlpKeyValue{Key: string(kv.Key), Value: newOtlpValue(kv.Value)})
+	}
+	return values
+}
+
+func newOtlpValue(value attribute.Value) otlpValue {
+
+	array := func(size int, element func(i int) otlpValue) otlpValue {
+		values := make([]otlpValue, 0, size)
+		for i := 0; i < size; i++ {
+			values = append(values, element(i))
+		}
+		return otlpValue{ArrayValue: &otlpValues{Values: values}}
+	}
+	switch value.Type() {
+	case attribute.BOOL:
+		v := value.AsBool()
+		return otlpValue{BoolValue: &v}
+	case attribute.INT64:
+		v := strconv.FormatInt(value.AsInt64(), 10)
+		return otlpValue{IntValue: &v}
+	case attribute.FLOAT64:
+		v := value.AsFloat64()
+		return otlpValue{DoubleValue: &v}
+	case attribute.BOOLSLICE:
+		values := value.AsBoolSlice()
+		return array(len(values), func(i int) otlpValue { return newOtlpValue(attribute.BoolValue(values[i])) })
+	case attribute.INT64SLICE:
+		values := value.AsInt64Slice()
+		return array(len(values), func(i int) otlpValue { return newOtlpValue(attribute.Int64Value(values[i])) })
+	case attribute.FLOAT64SLICE:
+		values := value.AsFloat64Slice()
+		return array(len(values), func(i int) otlpValue { return newOtlpValue(attribute.Float64Value(values[i])) })
+	case attribute.STRINGSLICE:
+		values := value.AsStringSlice()
+		return array(len(values), func(i int) otlpValue { return newOtlpValue(attribute.StringValue(values[i])) })
+	default:
+		v := value.Emit()
+		return otlpValue{StringValue: &v}
+	}
+}
@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/busgo/elsa/pkg/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// the text map carrier of the grpc metadata
+type metadataCarrier metadata.MD
+
+// Get returns the value associated with the passed key.
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set stores the key-value pair.
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys lists the keys stored in this carrier.
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// the rpc service and method attributes of the full method
+func methodAttributes(fullMethod string) []attribute.KeyValue {
+
+	name := strings.TrimPrefix(fullMethod, "/")
+	index := strings.LastIndex(name, "/")
+	if index < 0 {
+		return []attribute.KeyValue{semconv.RPCSystemKey.String("grpc"), semconv.RPCMethodKey.String(name)}
+	}
+	return []attribute.KeyValue{
+		semconv.RPCSystemKey.String("grpc"),
+		semconv.RPCServiceKey.String(name[:index]),
+		semconv.RPCMethodKey.String(name[index+1:]),
+	}
+}
+
+// end the span with the rpc error
+func endSpan(span trace.Span, err error) {
+
+	s, _ := status.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(s.Code())))
+	if err != nil {
+		span.SetStatus(codes.Error, s.Message())
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// the net.peer.ip and net.peer.port of the tcp peer, the net.peer.name of the others like the unix socket
+func peerAttributes(addr net.Addr) []attribute.KeyValue {
+
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return []attribute.KeyValue{semconv.NetPeerNameKey.String(addr.String())}
+	}
+	attributes := []attribute.KeyValue{semconv.NetPeerIPKey.String(host)}
+	if number, err := strconv.Atoi(port); err == nil {
+		attributes = append(attributes, semconv.NetPeerPortKey.Int(number))
+	}
+	return attributes
+}
+
+// start the server span with the propagated trace context
+func startServerSpan(ctx context.Context, fullMethod string, attrs []attribute.KeyValue) (context.Context, trace.Span) {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	attributes := append(methodAttributes(fullMethod), attrs...)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		attributes = append(attributes, peerAttributes(p.Addr)...)
+	}
+	return Tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"), trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+}
+
+// start the client span and inject the trace context to the outgoing metadata
+func startClientSpan(ctx context.Context, fullMethod string, attrs []attribute.KeyValue) (context.Context, trace.Span) {
+
+	attributes := append(methodAttributes(fullMethod), attrs...)
+	ctx, span := Tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// the client stream ends the span when the stream finished
+type wrappedClientStream struct {
+	grpc.ClientStream
+	span trace.Span
+	desc *grpc.StreamDesc
+	once sync.Once
+}
+
+func (w *wrappedClientStream) RecvMsg(m interface{}) error {
+	err := w.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		w.once.Do(func() { endSpan(w.span, nil) })
+	} else if err != nil {
+		w.once.Do(func() { endSpan(w.span, err) })
+	} else if !w.desc.ServerStreams {
+		w.once.Do(func() { endSpan(w.span, nil) })
+	}
+	return err
+}
+
+// trace the unary requests with the attributes
+func UnaryServerInterceptor(attrs ...attribute.KeyValue) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startServerSpan(ctx, info.FullMethod, attrs)
+		response, err := handler(ctx, req)
+		endSpan(span, err)
+		return response, err
+	}
+}
+
+// trace the streams with the attributes
+func StreamServerInterceptor(attrs ...attribute.KeyValue) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startServerSpan(ss.Context(), info.FullMethod, attrs)
+		err := handler(srv, middleware.WrapServerStream(ss, ctx))
+		endSpan(span, err)
+		return err
+	}
+}
+
+// trace the unary calls with the attributes
+func UnaryClientInterceptor(attrs ...attribute.KeyValue) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := startClientSpan(ctx, method, attrs)
+		p := new(peer.Peer)
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(p))...)
+		if p.Addr != nil {
+			span.SetAttributes(InstanceKey.String(p.Addr.String()))
+		}
+		endSpan(span, err)
+		return err
+	}
+}
+
+// trace the stream calls with the attributes
+func StreamClientInterceptor(attrs ...attribute.KeyValue) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := startClientSpan(ctx, method, attrs)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err)
+			return nil, err
+		}
+		return &wrappedClientStream{ClientStream: stream, span: span, desc: desc}, nil
+	}
+}
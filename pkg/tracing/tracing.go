@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/busgo/elsa/pkg/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the instrumentation name of the elsa tracers
+const InstrumentationName = "github.com/busgo/elsa"
+
+// the exporter types
+const (
+	NoneExporter   = "none"
+	StdoutExporter = "stdout" // the human readable json of the stdouttrace exporter
+	FileExporter   = "file"   // the otlp json lines readable by the collector otlpjsonfile receiver
+	OtlpExporter   = "otlp"
+)
+
+// the span attribute keys of elsa
+const (
+	SegmentKey  = attribute.Key("elsa.segment")
+	ServiceKey  = attribute.Key("elsa.service")
+	InstanceKey = attribute.Key("elsa.instance")
+)
+
+// the tracing config
+type Config struct {
+	ServiceName string  // the service name of the resource
+	Exporter    string  // none,stdout,file or otlp
+	FilePath    string  // the file path of the file exporter appending the spans in the otlp json lines
+	Endpoint    string  // the otlp grpc collector endpoint
+	SampleRatio float64 // the sample ratio of the root spans, default is 1
+}
+
+// the tracer of elsa
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// init the global tracer provider and the w3c trace context propagator,
+// the shutdown func flushes the spans and closes the exporter
+func Init(config Config) (func(ctx context.Context) error, error) {
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch config.Exporter {
+	case "", NoneExporter:
+		return func(ctx context.Context) error { return nil }, nil
+	case StdoutExporter:
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case FileExporter:
+		if config.FilePath == "" {
+			return nil, errors.New("the file path of the trace file exporter is empty")
+		}
+		file, err := os.OpenFile(config.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, closer = newFileExporter(file), file
+	case OtlpExporter:
+		e, err := otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpoint(config.Endpoint), otlptracegrpc.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	default:
+		return nil, fmt.Errorf("the trace exporter:%s not support", config.Exporter)
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Infof("init the tracer provider service name:%s exporter:%s success", config.ServiceName, config.Exporter)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			_ = closer.Close()
+		}
+		return err
+	}, nil
+}
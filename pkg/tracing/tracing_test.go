@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const testMethod = "/com.busgo.trade.proto.TradeService/Ping"
+
+// test the trace context propagated from the client span to the server span
+func TestInterceptorPropagation(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var clientTraceId trace.TraceID
+	err := UnaryClientInterceptor(ServiceKey.String("trade"))(context.Background(), testMethod, nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			clientTraceId = trace.SpanContextFromContext(ctx).TraceID()
+			md, _ := metadata.FromOutgoingContext(ctx)
+			ctx = metadata.NewIncomingContext(context.Background(), md)
+			_, err := UnaryServerInterceptor(SegmentKey.String("dev"))(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					if trace.SpanContextFromContext(ctx).TraceID() != clientTraceId {
+						t.Fatal("the server span must be in the trace of the client span")
+					}
+					return nil, nil
+				})
+			return err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("the ended spans must be 2 but %d", len(spans))
+	}
+	if spans[0].SpanKind() != trace.SpanKindServer || spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Fatal("the server span must be the child of the client span")
+	}
+}
+
+// test the spans are written in the otlp json
+func TestFileExporter(t *testing.T) {
+
+	buffer := new(bytes.Buffer)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(newFileExporter(buffer)))
+	defer provider.Shutdown(context.Background())
+
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8001}
+	ctx, parent := provider.Tracer(InstrumentationName).Start(context.Background(), "parent")
+	_, span := provider.Tracer(InstrumentationName).Start(ctx, "Ping", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(append(peerAttributes(addr), ServiceKey.String("trade"))...))
+	span.SetStatus(codes.Error, "unavailable")
+	span.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("each batch must be a line but %d", len(lines))
+	}
+	traces := otlpTraces{}
+	if err := json.Unmarshal([]byte(lines[0]), &traces); err != nil {
+		t.Fatal(err)
+	}
+	s := traces.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if len(s.TraceId) != 32 || len(s.SpanId) != 16 || len(s.ParentSpanId) != 16 || s.Kind != 2 || s.Status.Code != 2 {
+		t.Fatalf("the span is not in the otlp json:%s", lines[0])
+	}
+	if traces.ResourceSpans[0].ScopeSpans[0].Scope.Name != InstrumentationName {
+		t.Fatalf("the scope must be the instrumentation name but %s", lines[0])
+	}
+	for _, want := range []string{`{"key":"net.peer.ip","value":{"stringValue":"10.0.0.1"}}`, `{"key":"net.peer.port","value":{"intValue":"8001"}}`} {
+		if !strings.Contains(lines[0], want) {
+			t.Fatalf("the attribute %s is missing in %s", want, lines[0])
+		}
+	}
+}
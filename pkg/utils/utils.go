@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"fmt"
 	"net"
+	"strconv"
 )
 
 const (
@@ -9,20 +11,106 @@ const (
 	LocalHost = "localhost"
 )
 
-// get local ip address
+// get local ip address, the ipv4 address is preferred to the ipv6 address
 func GetLocalIp() string {
 	addresses, err := net.InterfaceAddrs()
 	if err != nil {
 		return "127.0.0.1"
 	}
 
+	if ip := selectIp(addresses, nil); ip != "" {
+		return ip
+	}
+	return "127.0.0.1"
+}
+
+// get the ip address of the network interface like eth0
+func GetIpByInterface(name string) (string, error) {
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	if ip := selectIp(addresses, nil); ip != "" {
+		return ip, nil
+	}
+	return "", fmt.Errorf("the interface:%s has no available ip address", name)
+}
+
+// get the local ip address in the cidr like 10.0.0.0/8 or fd00::/8
+func GetIpByCIDR(cidr string) (string, error) {
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	if ip := selectIp(addresses, ipNet); ip != "" {
+		return ip, nil
+	}
+	return "", fmt.Errorf("no local ip address in the cidr:%s", cidr)
+}
+
+// select the first non loopback ipv4 address or else the global unicast ipv6 address in the network
+func selectIp(addresses []net.Addr, network *net.IPNet) string {
+
+	ipv6 := ""
 	for _, addr := range addresses {
-		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
-			if ipNet.IP.To4() != nil {
-				return ipNet.IP.String()
-			}
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if network != nil && !network.Contains(ipNet.IP) {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+		// the link local address requires the zone
+		if ipv6 == "" && ipNet.IP.IsGlobalUnicast() {
+			ipv6 = ipNet.IP.String()
 		}
 	}
+	return ipv6
+}
 
-	return "127.0.0.1"
+// join the host and the port, the ipv6 host is enclosed in the square brackets like [::1]:8001
+func JoinHostPort(host string, port int32) string {
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// check the host of the endpoint like 127.0.0.1:8005 or [::1]:8005 is the local host
+func IsLocalEndpoint(endpoint string) bool {
+
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	if host == LocalHost {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addresses {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
 }
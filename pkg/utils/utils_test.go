@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestJoinHostPort(t *testing.T) {
+
+	if address := JoinHostPort("192.168.1.1", 8001); address != "192.168.1.1:8001" {
+		t.Fatalf("the ipv4 address must be 192.168.1.1:8001 but %s", address)
+	}
+	if address := JoinHostPort("fd00::1", 8001); address != "[fd00::1]:8001" {
+		t.Fatalf("the ipv6 address must be [fd00::1]:8001 but %s", address)
+	}
+}
+
+func TestIsLocalEndpoint(t *testing.T) {
+
+	for _, endpoint := range []string{"127.0.0.1:8005", "[::1]:8005", "localhost:8005"} {
+		if !IsLocalEndpoint(endpoint) {
+			t.Fatalf("the endpoint:%s must be local", endpoint)
+		}
+	}
+	if IsLocalEndpoint("192.0.2.1:8005") {
+		t.Fatal("the endpoint:192.0.2.1:8005 must not be local")
+	}
+}
+
+func TestSelectIp(t *testing.T) {
+
+	addresses := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("fd00::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)},
+		&net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(8, 32)},
+	}
+	if ip := selectIp(addresses, nil); ip != "172.17.0.2" {
+		t.Fatalf("the first ipv4 address must be selected but %s", ip)
+	}
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	if ip := selectIp(addresses, network); ip != "10.1.2.3" {
+		t.Fatalf("the ip in the cidr must be 10.1.2.3 but %s", ip)
+	}
+	_, network, _ = net.ParseCIDR("fd00::/8")
+	if ip := selectIp(addresses, network); ip != "fd00::1" {
+		t.Fatalf("the ipv6 address in the cidr must be fd00::1 but %s", ip)
+	}
+}